@@ -0,0 +1,71 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	riffList = "LIST"
+	riffDate = "IDIT"
+)
+
+var aviMagic = []byte("AVI ")
+
+func isAVI(buf []byte) bool {
+	return len(buf) >= 12 && bytes.HasPrefix(buf, []byte("RIFF")) && bytes.Equal(buf[8:12], aviMagic)
+}
+
+// aviTime gives the capture time stored by cameras in the IDIT chunk of the
+// header list, either as a ctime string or in the Exif layout.
+func aviTime(r io.ReaderAt, size int64) (time.Time, error) {
+	data, err := findRiff(r, 12, size, riffDate, 0)
+	if err != nil {
+		return time.Time{}, err
+	}
+	str := strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+	for _, layout := range []string{time.ANSIC, "2006:01:02 15:04:05", "2006/01/02 15:04:05"} {
+		if when, err := time.Parse(layout, str); err == nil {
+			return when, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s: %q: %w", riffDate, str, ErrFormat)
+}
+
+// findRiff gives the content of the first chunk of the given type found
+// between at and end, looking into the lists of chunks of the header. The
+// movie data are skipped without being read.
+func findRiff(r io.ReaderAt, at, end int64, typ string, depth int) ([]byte, error) {
+	head := make([]byte, 12)
+	for at+8 <= end && depth < 8 {
+		n, err := r.ReadAt(head, at)
+		if n < 8 {
+			if err == nil || err == io.EOF {
+				err = fmt.Errorf("%w: chunk %s", ErrNotFound, typ)
+			}
+			return nil, err
+		}
+		var (
+			id   = string(head[:4])
+			size = int64(binary.LittleEndian.Uint32(head[4:]))
+		)
+		if at+8+size > end {
+			size = end - at - 8
+		}
+		if id == typ {
+			return ioutil.ReadAll(io.NewSectionReader(r, at+8, size))
+		}
+		if id == riffList && n >= 12 && size >= 4 && string(head[8:12]) != "movi" {
+			if found, err := findRiff(r, at+12, at+8+size, typ, depth+1); err == nil {
+				return found, nil
+			}
+		}
+		at += 8 + size + size&1
+	}
+	return nil, fmt.Errorf("%w: chunk %s", ErrNotFound, typ)
+}
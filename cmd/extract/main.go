@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"image/jpeg"
@@ -10,64 +11,156 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/midbel/exif/mov"
 	"github.com/midbel/exif/nef"
 )
 
+const (
+	ExtJPG = ".jpg"
+	ExtDAT = ".dat"
+)
+
+type options struct {
+	focus   bool
+	af      nef.AFInfo
+	preview *nef.File
+	rotate  bool
+	orient  uint32
+	meta    *nef.File
+}
+
 func main() {
-	dir := flag.String("d", "", "directory")
+	var (
+		dir    = flag.String("d", "", "directory")
+		focus  = flag.Bool("f", false, "draw focus points on the full size preview")
+		rotate = flag.Bool("r", false, "rotate extracted previews upright")
+		meta   = flag.Bool("m", false, "embed exif and gps metadata in extracted previews")
+	)
 	flag.Parse()
 	for _, a := range flag.Args() {
-		if err := extract(a, *dir); err != nil {
+		if err := extract(a, *dir, *focus, *rotate, *meta); err != nil {
 			fmt.Fprintf(os.Stdout, "%s: %s\n", a, err)
 		}
 	}
 }
 
-func extract(file, dir string) error {
+func extract(file, dir string, focus, rotate, meta bool) error {
 	dir, err := mkdir(dir, file)
 	if err != nil {
 		return err
 	}
-	r, err := os.Open(file)
+	buf, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
-	defer r.Close()
-
-	files, err := nef.Decode(r)
+	files, err := decode(buf)
 	if err != nil {
 		return err
 	}
+	var opt options
+	if focus && len(files) > 0 {
+		opt.af, err = files[0].AFInfo()
+		if err != nil && !errors.Is(err, nef.ErrExist) {
+			return err
+		}
+		opt.preview = fullPreview(files)
+		opt.focus = err == nil && opt.preview != nil
+	}
+	if rotate && len(files) > 0 {
+		opt.rotate = true
+		opt.orient = files[0].Orientation()
+	}
+	if meta && len(files) > 0 {
+		opt.meta = files[0]
+	}
 	for i := range files {
-		if err := extractImages(files[i], dir); err != nil {
+		if err := extractImages(files[i], dir, opt); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func writeImage(f *nef.File) ([]byte, error) {
+func decode(buf []byte) ([]*nef.File, error) {
+	if mov.IsCR3(buf) {
+		f, err := mov.ReadCR3(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			return nil, err
+		}
+		return []*nef.File{f}, nil
+	}
+	return nef.Decode(bytes.NewReader(buf))
+}
+
+func writeImage(f *nef.File, opt options) ([]byte, error) {
 	img, err := f.Image()
 	if err != nil {
 		return nil, err
 	}
+	if opt.focus && f == opt.preview {
+		img = nef.DrawFocus(img, opt.af)
+	}
+	if opt.rotate {
+		img = nef.Orient(img, opt.orient)
+	}
 	var buf bytes.Buffer
-	err = jpeg.Encode(&buf, img, nil)
-	return buf.Bytes(), err
+	if err = jpeg.Encode(&buf, img, nil); err != nil || opt.meta == nil {
+		return buf.Bytes(), err
+	}
+	orient := opt.meta.Orientation()
+	if opt.rotate {
+		orient = nef.OrientNormal
+	}
+	return embedMetadata(buf.Bytes(), img, opt.meta, orient)
+}
+
+// fullPreview gives the largest JPEG preview of the files, the only one whose
+// frame matches the one of the focus points.
+func fullPreview(files []*nef.File) *nef.File {
+	var (
+		preview *nef.File
+		size    uint64
+		walk    func([]*nef.File)
+	)
+	walk = func(files []*nef.File) {
+		for _, f := range files {
+			t, err := f.GetTag(nef.JpegFromRawLength, nef.Tiff)
+			if err == nil && f.IsJpeg() && t.Uint64() > size {
+				preview, size = f, t.Uint64()
+			}
+			walk(f.Files)
+		}
+	}
+	walk(files)
+	return preview
 }
 
 func writeBytes(f *nef.File) ([]byte, error) {
 	return f.Bytes()
 }
 
-func extractImages(f *nef.File, dir string) error {
+func extractImages(f *nef.File, dir string, opt options) error {
+	if f.IsJpeg() || f.IsRaw() {
+		if err := extractImage(f, dir, opt); err != nil {
+			return err
+		}
+	}
+	for i := range f.Files {
+		if err := extractImages(f.Files[i], dir, opt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractImage(f *nef.File, dir string, opt options) error {
 	var (
 		buf []byte
 		err error
 		ext string
 	)
 	if f.IsSupported() {
-		buf, err = writeImage(f)
+		buf, err = writeImage(f, opt)
 		ext = ExtJPG
 	} else {
 		buf, err = writeBytes(f)
@@ -81,11 +174,6 @@ func extractImages(f *nef.File, dir string) error {
 		return err
 	}
 	fmt.Printf("extracted %s (%d KB) from %s\n", file, len(buf)>>10, f.Directory())
-	for i := range f.Files {
-		if err := extractImages(f.Files[i], dir); err != nil {
-			return err
-		}
-	}
 	return nil
 }
 
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+
+	"github.com/midbel/exif/nef"
+)
+
+const (
+	tiffOrientation    = 0x112
+	tiffXResolution    = 0x11a
+	tiffYResolution    = 0x11b
+	tiffResolutionUnit = 0x128
+	exifPixelX         = 0xa002
+	exifPixelY         = 0xa003
+	exifInterop        = 0xa005
+)
+
+const (
+	compressionOJPEG = 6
+	resolutionInch   = 2
+	thumbSize        = 160
+)
+
+// layoutTags are the tags describing the image data of a directory of the NEF.
+// They do not apply to the extracted images.
+var layoutTags = map[uint16]bool{
+	nef.NewSubfileType:      true,
+	nef.ImageWidth:          true,
+	nef.ImageLength:         true,
+	nef.BitsPerSample:       true,
+	nef.Compression:         true,
+	nef.Photometric:         true,
+	nef.StripOffsets:        true,
+	nef.SamplesPerPixel:     true,
+	nef.RowsPerStrip:        true,
+	nef.StripByteCounts:     true,
+	nef.PlanarConfiguration: true,
+	nef.TileWidth:           true,
+	nef.TileLength:          true,
+	nef.TileOffsets:         true,
+	nef.TileByteCounts:      true,
+	nef.Nef:                 true,
+	nef.JpegFromRawStart:    true,
+	nef.JpegFromRawLength:   true,
+	nef.CFARepeatPatternDim: true,
+	nef.CFAPattern:          true,
+	nef.Exif:                true,
+	nef.Gps:                 true,
+}
+
+// embedMetadata adds to an extracted image the tags of the main directory of
+// the NEF and its Exif and GPS tags. The maker notes are left out to keep the
+// segment under the 64KB limit of JPEG. A thumbnail of the image is stored in
+// the second directory.
+func embedMetadata(buf []byte, img image.Image, root *nef.File, orient uint32) ([]byte, error) {
+	var (
+		order = root.ByteOrder()
+		rect  = img.Bounds()
+		ifd0  = nef.NewFile(order, nil)
+	)
+	for _, t := range root.TagsFor(nef.Tiff) {
+		if !layoutTags[t.Id] {
+			ifd0.SetTag(t, nef.Tiff)
+		}
+	}
+	ifd0.SetTag(nef.NewTag(tiffOrientation, nef.Short, order, uint64(orient)), nef.Tiff)
+	for _, t := range root.TagsFor(nef.Exif) {
+		if t.Id != nef.Note && t.Id != exifInterop {
+			ifd0.SetTag(t, nef.Exif)
+		}
+	}
+	ifd0.SetTag(nef.NewTag(exifPixelX, nef.Long, order, uint64(rect.Dx())), nef.Exif)
+	ifd0.SetTag(nef.NewTag(exifPixelY, nef.Long, order, uint64(rect.Dy())), nef.Exif)
+	for _, t := range root.TagsFor(nef.Gps) {
+		ifd0.SetTag(t, nef.Gps)
+	}
+
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, scaleImage(img, thumbSize), nil); err != nil {
+		return nil, err
+	}
+	next := nef.NewFile(order, thumb.Bytes(),
+		nef.NewTag(nef.Compression, nef.Short, order, compressionOJPEG),
+		nef.NewRationals(tiffXResolution, nef.Rational, order, 1, 72),
+		nef.NewRationals(tiffYResolution, nef.Rational, order, 1, 72),
+		nef.NewTag(tiffResolutionUnit, nef.Short, order, resolutionInch),
+		nef.NewTag(nef.JpegFromRawStart, nef.Long, order, 0),
+		nef.NewTag(nef.JpegFromRawLength, nef.Long, order, uint64(thumb.Len())),
+	)
+	return nef.EmbedExif(buf, []*nef.File{ifd0, next})
+}
+
+// scaleImage reduces an image to fit in a square of the given size, picking
+// the nearest pixel.
+func scaleImage(img image.Image, size int) image.Image {
+	var (
+		rect = img.Bounds()
+		w, h = rect.Dx(), rect.Dy()
+	)
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		w, h = size, h*size/w
+	} else {
+		w, h = w*size/h, size
+	}
+	if w == 0 {
+		w = 1
+	}
+	if h == 0 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(x, y, img.At(rect.Min.X+x*rect.Dx()/w, rect.Min.Y+y*rect.Dy()/h))
+		}
+	}
+	return dst
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 
@@ -50,24 +52,93 @@ func main() {
 }
 
 func readFile(file string) error {
-	r, err := os.Open(file)
+	buf, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
-	defer r.Close()
-
-	files, err := nef.Decode(r)
-	if err == nil {
-		for i := range files {
-			if i > 0 {
-				fmt.Println("===")
+	if nef.IsJPEG(buf) {
+		j, err := nef.DecodeJPEG(bytes.NewReader(buf))
+		if err == nil {
+			if j.ExifErr != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", file, j.ExifErr)
+			}
+			listFiles(j.Files)
+			listSegments(j)
+		}
+		return err
+	}
+	if nef.IsPNG(buf) {
+		p, err := nef.DecodePNG(bytes.NewReader(buf))
+		if err == nil {
+			listFiles(p.Files)
+			listChunks("png", p.Width, p.Height, p.Chunks, p.XMP, nil)
+			for k, v := range p.Texts {
+				fmt.Printf("text: %s: %s", k, v)
+				fmt.Println()
 			}
-			listTagsFromFile(files[i])
 		}
+		return err
+	}
+	if nef.IsWebP(buf) {
+		w, err := nef.DecodeWebP(bytes.NewReader(buf))
+		if err == nil {
+			listFiles(w.Files)
+			listChunks("webp", w.Width, w.Height, w.Chunks, w.XMP, w.ICC)
+		}
+		return err
+	}
+	files, err := nef.Decode(bytes.NewReader(buf))
+	if err == nil {
+		listFiles(files)
 	}
 	return err
 }
 
+func listFiles(files []*nef.File) {
+	for i := range files {
+		if i > 0 {
+			fmt.Println("===")
+		}
+		listTagsFromFile(files[i])
+	}
+}
+
+func listSegments(j *nef.JPEG) {
+	fmt.Println("===")
+	fmt.Printf("jpeg: %dx%d, %d segments", j.Width, j.Height, len(j.Segments))
+	fmt.Println()
+	if len(j.XMP) > 0 {
+		fmt.Printf("xmp: %d bytes", len(j.XMP))
+		fmt.Println()
+	}
+	if len(j.ICC) > 0 {
+		fmt.Printf("icc: %d bytes", len(j.ICC))
+		fmt.Println()
+	}
+	if len(j.Photoshop) > 0 {
+		fmt.Printf("photoshop: %d bytes", len(j.Photoshop))
+		fmt.Println()
+	}
+	for _, c := range j.Comments {
+		fmt.Printf("comment: %s", c)
+		fmt.Println()
+	}
+}
+
+func listChunks(kind string, width, height int, chunks []nef.Chunk, xmp, icc []byte) {
+	fmt.Println("===")
+	fmt.Printf("%s: %dx%d, %d chunks", kind, width, height, len(chunks))
+	fmt.Println()
+	if len(xmp) > 0 {
+		fmt.Printf("xmp: %d bytes", len(xmp))
+		fmt.Println()
+	}
+	if len(icc) > 0 {
+		fmt.Printf("icc: %d bytes", len(icc))
+		fmt.Println()
+	}
+}
+
 const pat = "%s: %03d) id: %32s (0x%04x), source: %6s, type: %12s, len: %6d, offset: %12d, values: %v"
 
 func listTagsFromFile(f *nef.File) {
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"math/bits"
+	"strings"
 
 	"github.com/midbel/exif/nef"
 )
@@ -28,11 +29,11 @@ var notes = map[uint16]Value{
 	0x1c: makeValue("ExposureTuning", nil),
 	0x1d: makeValue("SerialNumber", nil),
 	0x1e: makeValue("ColorSpace", nil),
-	0x1f: makeValue("VRInfo", nil),
+	0x1f: makeValue("VRInfo", vrInfo),
 	0x22: makeValue("ActiveD-Lighting", nil),
-	0x23: makeValue("PictureControlData", nil),
+	0x23: makeValue("PictureControlData", pictureControl),
 	0x24: makeValue("WorldTime", nil),
-	0x25: makeValue("ISOInfo", nil),
+	0x25: makeValue("ISOInfo", isoInfo),
 	0x2a: makeValue("VignetteControl", nil),
 	0x2b: makeValue("DistortInfo", nil),
 	0x2c: makeValue("UnknownInfo", nil),
@@ -52,21 +53,30 @@ var notes = map[uint16]Value{
 	0x97: makeValue("ColorBalance", nil),
 	0x98: makeValue("LensData", nil),
 	0x99: makeValue("RawImageCenter", nil),
-	0x9e: makeValue("RetouchHistory", nil),
+	0x9e: makeValue("RetouchHistory", retouchHistory),
 	0xa3: makeValue("<unknown>", nil),
 	0xa4: makeValue("<unknown>", nil),
 	0xa7: makeValue("ShutterCount", nil),
 	0xa8: makeValue("FlashInfo", nil),
-	0xb0: makeValue("MultiExposure", nil),
-	0xb1: makeValue("HighISONoiseReduction", nil),
-	0xb6: makeValue("PowerUpTime", nil),
-	0xb7: makeValue("AFInfo2", nil),
-	0xb8: makeValue("FileInfo", nil),
+	0xb0: makeValue("MultiExposure", multiExposure),
+	0xb1: makeValue("HighISONoiseReduction", highISONoiseReduction),
+	0xb6: makeValue("PowerUpTime", powerUpTime),
+	0xb7: makeValue("AFInfo2", afInfo),
+	0xb8: makeValue("FileInfo", fileInfo),
 	0xb9: makeValue("AFTune", nil),
 	0xba: makeValue("<unknown>", nil),
-	0xbb: makeValue("RetouchInfo", nil),
+	0xbb: makeValue("RetouchInfo", retouchInfo),
 	0xbc: makeValue("<unknown>", nil),
 	0xbf: makeValue("<unknown>", nil),
+
+	0xe01: makeValue("NikonCaptureData", captureData),
+	0xe09: makeValue("NikonCaptureVersion", nil),
+	0xe0e: makeValue("NikonCaptureOffsets", nil),
+	0xe10: makeValue("NikonScanIFD", nil),
+	0xe13: makeValue("NikonCaptureEditVersions", nil),
+	0xe1d: makeValue("NikonICCProfile", nil),
+	0xe1e: makeValue("NikonCaptureOutput", nil),
+	0xe22: makeValue("NEFBitDepth", nil),
 }
 
 func makerNoteVersion(t nef.Tag) interface{} {
@@ -97,6 +107,105 @@ func lensType(t nef.Tag) interface{} {
 	return ""
 }
 
+func afInfo(t nef.Tag) interface{} {
+	a, err := nef.DecodeAFInfo(t)
+	if err != nil {
+		return err
+	}
+	return a
+}
+
+func pictureControl(t nef.Tag) interface{} {
+	p, err := nef.DecodePictureControl(t)
+	if err != nil {
+		return err
+	}
+	return p
+}
+
+func vrInfo(t nef.Tag) interface{} {
+	v, err := nef.DecodeVRInfo(t)
+	if err != nil {
+		return err
+	}
+	return v
+}
+
+func isoInfo(t nef.Tag) interface{} {
+	i, err := nef.DecodeISOInfo(t)
+	if err != nil {
+		return err
+	}
+	return i
+}
+
+func fileInfo(t nef.Tag) interface{} {
+	i, err := nef.DecodeFileInfo(t)
+	if err != nil {
+		return err
+	}
+	return i
+}
+
+func multiExposure(t nef.Tag) interface{} {
+	m, err := nef.DecodeMultiExposure(t)
+	if err != nil {
+		return err
+	}
+	return m
+}
+
+func highISONoiseReduction(t nef.Tag) interface{} {
+	n, err := nef.DecodeHighISONoiseReduction(t)
+	if err != nil {
+		return err
+	}
+	return n
+}
+
+func powerUpTime(t nef.Tag) interface{} {
+	when, err := nef.DecodePowerUpTime(t)
+	if err != nil {
+		return err
+	}
+	return when.Format("2006-01-02 15:04:05")
+}
+
+func retouchHistory(t nef.Tag) interface{} {
+	list, err := nef.DecodeRetouchHistory(t)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return "none"
+	}
+	str := make([]string, len(list))
+	for i := range list {
+		str[i] = list[i].String()
+	}
+	return strings.Join(str, ", ")
+}
+
+func retouchInfo(t nef.Tag) interface{} {
+	r, err := nef.DecodeRetouchInfo(t)
+	if err != nil {
+		return err
+	}
+	return fmt.Sprintf("%s (nef processing: %t)", r.Version, r.NEFProcessing)
+}
+
+func captureData(t nef.Tag) interface{} {
+	list, err := nef.DecodeCaptureData(t)
+	if err != nil {
+		return err
+	}
+	str := make([]string, len(list))
+	for i := range list {
+		str[i] = list[i].Name()
+	}
+	return strings.Join(str, ", ")
+}
+
 func nikonCompression(t nef.Tag) interface{} {
 	switch t.Uint() {
 	case 1:
@@ -27,7 +27,14 @@ var tiff = map[uint16]Value{
 	0x131:  makeValue("Software", nil),
 	0x132:  makeValue("DateTime", nil),
 	0x13b:  makeValue("Artist", nil),
+	0x13d:  makeValue("Predictor", nil),
+	0x142:  makeValue("TileWidth", imagePixels),
+	0x143:  makeValue("TileLength", imagePixels),
+	0x144:  makeValue("TileOffsets", nil),
+	0x145:  makeValue("TileByteCounts", nil),
 	0x14a:  makeValue("SubIFDS", nil),
+	0x153:  makeValue("SampleFormat", nil),
+	0x15b:  makeValue("JPEGTables", nil),
 	0x201:  makeValue("JpegFromRawStart", nil),
 	0x202:  makeValue("JpegFromRawLength", nil),
 	0x213:  makeValue("YCbCrPositioning", ycbcrPositioning),
@@ -41,6 +48,40 @@ var tiff = map[uint16]Value{
 	0x9003: makeValue("DateTimeOriginal", nil),
 	0x9216: makeValue("EPStandardID", nil),
 	0x9217: makeValue("SensingMethod", nil),
+	0xc612: makeValue("DNGVersion", nil),
+	0xc613: makeValue("DNGBackwardVersion", nil),
+	0xc614: makeValue("UniqueCameraModel", nil),
+	0xc619: makeValue("BlackLevelRepeatDim", nil),
+	0xc61a: makeValue("BlackLevel", nil),
+	0xc61b: makeValue("BlackLevelDeltaH", nil),
+	0xc61c: makeValue("BlackLevelDeltaV", nil),
+	0xc61d: makeValue("WhiteLevel", nil),
+	0xc61f: makeValue("DefaultCropOrigin", nil),
+	0xc620: makeValue("DefaultCropSize", nil),
+	0xc621: makeValue("ColorMatrix1", nil),
+	0xc622: makeValue("ColorMatrix2", nil),
+	0xc623: makeValue("CameraCalibration1", nil),
+	0xc624: makeValue("CameraCalibration2", nil),
+	0xc627: makeValue("AnalogBalance", nil),
+	0xc628: makeValue("AsShotNeutral", nil),
+	0xc62a: makeValue("BaselineExposure", nil),
+	0xc635: makeValue("MakerNoteSafety", nil),
+	0xc65a: makeValue("CalibrationIlluminant1", nil),
+	0xc65b: makeValue("CalibrationIlluminant2", nil),
+	0xc68d: makeValue("ActiveArea", nil),
+	0xc714: makeValue("ForwardMatrix1", nil),
+	0xc715: makeValue("ForwardMatrix2", nil),
+	0xc740: makeValue("OpcodeList1", opcodeList),
+	0xc741: makeValue("OpcodeList2", opcodeList),
+	0xc74e: makeValue("OpcodeList3", opcodeList),
+}
+
+func opcodeList(t nef.Tag) interface{} {
+	list, err := nef.DecodeOpcodeList(t)
+	if err != nil {
+		return err
+	}
+	return list
 }
 
 func subfileType(t nef.Tag) interface{} {
@@ -58,8 +99,16 @@ func tiffCompression(t nef.Tag) interface{} {
 	switch t.Uint() {
 	case 1:
 		return "uncompressed"
+	case 5:
+		return "lzw"
 	case 6:
+		return "jpeg (old style)"
+	case 7:
 		return "jpeg"
+	case 8, 32946:
+		return "deflate"
+	case 32773:
+		return "packbits"
 	case 34713:
 		return "nikon nef compressed"
 	default:
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/midbel/exif/nef"
+	"github.com/midbel/exif/nef/develop"
+)
+
+const (
+	ExtDNG   = ".dng"
+	tileSize = 256
+)
+
+const (
+	subfileMain    = 0
+	subfilePreview = 1
+	tiffModel      = 0x110
+)
+
+var ErrMatrix = errors.New("no colour matrix for model")
+
+func main() {
+	var (
+		dir      = flag.String("d", "", "directory")
+		compress = flag.Bool("c", false, "compress raw data with lossless jpeg")
+	)
+	flag.Parse()
+	for _, a := range flag.Args() {
+		if err := convert(a, *dir, *compress); err != nil {
+			fmt.Fprintf(os.Stdout, "%s: %s\n", a, err)
+		}
+	}
+}
+
+func convert(file, dir string, compress bool) error {
+	files, err := nef.DecodeFile(file)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nef.ErrImage
+	}
+	root := files[0]
+	raw, err := develop.Find(root)
+	if err != nil {
+		return err
+	}
+	params, err := develop.ReadParams(root, raw)
+	if err != nil {
+		return err
+	}
+	model, err := root.GetTag(tiffModel, nef.Tiff)
+	if err != nil {
+		return fmt.Errorf("model: %w", err)
+	}
+	if params.Matrix == [9]float64{} {
+		return fmt.Errorf("%s: %w", strings.TrimSpace(model.String()), ErrMatrix)
+	}
+
+	cfa, err := rawFile(raw, params, compress)
+	if err != nil {
+		return err
+	}
+	dng, err := mainFile(root, model, params)
+	if err != nil {
+		return err
+	}
+	dng.Files = append(dng.Files, cfa)
+	for _, f := range root.Files {
+		if !f.IsJpeg() {
+			continue
+		}
+		p, err := previewFile(f, dng.ByteOrder())
+		if err != nil {
+			return err
+		}
+		dng.Files = append(dng.Files, p)
+	}
+
+	if dir == "" {
+		dir = filepath.Dir(file)
+	}
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) + ExtDNG
+	return nef.EncodeFile(filepath.Join(dir, name), []*nef.File{dng})
+}
+
+// mainFile gives the main directory of the DNG: the thumbnail of the NEF, its
+// tags, its Exif and GPS directories and the colour tags of DNG.
+func mainFile(root *nef.File, model nef.Tag, params develop.Params) (*nef.File, error) {
+	var (
+		order = root.ByteOrder()
+		data  []byte
+		err   error
+	)
+	if root.IsRaw() {
+		if data, err = root.Bytes(); err != nil {
+			return nil, err
+		}
+	}
+	f := nef.NewFile(order, data)
+	for _, t := range root.TagsFor(nef.Tiff) {
+		switch t.Id {
+		case nef.Nef, nef.Exif, nef.Gps:
+		default:
+			f.SetTag(t, nef.Tiff)
+		}
+	}
+	for _, t := range root.TagsFor(nef.Exif) {
+		f.SetTag(t, nef.Exif)
+	}
+	for _, t := range root.TagsFor(nef.Gps) {
+		f.SetTag(t, nef.Gps)
+	}
+	if root.IsRaw() {
+		f.SetTag(nef.NewTag(nef.StripOffsets, nef.Long, order, 0), nef.Tiff)
+		f.SetTag(nef.NewTag(nef.StripByteCounts, nef.Long, order, uint64(len(data))), nef.Tiff)
+		f.SetTag(nef.NewTag(nef.RowsPerStrip, nef.Long, order, 1<<32-1), nef.Tiff)
+	}
+	var (
+		neutral = make([]float64, 3)
+		matrix  = params.Matrix
+	)
+	for c, m := range params.Multipliers {
+		neutral[c] = 1
+		if m > 0 {
+			neutral[c] = 1 / m
+		}
+	}
+	tags := []nef.Tag{
+		nef.NewTag(nef.NewSubfileType, nef.Long, order, subfilePreview),
+		nef.NewBytes(nef.DNGVersion, nef.Byte, []byte{1, 4, 0, 0}),
+		nef.NewBytes(nef.DNGBackwardVersion, nef.Byte, []byte{1, 1, 0, 0}),
+		nef.NewString(nef.UniqueCameraModel, strings.TrimSpace(model.String())),
+		nef.NewRationals(nef.ColorMatrix1, nef.SRational, order, 10000, matrix[:]...),
+		nef.NewTag(nef.CalibrationIlluminant1, nef.Short, order, nef.IlluminantD65),
+		nef.NewRationals(nef.AsShotNeutral, nef.Rational, order, 1000000, neutral...),
+		nef.NewTag(nef.MakerNoteSafety, nef.Short, order, 1),
+	}
+	for _, t := range tags {
+		f.SetTag(t, nef.Tiff)
+	}
+	return f, nil
+}
+
+// rawFile gives the directory holding the sensor data, written as 16 bits
+// samples in a single strip or compressed with lossless JPEG in tiles.
+func rawFile(raw *nef.File, params develop.Params, compress bool) (*nef.File, error) {
+	img, err := raw.RawImage()
+	if err != nil {
+		return nil, err
+	}
+	cfa, err := raw.CFA()
+	if err != nil {
+		return nil, err
+	}
+	bits, err := raw.GetTag(nef.BitsPerSample, nef.Tiff)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		order  = raw.ByteOrder()
+		rect   = img.Bounds()
+		width  = uint64(rect.Dx())
+		height = uint64(rect.Dy())
+		tags   []nef.Tag
+		data   []byte
+	)
+	if compress {
+		data, tags, err = compressTiles(img, int(bits.Uint()), order)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data = make([]byte, 0, rect.Dx()*rect.Dy()*2)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				data = append(data, 0, 0)
+				order.PutUint16(data[len(data)-2:], img.Gray16At(x, y).Y)
+			}
+		}
+		tags = []nef.Tag{
+			nef.NewTag(nef.Compression, nef.Short, order, nef.CompressionNone),
+			nef.NewTag(nef.StripOffsets, nef.Long, order, 0),
+			nef.NewTag(nef.RowsPerStrip, nef.Long, order, height),
+			nef.NewTag(nef.StripByteCounts, nef.Long, order, uint64(len(data))),
+		}
+	}
+	colors := make([]uint64, len(cfa.Colors))
+	for i, c := range cfa.Colors {
+		colors[i] = uint64(c)
+	}
+	black := params.Black
+	if len(black) != cfa.Width*cfa.Height {
+		black = make([]float64, cfa.Width*cfa.Height)
+		for i := 0; i < len(black) && len(params.Black) > 0; i++ {
+			black[i] = params.Black[0]
+		}
+	}
+	tags = append(tags,
+		nef.NewTag(nef.NewSubfileType, nef.Long, order, subfileMain),
+		nef.NewTag(nef.ImageWidth, nef.Long, order, width),
+		nef.NewTag(nef.ImageLength, nef.Long, order, height),
+		nef.NewTag(nef.BitsPerSample, nef.Short, order, 16),
+		nef.NewTag(nef.Photometric, nef.Short, order, uint64(nef.ImgCFA)),
+		nef.NewTag(nef.SamplesPerPixel, nef.Short, order, 1),
+		nef.NewTag(nef.PlanarConfiguration, nef.Short, order, 1),
+		nef.NewTag(nef.CFARepeatPatternDim, nef.Short, order, uint64(cfa.Height), uint64(cfa.Width)),
+		nef.NewTag(nef.CFAPattern, nef.Byte, order, colors...),
+		nef.NewTag(nef.BlackLevelRepeatDim, nef.Short, order, uint64(cfa.Height), uint64(cfa.Width)),
+		nef.NewRationals(nef.BlackLevel, nef.Rational, order, 100, black...),
+		nef.NewTag(nef.WhiteLevel, nef.Long, order, uint64(params.White)),
+		nef.NewTag(nef.DefaultCropOrigin, nef.Long, order, 0, 0),
+		nef.NewTag(nef.DefaultCropSize, nef.Long, order, width, height),
+	)
+	return nef.NewFile(order, data, tags...), nil
+}
+
+// compressTiles compresses the sensor data in tiles of lossless JPEG. Each row
+// of a tile is encoded as two interleaved components so that samples are
+// predicted from the previous sample of the same colour.
+func compressTiles(img *image.Gray16, bits int, order binary.ByteOrder) ([]byte, []nef.Tag, error) {
+	var (
+		rect    = img.Bounds()
+		data    []byte
+		offsets []uint64
+		counts  []uint64
+		samples = make([]uint16, tileSize*tileSize)
+	)
+	for ty := rect.Min.Y; ty < rect.Max.Y; ty += tileSize {
+		for tx := rect.Min.X; tx < rect.Max.X; tx += tileSize {
+			for y := 0; y < tileSize; y++ {
+				for x := 0; x < tileSize; x++ {
+					px, py := tx+x, ty+y
+					if px >= rect.Max.X {
+						px = rect.Max.X - 1
+					}
+					if py >= rect.Max.Y {
+						py = rect.Max.Y - 1
+					}
+					samples[y*tileSize+x] = img.Gray16At(px, py).Y
+				}
+			}
+			buf, err := nef.EncodeLossless(samples, tileSize/2, tileSize, 2, bits)
+			if err != nil {
+				return nil, nil, err
+			}
+			offsets = append(offsets, uint64(len(data)))
+			counts = append(counts, uint64(len(buf)))
+			data = append(data, buf...)
+		}
+	}
+	tags := []nef.Tag{
+		nef.NewTag(nef.Compression, nef.Short, order, nef.CompressionJPEG),
+		nef.NewTag(nef.TileWidth, nef.Long, order, tileSize),
+		nef.NewTag(nef.TileLength, nef.Long, order, tileSize),
+		nef.NewTag(nef.TileOffsets, nef.Long, order, offsets...),
+		nef.NewTag(nef.TileByteCounts, nef.Long, order, counts...),
+	}
+	return data, tags, nil
+}
+
+// previewFile gives a directory holding a JPEG preview of the NEF, stored in
+// a single strip as expected by DNG readers.
+func previewFile(f *nef.File, order binary.ByteOrder) (*nef.File, error) {
+	data, err := f.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("preview: %w", err)
+	}
+	tags := []nef.Tag{
+		nef.NewTag(nef.NewSubfileType, nef.Long, order, subfilePreview),
+		nef.NewTag(nef.ImageWidth, nef.Long, order, uint64(cfg.Width)),
+		nef.NewTag(nef.ImageLength, nef.Long, order, uint64(cfg.Height)),
+		nef.NewTag(nef.BitsPerSample, nef.Short, order, 8, 8, 8),
+		nef.NewTag(nef.Compression, nef.Short, order, nef.CompressionJPEG),
+		nef.NewTag(nef.Photometric, nef.Short, order, uint64(nef.ImgYCbCr)),
+		nef.NewTag(nef.StripOffsets, nef.Long, order, 0),
+		nef.NewTag(nef.SamplesPerPixel, nef.Short, order, 3),
+		nef.NewTag(nef.RowsPerStrip, nef.Long, order, uint64(cfg.Height)),
+		nef.NewTag(nef.StripByteCounts, nef.Long, order, uint64(len(data))),
+		nef.NewTag(nef.PlanarConfiguration, nef.Short, order, 1),
+	}
+	return nef.NewFile(order, data, tags...), nil
+}
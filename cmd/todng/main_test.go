@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/midbel/exif/nef"
+	"github.com/midbel/exif/nef/develop"
+)
+
+func TestConvert(t *testing.T) {
+	const (
+		width  = 20
+		height = 12
+	)
+	var (
+		order   = binary.BigEndian
+		pattern = []uint64{0, 1, 1, 2}
+		samples = make([]uint16, width*height)
+		strip   = make([]byte, width*height*2)
+	)
+	for i := range samples {
+		samples[i] = uint16(600 + (i*i*7)%15000)
+		order.PutUint16(strip[i*2:], samples[i])
+	}
+	raw := nef.NewFile(order, strip,
+		nef.NewTag(nef.ImageWidth, nef.Long, order, width),
+		nef.NewTag(nef.ImageLength, nef.Long, order, height),
+		nef.NewTag(nef.BitsPerSample, nef.Short, order, 16),
+		nef.NewTag(nef.Compression, nef.Short, order, nef.CompressionNone),
+		nef.NewTag(nef.Photometric, nef.Short, order, uint64(nef.ImgCFA)),
+		nef.NewTag(nef.StripOffsets, nef.Long, order, 0),
+		nef.NewTag(nef.RowsPerStrip, nef.Long, order, height),
+		nef.NewTag(nef.StripByteCounts, nef.Long, order, uint64(len(strip))),
+		nef.NewTag(nef.CFARepeatPatternDim, nef.Short, order, 2, 2),
+		nef.NewTag(nef.CFAPattern, nef.Byte, order, pattern...),
+	)
+	var (
+		root   = nef.NewFile(order, nil, nef.NewString(tiffModel, "NIKON D700"))
+		model  = nef.NewString(tiffModel, "NIKON D700")
+		params = develop.Params{
+			Black:       []float64{600},
+			White:       15600,
+			Multipliers: [3]float64{2, 1, 1.25},
+			Matrix:      [9]float64{0.5, -0.25, 0, -0.5, 1.25, 0.25, 0, 0.125, 0.75},
+		}
+	)
+	for _, compress := range []bool{false, true} {
+		cfa, err := rawFile(raw, params, compress)
+		if err != nil {
+			t.Fatalf("compress %t: raw: %s", compress, err)
+		}
+		main, err := mainFile(root, model, params)
+		if err != nil {
+			t.Fatalf("compress %t: main: %s", compress, err)
+		}
+		main.Files = append(main.Files, cfa)
+
+		var buf bytes.Buffer
+		if err := nef.Encode(&buf, []*nef.File{main}); err != nil {
+			t.Fatalf("compress %t: encode: %s", compress, err)
+		}
+		files, err := nef.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("compress %t: decode: %s", compress, err)
+		}
+		if len(files) != 1 || len(files[0].Files) != 1 {
+			t.Fatalf("compress %t: directories mismatched", compress)
+		}
+		d, err := files[0].DNG()
+		if err != nil {
+			t.Fatalf("compress %t: dng: %s", compress, err)
+		}
+		if d.Version != "1.4.0.0" || d.Model != "NIKON D700" {
+			t.Errorf("compress %t: version %s, model %s", compress, d.Version, d.Model)
+		}
+		if !equalFloats(d.ColorMatrix[0], params.Matrix[:]) {
+			t.Errorf("compress %t: color matrix mismatched: want %v, got %v", compress, params.Matrix, d.ColorMatrix[0])
+		}
+		if want := []float64{0.5, 1, 0.8}; !equalFloats(d.AsShotNeutral, want) {
+			t.Errorf("compress %t: neutral mismatched: want %v, got %v", compress, want, d.AsShotNeutral)
+		}
+
+		sub := files[0].Files[0]
+		if sub.IsTiled() != compress {
+			t.Errorf("compress %t: tiled %t", compress, sub.IsTiled())
+		}
+		c, err := sub.CFA()
+		if err != nil {
+			t.Fatalf("compress %t: cfa: %s", compress, err)
+		}
+		if c.Width != 2 || c.Height != 2 || !bytes.Equal(c.Colors, []byte{0, 1, 1, 2}) {
+			t.Errorf("compress %t: cfa mismatched: %+v", compress, c)
+		}
+		r, err := sub.DNGRaw()
+		if err != nil {
+			t.Fatalf("compress %t: raw: %s", compress, err)
+		}
+		if !equalFloats(r.Black, []float64{600, 600, 600, 600}) || !equalFloats(r.White, []float64{15600}) {
+			t.Errorf("compress %t: levels mismatched: black %v, white %v", compress, r.Black, r.White)
+		}
+		img, err := sub.RawImage()
+		if err != nil {
+			t.Fatalf("compress %t: image: %s", compress, err)
+		}
+		if b := img.Bounds(); b.Dx() != width || b.Dy() != height {
+			t.Fatalf("compress %t: size mismatched: %s", compress, b)
+		}
+		for i, s := range samples {
+			if got := img.Gray16At(i%width, i/width).Y; got != s {
+				t.Fatalf("compress %t: sample %d mismatched: want %d, got %d", compress, i, s, got)
+			}
+		}
+	}
+}
+
+func equalFloats(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-6 {
+			return false
+		}
+	}
+	return true
+}
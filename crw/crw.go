@@ -0,0 +1,383 @@
+package crw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"time"
+
+	"github.com/midbel/exif/nef"
+)
+
+var (
+	ErrFormat   = errors.New("not a crw file")
+	ErrNotFound = errors.New("not found")
+)
+
+var (
+	little    = []byte("II")
+	big       = []byte("MM")
+	signature = []byte("HEAPCCDR")
+)
+
+const headerSize = 14
+
+// location and type of the data of an entry, given by the upper bits of its
+// tag
+const (
+	locationMask = 0xc000
+	inRecord     = 0x4000
+
+	typeMask  = 0x3800
+	typeHeap  = 0x2800
+	typeHeap2 = 0x3000
+
+	idMask = 0x3fff
+)
+
+// tags of the entries of the heaps, without their location
+const (
+	TagDescription    = 0x0805
+	TagMakeModel      = 0x080a
+	TagFirmware       = 0x080b
+	TagOwner          = 0x0810
+	TagFocalLength    = 0x1029
+	TagShotInfo       = 0x102a
+	TagCameraSettings = 0x102d
+	TagSerialNumber   = 0x180b
+	TagTimeStamp      = 0x180e
+	TagImageInfo      = 0x1810
+	TagExposureInfo   = 0x1818
+	TagRawData        = 0x2005
+	TagJpgFromRaw     = 0x2007
+	TagThumbnail      = 0x2008
+)
+
+// tags of the directories given by Metadata
+const (
+	tiffDescription = 0x10e
+	tiffMake        = 0x10f
+	tiffModel       = 0x110
+	tiffOrientation = 0x112
+	tiffSoftware    = 0x131
+	tiffDateTime    = 0x132
+	tiffArtist      = 0x13b
+
+	exifExposureTime   = 0x829a
+	exifFNumber        = 0x829d
+	exifDateTime       = 0x9003
+	exifShutterSpeed   = 0x9201
+	exifAperture       = 0x9202
+	exifExposureBias   = 0x9204
+	exifFocalLength    = 0x920a
+	exifPixelX         = 0xa002
+	exifPixelY         = 0xa003
+	exifBodySerial     = 0xa431
+	noteCameraSettings = 0x0001
+	noteFocalLength    = 0x0002
+	noteShotInfo       = 0x0004
+)
+
+// Entry is a record of a heap. The content of the records storing a heap is
+// given by Entries.
+type Entry struct {
+	Tag     uint16
+	Offset  uint32
+	Size    uint32
+	Data    []byte
+	Entries []Entry
+}
+
+// Id gives the tag of an entry without its location.
+func (e Entry) Id() uint16 {
+	return e.Tag & idMask
+}
+
+func (e Entry) IsHeap() bool {
+	typ := e.Tag & typeMask
+	return typ == typeHeap || typ == typeHeap2
+}
+
+// File is a Canon CRW file: a header followed by a heap (CIFF) whose records
+// can themselves be heaps.
+type File struct {
+	Order   binary.ByteOrder
+	Entries []Entry
+
+	buf []byte
+}
+
+func IsCRW(buf []byte) bool {
+	if len(buf) < headerSize || !bytes.Equal(buf[6:14], signature) {
+		return false
+	}
+	return bytes.HasPrefix(buf, little) || bytes.HasPrefix(buf, big)
+}
+
+func DecodeFile(file string) (*File, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return Decode(r)
+}
+
+func Decode(r io.Reader) (*File, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !IsCRW(buf) {
+		return nil, ErrFormat
+	}
+	f := File{
+		Order: binary.ByteOrder(binary.LittleEndian),
+		buf:   buf,
+	}
+	if bytes.HasPrefix(buf, big) {
+		f.Order = binary.BigEndian
+	}
+	start := f.Order.Uint32(buf[2:])
+	if start < headerSize || int(start) > len(buf) {
+		return nil, fmt.Errorf("heap at %d: %w", start, ErrFormat)
+	}
+	if f.Entries, err = f.readHeap(start, uint32(len(buf))-start, 0); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+const maxDepth = 8
+
+// readHeap reads the records of the heap stored in size bytes from offset.
+// The last 4 bytes of a heap give the position of its table of records from
+// its start, each record being made of a tag, a size and an offset in the
+// heap. Records of 8 bytes at most can be stored in the table itself.
+func (f File) readHeap(offset, size uint32, depth int) ([]Entry, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("heap at %d: too deep: %w", offset, ErrFormat)
+	}
+	if size < 4 {
+		return nil, fmt.Errorf("heap at %d: %w", offset, io.ErrUnexpectedEOF)
+	}
+	var (
+		heap  = f.buf[offset : offset+size]
+		table = f.Order.Uint32(heap[size-4:])
+	)
+	if uint64(table)+2 > uint64(size) {
+		return nil, fmt.Errorf("heap at %d: table at %d: %w", offset, table, ErrFormat)
+	}
+	var (
+		count = int(f.Order.Uint16(heap[table:]))
+		recs  = heap[table+2:]
+		list  []Entry
+	)
+	if len(recs) < count*10 {
+		return nil, fmt.Errorf("heap at %d: %d records: %w", offset, count, io.ErrUnexpectedEOF)
+	}
+	for i := 0; i < count; i++ {
+		var (
+			rec = recs[i*10 : (i+1)*10]
+			e   = Entry{Tag: f.Order.Uint16(rec)}
+		)
+		if e.Tag&locationMask == inRecord {
+			e.Offset = offset + table + 2 + uint32(i*10) + 2
+			e.Size = 8
+			e.Data = rec[2:]
+			list = append(list, e)
+			continue
+		}
+		e.Size = f.Order.Uint32(rec[2:])
+		e.Offset = f.Order.Uint32(rec[6:])
+		if uint64(e.Offset)+uint64(e.Size) > uint64(size) {
+			return nil, fmt.Errorf("record %04x: %w", e.Tag, io.ErrUnexpectedEOF)
+		}
+		e.Offset += offset
+		e.Data = f.buf[e.Offset : e.Offset+e.Size]
+		if e.IsHeap() {
+			sub, err := f.readHeap(e.Offset, e.Size, depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("record %04x: %w", e.Tag, err)
+			}
+			e.Entries = sub
+		}
+		list = append(list, e)
+	}
+	return list, nil
+}
+
+// Find gives the first record with the given id found in the heaps.
+func (f File) Find(id uint16) (Entry, error) {
+	if e, ok := findEntry(f.Entries, id); ok {
+		return e, nil
+	}
+	return Entry{}, fmt.Errorf("%w: record %04x", ErrNotFound, id)
+}
+
+func findEntry(list []Entry, id uint16) (Entry, bool) {
+	for _, e := range list {
+		if e.Id() == id {
+			return e, true
+		}
+		if e, ok := findEntry(e.Entries, id); ok {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Jpeg gives the embedded JPEG, a full size preview of the image.
+func (f File) Jpeg() ([]byte, error) {
+	e, err := f.Find(TagJpgFromRaw)
+	if err != nil {
+		return nil, err
+	}
+	return e.Data, nil
+}
+
+func (f File) Thumbnail() ([]byte, error) {
+	e, err := f.Find(TagThumbnail)
+	if err != nil {
+		return nil, err
+	}
+	return e.Data, nil
+}
+
+// Time gives the capture time. It is stored as the number of seconds since
+// 1970 in the local time of the camera, so the time returned has no zone.
+func (f File) Time() (time.Time, error) {
+	e, err := f.Find(TagTimeStamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(e.Data) < 4 {
+		return time.Time{}, fmt.Errorf("time stamp: %w", io.ErrUnexpectedEOF)
+	}
+	return time.Unix(int64(f.Order.Uint32(e.Data)), 0).UTC(), nil
+}
+
+func (f File) shorts(id uint16) ([]int16, error) {
+	e, err := f.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	vs := make([]int16, len(e.Data)/2)
+	for i := range vs {
+		vs[i] = int16(f.Order.Uint16(e.Data[i*2:]))
+	}
+	return vs, nil
+}
+
+// Metadata gives the records of the file as a directory, the records being
+// translated to their equivalent tags in IFD0, in Exif and in the maker notes
+// of Canon. Its image is the embedded JPEG and its sub directory the
+// thumbnail.
+func (f File) Metadata() (*nef.File, error) {
+	root := nef.NewFile(f.Order, f.buf)
+	if e, err := f.Find(TagJpgFromRaw); err == nil {
+		root.SetTag(nef.NewTag(nef.JpegFromRawStart, nef.Long, f.Order, uint64(e.Offset)), nef.Tiff)
+		root.SetTag(nef.NewTag(nef.JpegFromRawLength, nef.Long, f.Order, uint64(e.Size)), nef.Tiff)
+	}
+	if e, err := f.Find(TagMakeModel); err == nil {
+		parts := bytes.SplitN(e.Data, []byte{0}, 3)
+		if len(parts) >= 2 {
+			root.SetTag(nef.NewString(tiffMake, string(parts[0])), nef.Tiff)
+			root.SetTag(nef.NewString(tiffModel, string(parts[1])), nef.Tiff)
+		}
+	}
+	for id, tag := range map[uint16]uint16{
+		TagDescription: tiffDescription,
+		TagFirmware:    tiffSoftware,
+		TagOwner:       tiffArtist,
+	} {
+		if e, err := f.Find(id); err == nil {
+			if str := string(bytes.TrimRight(e.Data, "\x00")); str != "" {
+				root.SetTag(nef.NewString(tag, str), nef.Tiff)
+			}
+		}
+	}
+	if when, err := f.Time(); err == nil {
+		str := when.Format("2006:01:02 15:04:05")
+		root.SetTag(nef.NewString(tiffDateTime, str), nef.Tiff)
+		root.SetTag(nef.NewString(exifDateTime, str), nef.Exif)
+	}
+	if e, err := f.Find(TagImageInfo); err == nil && len(e.Data) >= 16 {
+		var (
+			width  = f.Order.Uint32(e.Data)
+			height = f.Order.Uint32(e.Data[4:])
+			orient = nef.OrientNormal
+		)
+		switch int32(f.Order.Uint32(e.Data[12:])) {
+		case 90, -270:
+			orient = nef.OrientRotate90
+		case 180, -180:
+			orient = nef.OrientRotate180
+		case 270, -90:
+			orient = nef.OrientRotate270
+		}
+		root.SetTag(nef.NewTag(tiffOrientation, nef.Short, f.Order, uint64(orient)), nef.Tiff)
+		root.SetTag(nef.NewTag(exifPixelX, nef.Long, f.Order, uint64(width)), nef.Exif)
+		root.SetTag(nef.NewTag(exifPixelY, nef.Long, f.Order, uint64(height)), nef.Exif)
+	}
+	if e, err := f.Find(TagExposureInfo); err == nil && len(e.Data) >= 12 {
+		var (
+			bias = float64(math.Float32frombits(f.Order.Uint32(e.Data)))
+			tv   = float64(math.Float32frombits(f.Order.Uint32(e.Data[4:])))
+			av   = float64(math.Float32frombits(f.Order.Uint32(e.Data[8:])))
+		)
+		root.SetTag(nef.NewRationals(exifExposureBias, nef.SRational, f.Order, 100, bias), nef.Exif)
+		root.SetTag(nef.NewRationals(exifShutterSpeed, nef.SRational, f.Order, 1000, tv), nef.Exif)
+		root.SetTag(nef.NewRationals(exifAperture, nef.Rational, f.Order, 1000, av), nef.Exif)
+		root.SetTag(exposureTime(f.Order, tv), nef.Exif)
+		root.SetTag(nef.NewRationals(exifFNumber, nef.Rational, f.Order, 10, math.Pow(2, av/2)), nef.Exif)
+	}
+	if e, err := f.Find(TagSerialNumber); err == nil && len(e.Data) >= 4 {
+		str := fmt.Sprintf("%d", f.Order.Uint32(e.Data))
+		root.SetTag(nef.NewString(exifBodySerial, str), nef.Exif)
+	}
+	for id, tag := range map[uint16]uint16{
+		TagCameraSettings: noteCameraSettings,
+		TagFocalLength:    noteFocalLength,
+		TagShotInfo:       noteShotInfo,
+	} {
+		vs, err := f.shorts(id)
+		if err != nil || len(vs) == 0 {
+			continue
+		}
+		values := make([]uint64, len(vs))
+		for i := range vs {
+			values[i] = uint64(uint16(vs[i]))
+		}
+		root.SetTag(nef.NewTag(tag, nef.Short, f.Order, values...), nef.Note)
+		if id == TagFocalLength && len(vs) > 1 {
+			root.SetTag(nef.NewRationals(exifFocalLength, nef.Rational, f.Order, 1, float64(vs[1])), nef.Exif)
+		}
+	}
+	if e, err := f.Find(TagThumbnail); err == nil {
+		thumb := nef.NewFile(f.Order, f.buf,
+			nef.NewTag(nef.NewSubfileType, nef.Long, f.Order, 1),
+			nef.NewTag(nef.JpegFromRawStart, nef.Long, f.Order, uint64(e.Offset)),
+			nef.NewTag(nef.JpegFromRawLength, nef.Long, f.Order, uint64(e.Size)),
+		)
+		thumb.Index = []int{0, 0}
+		root.Files = append(root.Files, thumb)
+	}
+	root.Index = []int{0}
+	return root, nil
+}
+
+// exposureTime gives the exposure time from the shutter speed in APEX, as a
+// fraction of a second when shorter than a second.
+func exposureTime(order binary.ByteOrder, tv float64) nef.Tag {
+	secs := math.Pow(2, -tv)
+	if secs < 1 {
+		return nef.NewRationals(exifExposureTime, nef.Rational, order, int32(math.Round(1/secs)), secs)
+	}
+	return nef.NewRationals(exifExposureTime, nef.Rational, order, 10, secs)
+}
@@ -0,0 +1,399 @@
+package crw
+
+import (
+	"fmt"
+	"math"
+)
+
+// indexes of the values of the camera settings, as listed by the
+// CanonCameraSettings table of ExifTool
+const (
+	csMacroMode    = 1
+	csSelfTimer    = 2
+	csQuality      = 3
+	csFlashMode    = 4
+	csDrive        = 5
+	csFocusMode    = 7
+	csRecordMode   = 9
+	csImageSize    = 10
+	csEasyMode     = 11
+	csDigitalZoom  = 12
+	csContrast     = 13
+	csSaturation   = 14
+	csSharpness    = 15
+	csISO          = 16
+	csMetering     = 17
+	csFocusRange   = 18
+	csAFPoint      = 19
+	csExposureMode = 20
+	csLensType     = 22
+	csMaxFocal     = 23
+	csMinFocal     = 24
+	csFocalUnits   = 25
+	csMaxAperture  = 26
+	csMinAperture  = 27
+)
+
+// indexes of the values of the shot info, as listed by the CanonShotInfo
+// table of ExifTool
+const (
+	siAutoISO        = 1
+	siBaseISO        = 2
+	siMeasuredEV     = 3
+	siTargetAperture = 4
+	siTargetExposure = 5
+	siExposureComp   = 6
+	siWhiteBalance   = 7
+	siSequence       = 9
+	siAFPoints       = 14
+	siFlashComp      = 15
+	siFocusUpper     = 19
+	siFocusLower     = 20
+	siFNumber        = 21
+)
+
+var macroModes = map[int16]string{
+	1: "macro",
+	2: "normal",
+}
+
+var qualities = map[int16]string{
+	-1: "n/a",
+	1:  "economy",
+	2:  "normal",
+	3:  "fine",
+	4:  "raw",
+	5:  "superfine",
+	7:  "craw",
+}
+
+var flashModes = map[int16]string{
+	0:  "off",
+	1:  "auto",
+	2:  "on",
+	3:  "red-eye reduction",
+	4:  "slow-sync",
+	5:  "red-eye reduction (auto)",
+	6:  "red-eye reduction (on)",
+	16: "external flash",
+}
+
+var driveModes = map[int16]string{
+	0: "single",
+	1: "continuous",
+	2: "movie",
+	3: "continuous, speed priority",
+	4: "continuous, low",
+	5: "continuous, high",
+	6: "silent single",
+}
+
+var focusModes = map[int16]string{
+	0:  "one-shot af",
+	1:  "ai servo af",
+	2:  "ai focus af",
+	3:  "manual focus",
+	4:  "single",
+	5:  "continuous",
+	6:  "manual focus",
+	16: "pan focus",
+}
+
+var recordModes = map[int16]string{
+	1: "jpeg",
+	2: "crw+thm",
+	3: "avi+thm",
+	4: "tif",
+	5: "tif+jpeg",
+	6: "cr2",
+	7: "cr2+jpeg",
+}
+
+var imageSizes = map[int16]string{
+	0: "large",
+	1: "medium",
+	2: "small",
+	5: "medium 1",
+	6: "medium 2",
+	7: "medium 3",
+	8: "postcard",
+	9: "widescreen",
+}
+
+var easyModes = map[int16]string{
+	0:  "full auto",
+	1:  "manual",
+	2:  "landscape",
+	3:  "fast shutter",
+	4:  "slow shutter",
+	5:  "night",
+	6:  "gray scale",
+	7:  "sepia",
+	8:  "portrait",
+	9:  "sports",
+	10: "macro",
+	11: "black & white",
+	12: "pan focus",
+	13: "vivid",
+	14: "neutral",
+	15: "flash off",
+	16: "long shutter",
+	17: "super macro",
+	18: "foliage",
+	19: "indoor",
+	20: "fireworks",
+	21: "beach",
+	22: "underwater",
+	23: "snow",
+	24: "kids & pets",
+	25: "night snapshot",
+	26: "digital macro",
+}
+
+var digitalZooms = map[int16]string{
+	0: "none",
+	1: "2x",
+	2: "4x",
+	3: "other",
+}
+
+var meteringModes = map[int16]string{
+	0: "default",
+	1: "spot",
+	2: "average",
+	3: "evaluative",
+	4: "partial",
+	5: "center-weighted average",
+}
+
+var focusRanges = map[int16]string{
+	0:  "manual",
+	1:  "auto",
+	2:  "not known",
+	3:  "macro",
+	4:  "very close",
+	5:  "close",
+	6:  "middle range",
+	7:  "far range",
+	8:  "pan focus",
+	9:  "super macro",
+	10: "infinity",
+}
+
+var afPoints = map[int16]string{
+	0x2005: "manual af point selection",
+	0x3000: "none (mf)",
+	0x3001: "auto af point selection",
+	0x3002: "right",
+	0x3003: "center",
+	0x3004: "left",
+	0x4001: "auto af point selection",
+	0x4006: "face detect",
+}
+
+var exposureModes = map[int16]string{
+	0: "program ae",
+	1: "shutter speed priority ae",
+	2: "aperture-priority ae",
+	3: "manual",
+	4: "depth-of-field ae",
+	5: "m-dep",
+	6: "bulb",
+}
+
+var isoSpeeds = map[int16]string{
+	0:  "n/a",
+	14: "auto high",
+	15: "auto",
+	16: "50",
+	17: "100",
+	18: "200",
+	19: "400",
+	20: "800",
+}
+
+var whiteBalances = map[int16]string{
+	0: "auto",
+	1: "daylight",
+	2: "cloudy",
+	3: "tungsten",
+	4: "fluorescent",
+	5: "flash",
+	6: "custom",
+	7: "black & white",
+	8: "shade",
+	9: "manual temperature (kelvin)",
+}
+
+// CameraSettings are the settings of the camera recorded in the
+// CameraSettings record, the first maker note of Canon. Contrast, Saturation
+// and Sharpness are steps from the normal setting.
+type CameraSettings struct {
+	MacroMode    string
+	SelfTimer    float64
+	Quality      string
+	FlashMode    string
+	Drive        string
+	FocusMode    string
+	RecordMode   string
+	ImageSize    string
+	EasyMode     string
+	DigitalZoom  string
+	Contrast     int16
+	Saturation   int16
+	Sharpness    int16
+	ISO          string
+	MeteringMode string
+	FocusRange   string
+	AFPoint      string
+	ExposureMode string
+	LensType     uint16
+	// MinFocal and MaxFocal are the focal lengths of the lens in mm.
+	MinFocal float64
+	MaxFocal float64
+	// MaxAperture and MinAperture are the apertures of the lens as f-numbers.
+	MaxAperture float64
+	MinAperture float64
+}
+
+// ShotInfo is the information recorded by the camera about the shot in the
+// ShotInfo record of Canon.
+type ShotInfo struct {
+	AutoISO  float64
+	BaseISO  float64
+	Measured float64
+	// TargetAperture and FNumber are f-numbers and TargetExposure is in
+	// seconds.
+	TargetAperture float64
+	TargetExposure float64
+	ExposureComp   float64
+	WhiteBalance   string
+	SequenceNumber int16
+	// AFPointsInFocus is a mask of the points in focus.
+	AFPointsInFocus uint16
+	FlashComp       float64
+	// FocusUpper and FocusLower are the distances of focus in meters.
+	FocusUpper float64
+	FocusLower float64
+	FNumber    float64
+}
+
+// CameraSettings gives the settings of the camera, decoded from the values
+// of the CameraSettings record.
+func (f File) CameraSettings() (CameraSettings, error) {
+	var c CameraSettings
+	vs, err := f.shorts(TagCameraSettings)
+	if err != nil {
+		return c, err
+	}
+	at := values(vs)
+
+	c.MacroMode = lookup(macroModes, at(csMacroMode))
+	c.SelfTimer = float64(at(csSelfTimer)&0x3fff) / 10
+	c.Quality = lookup(qualities, at(csQuality))
+	c.FlashMode = lookup(flashModes, at(csFlashMode))
+	c.Drive = lookup(driveModes, at(csDrive))
+	c.FocusMode = lookup(focusModes, at(csFocusMode))
+	c.RecordMode = lookup(recordModes, at(csRecordMode))
+	c.ImageSize = lookup(imageSizes, at(csImageSize))
+	c.EasyMode = lookup(easyModes, at(csEasyMode))
+	c.DigitalZoom = lookup(digitalZooms, at(csDigitalZoom))
+	c.Contrast = at(csContrast)
+	c.Saturation = at(csSaturation)
+	c.Sharpness = at(csSharpness)
+	if iso := at(csISO); iso&0x4000 != 0 {
+		c.ISO = fmt.Sprintf("%d", iso&0x3fff)
+	} else {
+		c.ISO = lookup(isoSpeeds, iso)
+	}
+	c.MeteringMode = lookup(meteringModes, at(csMetering))
+	c.FocusRange = lookup(focusRanges, at(csFocusRange))
+	c.AFPoint = lookup(afPoints, at(csAFPoint))
+	c.ExposureMode = lookup(exposureModes, at(csExposureMode))
+	c.LensType = uint16(at(csLensType))
+
+	units := float64(at(csFocalUnits))
+	if units <= 0 {
+		units = 1
+	}
+	c.MaxFocal = float64(uint16(at(csMaxFocal))) / units
+	c.MinFocal = float64(uint16(at(csMinFocal))) / units
+	c.MaxAperture = aperture(at(csMaxAperture))
+	c.MinAperture = aperture(at(csMinAperture))
+	return c, nil
+}
+
+// ShotInfo gives the information about the shot, decoded from the values of
+// the ShotInfo record.
+func (f File) ShotInfo() (ShotInfo, error) {
+	var s ShotInfo
+	vs, err := f.shorts(TagShotInfo)
+	if err != nil {
+		return s, err
+	}
+	at := values(vs)
+
+	s.AutoISO = 100 * math.Exp2(float64(at(siAutoISO))/32)
+	s.BaseISO = 100 * math.Exp2(float64(at(siBaseISO))/32) / 32
+	s.Measured = float64(at(siMeasuredEV)) / 32
+	s.TargetAperture = aperture(at(siTargetAperture))
+	if v := at(siTargetExposure); v != 0 {
+		s.TargetExposure = math.Exp2(-canonEV(v))
+	}
+	s.ExposureComp = canonEV(at(siExposureComp))
+	s.WhiteBalance = lookup(whiteBalances, at(siWhiteBalance))
+	s.SequenceNumber = at(siSequence)
+	s.AFPointsInFocus = uint16(at(siAFPoints))
+	s.FlashComp = canonEV(at(siFlashComp))
+	s.FocusUpper = float64(uint16(at(siFocusUpper))) / 100
+	s.FocusLower = float64(uint16(at(siFocusLower))) / 100
+	s.FNumber = aperture(at(siFNumber))
+	return s, nil
+}
+
+// values gives the value at an index of a record, 0 when the record is too
+// short.
+func values(vs []int16) func(int) int16 {
+	return func(i int) int16 {
+		if i >= len(vs) {
+			return 0
+		}
+		return vs[i]
+	}
+}
+
+func lookup(names map[int16]string, v int16) string {
+	str, ok := names[v]
+	if !ok {
+		str = fmt.Sprintf("other (%d)", v)
+	}
+	return str
+}
+
+// canonEV converts a value in 1/32 EV, whose steps of a third are stored as
+// 0x0c and 0x14, to EV.
+func canonEV(v int16) float64 {
+	var (
+		val  = int(v)
+		sign = 1.0
+	)
+	if val < 0 {
+		val, sign = -val, -1
+	}
+	frac := float64(val & 0x1f)
+	switch frac {
+	case 0x0c:
+		frac = 32.0 / 3
+	case 0x14:
+		frac = 64.0 / 3
+	}
+	return sign * (float64(val&^0x1f) + frac) / 32
+}
+
+// aperture gives the f-number of an aperture in Canon EV, 0 when not known.
+func aperture(v int16) float64 {
+	if v == 0 {
+		return 0
+	}
+	return math.Exp2(canonEV(v) / 2)
+}
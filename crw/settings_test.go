@@ -0,0 +1,122 @@
+package crw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// crwFile builds a CRW file whose heap holds the given records of shorts.
+func crwFile(records map[uint16][]int16) []byte {
+	var (
+		order = binary.LittleEndian
+		heap  []byte
+		table []byte
+	)
+	table = order.AppendUint16(table, uint16(len(records)))
+	for tag, vs := range records {
+		offset := len(heap)
+		for _, v := range vs {
+			heap = order.AppendUint16(heap, uint16(v))
+		}
+		table = order.AppendUint16(table, tag)
+		table = order.AppendUint32(table, uint32(len(heap)-offset))
+		table = order.AppendUint32(table, uint32(offset))
+	}
+	buf := append([]byte("II"), order.AppendUint32(nil, headerSize)...)
+	buf = append(buf, signature...)
+	buf = append(buf, heap...)
+	buf = order.AppendUint32(append(buf, table...), uint32(len(heap)))
+	return buf
+}
+
+func TestCameraSettings(t *testing.T) {
+	settings := make([]int16, 30)
+	settings[csMacroMode] = 1
+	settings[csQuality] = 3
+	settings[csFlashMode] = 1
+	settings[csDrive] = 1
+	settings[csFocusMode] = 1
+	settings[csISO] = 0x4000 | 400
+	settings[csAFPoint] = 0x3003
+	settings[csMaxFocal] = 200
+	settings[csMinFocal] = 70
+	settings[csFocalUnits] = 1
+	settings[csMaxAperture] = 128
+	settings[csMinAperture] = 192
+
+	f, err := Decode(bytes.NewReader(crwFile(map[uint16][]int16{
+		TagCameraSettings: settings,
+	})))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c, err := f.CameraSettings()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data := []struct {
+		Name string
+		Want string
+		Got  string
+	}{
+		{Name: "macro", Want: "macro", Got: c.MacroMode},
+		{Name: "quality", Want: "fine", Got: c.Quality},
+		{Name: "flash", Want: "auto", Got: c.FlashMode},
+		{Name: "drive", Want: "continuous", Got: c.Drive},
+		{Name: "focus", Want: "ai servo af", Got: c.FocusMode},
+		{Name: "iso", Want: "400", Got: c.ISO},
+		{Name: "af point", Want: "center", Got: c.AFPoint},
+	}
+	for _, d := range data {
+		if d.Got != d.Want {
+			t.Errorf("%s mismatched: want %q, got %q", d.Name, d.Want, d.Got)
+		}
+	}
+	if c.MinFocal != 70 || c.MaxFocal != 200 {
+		t.Errorf("focal lengths mismatched: got %f-%f", c.MinFocal, c.MaxFocal)
+	}
+	if c.MaxAperture != 4 || c.MinAperture != 8 {
+		t.Errorf("apertures mismatched: got f/%f-f/%f", c.MaxAperture, c.MinAperture)
+	}
+}
+
+func TestShotInfo(t *testing.T) {
+	info := make([]int16, 30)
+	info[siBaseISO] = 160
+	info[siTargetAperture] = 64
+	info[siTargetExposure] = 192
+	info[siExposureComp] = -0x0c
+	info[siWhiteBalance] = 1
+
+	f, err := Decode(bytes.NewReader(crwFile(map[uint16][]int16{
+		TagShotInfo: info,
+	})))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s, err := f.ShotInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data := []struct {
+		Name string
+		Want float64
+		Got  float64
+	}{
+		{Name: "auto iso", Want: 100, Got: s.AutoISO},
+		{Name: "base iso", Want: 100, Got: s.BaseISO},
+		{Name: "target aperture", Want: 2, Got: s.TargetAperture},
+		{Name: "target exposure", Want: 1.0 / 64, Got: s.TargetExposure},
+		{Name: "exposure compensation", Want: -1.0 / 3, Got: s.ExposureComp},
+	}
+	for _, d := range data {
+		if math.Abs(d.Got-d.Want) > 1e-9 {
+			t.Errorf("%s mismatched: want %f, got %f", d.Name, d.Want, d.Got)
+		}
+	}
+	if s.WhiteBalance != "daylight" {
+		t.Errorf("white balance mismatched: want daylight, got %s", s.WhiteBalance)
+	}
+}
@@ -0,0 +1,413 @@
+// Package exif opens the files supported by the other packages of the module
+// without knowing their format in advance.
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/midbel/exif/crw"
+	"github.com/midbel/exif/mov"
+	"github.com/midbel/exif/nef"
+	"github.com/midbel/exif/raf"
+)
+
+var (
+	ErrFormat   = errors.New("unknown format")
+	ErrNotFound = errors.New("not found")
+)
+
+const (
+	FormatTIFF    = "tiff"
+	FormatBigTIFF = "bigtiff"
+	FormatJPEG    = "jpeg"
+	FormatPNG     = "png"
+	FormatWebP    = "webp"
+	FormatHEIF    = "heif"
+	FormatAVIF    = "avif"
+	FormatCR3     = "cr3"
+	FormatMOV     = "mov"
+	FormatMP4     = "mp4"
+	FormatRAF     = "raf"
+	FormatCRW     = "crw"
+	FormatAVI     = "avi"
+)
+
+// variants of TIFF, told apart once decoded
+const (
+	FormatNEF = nef.VariantNEF
+	FormatNRW = nef.VariantNRW
+	FormatDNG = nef.VariantDNG
+	FormatCR2 = nef.VariantCR2
+	FormatARW = nef.VariantARW
+	FormatORF = nef.VariantORF
+	FormatRW2 = nef.VariantRW2
+	FormatPEF = nef.VariantPEF
+)
+
+// tags used by Metadata
+const (
+	tiffDateTime    = 0x132
+	exifDateTime    = 0x9003
+	gpsLatitudeRef  = 0x1
+	gpsLatitude     = 0x2
+	gpsLongitudeRef = 0x3
+	gpsLongitude    = 0x4
+)
+
+const (
+	timeLayout     = "2006:01:02 15:04:05"
+	brandQuickTime = "qt  "
+	ftypBox        = "ftyp"
+	sniffLength    = 16
+	prefixLength   = 256
+)
+
+// Metadata is the metadata of a file whatever its format. Files are the
+// directories of its Exif, the first one being IFD0, when the format has them.
+type Metadata struct {
+	Format string
+	Files  []*nef.File
+	XMP    []byte
+
+	created  time.Time
+	location []float64
+	previews [][]byte
+}
+
+// Open detects the format of a file and reads its metadata. The file is read
+// in memory since it is closed once decoded.
+func Open(file string) (*Metadata, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Detect(bytes.NewReader(buf), int64(len(buf)))
+}
+
+// Detect detects the format of the content of r from its first bytes and
+// reads its metadata. Only the parts holding the metadata are read from r
+// which must stay open as long as the previews are used.
+func Detect(r io.ReaderAt, size int64) (*Metadata, error) {
+	prefix := make([]byte, prefixLength)
+	n, err := r.ReadAt(prefix, 0)
+	if n < len(prefix) && err != io.EOF {
+		return nil, err
+	}
+	m := Metadata{
+		Format: Sniff(prefix[:n]),
+	}
+	switch m.Format {
+	case FormatTIFF, FormatBigTIFF:
+		err = m.readTiff(r, size)
+	case FormatJPEG:
+		err = m.readJPEG(r, size)
+	case FormatPNG:
+		err = m.readPNG(r, size)
+	case FormatWebP:
+		err = m.readWebP(r, size)
+	case FormatHEIF, FormatAVIF:
+		err = m.readHEIF(r, size)
+	case FormatCR3:
+		err = m.readCR3(r, size)
+	case FormatMOV, FormatMP4:
+		err = m.readMovie(r, size)
+	case FormatRAF:
+		err = m.readRAF(r, size)
+	case FormatCRW:
+		err = m.readCRW(r, size)
+	case FormatAVI:
+		m.created, err = aviTime(r, size)
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+	default:
+		err = ErrFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m.Format, err)
+	}
+	return &m, nil
+}
+
+// Sniff gives the format of a file from its first bytes or an empty string
+// when the format is not known. The variants of TIFF are told apart once
+// decoded, by Detect. QuickTime files without ftyp box are recognized by
+// their first atom.
+func Sniff(buf []byte) string {
+	switch {
+	case len(buf) < sniffLength:
+		return ""
+	case nef.IsJPEG(buf):
+		return FormatJPEG
+	case nef.IsPNG(buf):
+		return FormatPNG
+	case nef.IsWebP(buf):
+		return FormatWebP
+	case isAVI(buf):
+		return FormatAVI
+	case raf.IsRAF(buf):
+		return FormatRAF
+	case crw.IsCRW(buf):
+		return FormatCRW
+	case string(buf[4:8]) == ftypBox:
+		return sniffBrand(buf)
+	}
+	switch string(buf[:4]) {
+	case "II+\x00", "MM\x00+":
+		return FormatBigTIFF
+	case "II*\x00", "MM\x00*", "IIRO", "MMOR", "IIRS", "IIU\x00":
+		return FormatTIFF
+	}
+	if mov.IsQuickTime(buf) {
+		return FormatMOV
+	}
+	return ""
+}
+
+func sniffBrand(buf []byte) string {
+	if mov.IsCR3(buf) {
+		return FormatCR3
+	}
+	var (
+		size       = int(binary.BigEndian.Uint32(buf))
+		brand      = string(buf[8:12])
+		compatible []string
+	)
+	if size > len(buf) {
+		size = len(buf)
+	}
+	for i := 16; i+4 <= size; i += 4 {
+		compatible = append(compatible, string(buf[i:i+4]))
+	}
+	switch {
+	case brand == "avif" || brand == "avis":
+		return FormatAVIF
+	case mov.IsHEIF(brand, compatible):
+		return FormatHEIF
+	case brand == brandQuickTime:
+		return FormatMOV
+	default:
+		return FormatMP4
+	}
+}
+
+func (m *Metadata) readTiff(r io.ReaderAt, size int64) error {
+	files, err := nef.DecodeReaderAt(r, size)
+	if err != nil {
+		return err
+	}
+	m.Files = files
+	if len(files) > 0 && m.Format == FormatTIFF {
+		m.Format = files[0].Variant()
+	}
+	if len(files) > 0 {
+		if t, err := files[0].GetTag(nef.Xmp, nef.Tiff); err == nil {
+			m.XMP = t.Raw
+		}
+	}
+	return nil
+}
+
+func (m *Metadata) readJPEG(r io.ReaderAt, size int64) error {
+	j, err := nef.DecodeJPEG(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	m.Files, m.XMP = j.Files, j.XMP
+	return nil
+}
+
+func (m *Metadata) readPNG(r io.ReaderAt, size int64) error {
+	p, err := nef.DecodePNG(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	m.Files, m.XMP = p.Files, p.XMP
+	return nil
+}
+
+func (m *Metadata) readWebP(r io.ReaderAt, size int64) error {
+	w, err := nef.DecodeWebP(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	m.Files, m.XMP = w.Files, w.XMP
+	return nil
+}
+
+func (m *Metadata) readHEIF(r io.ReaderAt, size int64) error {
+	h, err := mov.ReadHEIF(r, size)
+	if err != nil {
+		return err
+	}
+	if m.Files, err = h.Exif(); err != nil && !errors.Is(err, mov.ErrNotFound) {
+		return err
+	}
+	if m.XMP, err = h.XMP(); err != nil && !errors.Is(err, mov.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (m *Metadata) readCR3(r io.ReaderAt, size int64) error {
+	f, err := mov.ReadCR3(r, size)
+	if err != nil {
+		return err
+	}
+	m.Files = []*nef.File{f}
+	return nil
+}
+
+func (m *Metadata) readMovie(r io.ReaderAt, size int64) error {
+	f, err := mov.Read(r, size)
+	if err != nil {
+		return err
+	}
+	if p, err := f.DecodeProfile(); err == nil && p.Created > 0 {
+		m.created = p.AcqTime().UTC()
+	}
+	if lat, lon, err := f.Location(); err == nil {
+		m.location = []float64{lat, lon}
+	}
+	return nil
+}
+
+func (m *Metadata) readRAF(r io.ReaderAt, size int64) error {
+	f, err := raf.Decode(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	if jpg, err := f.Jpeg(); err == nil {
+		m.previews = append(m.previews, jpg)
+	}
+	if m.Files, err = f.Exif(); err != nil && !errors.Is(err, nef.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+func (m *Metadata) readCRW(r io.ReaderAt, size int64) error {
+	f, err := crw.Decode(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	root, err := f.Metadata()
+	if err != nil {
+		return err
+	}
+	m.Files = []*nef.File{root}
+	return nil
+}
+
+// Tags gives the tags of the first directory: its own tags, followed by the
+// ones of its Exif, maker notes and GPS directories.
+func (m Metadata) Tags() []nef.Tag {
+	if len(m.Files) == 0 {
+		return nil
+	}
+	return m.Files[0].Tags()
+}
+
+// Time gives the capture time: the original date of the Exif, the date of the
+// first directory or the creation time of the movies.
+func (m Metadata) Time() (time.Time, error) {
+	if len(m.Files) > 0 {
+		f := m.Files[0]
+		for _, t := range []struct {
+			id     uint16
+			origin int
+		}{
+			{exifDateTime, nef.Exif},
+			{tiffDateTime, nef.Tiff},
+		} {
+			if t, err := f.GetTag(t.id, t.origin); err == nil {
+				if when, err := time.Parse(timeLayout, t.String()); err == nil {
+					return when, nil
+				}
+			}
+		}
+	}
+	if !m.created.IsZero() {
+		return m.created, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: capture time", ErrNotFound)
+}
+
+// GPS gives the latitude and the longitude in decimal degrees, negative in
+// the south and in the west.
+func (m Metadata) GPS() (float64, float64, error) {
+	if len(m.location) == 2 {
+		return m.location[0], m.location[1], nil
+	}
+	if len(m.Files) == 0 {
+		return 0, 0, fmt.Errorf("%w: gps", ErrNotFound)
+	}
+	f := m.Files[0]
+	lat, err := gpsDegrees(f, gpsLatitude, gpsLatitudeRef, "S")
+	if err != nil {
+		return 0, 0, err
+	}
+	lon, err := gpsDegrees(f, gpsLongitude, gpsLongitudeRef, "W")
+	if err != nil {
+		return 0, 0, err
+	}
+	return lat, lon, nil
+}
+
+func gpsDegrees(f *nef.File, id, ref uint16, negative string) (float64, error) {
+	t, err := f.GetTag(id, nef.Gps)
+	if err != nil {
+		return 0, fmt.Errorf("%w: gps %04x", ErrNotFound, id)
+	}
+	var (
+		vs  = t.Floats()
+		deg float64
+	)
+	for i, div := range []float64{1, 60, 3600} {
+		if i < len(vs) {
+			deg += vs[i] / div
+		}
+	}
+	if r, err := f.GetTag(ref, nef.Gps); err == nil && r.String() == negative {
+		deg = -deg
+	}
+	if math.IsNaN(deg) {
+		return 0, fmt.Errorf("gps %04x: %w", id, ErrFormat)
+	}
+	return deg, nil
+}
+
+// Previews gives the JPEG previews embedded in the file: the ones of the
+// directories, then the ones stored outside of them.
+func (m Metadata) Previews() ([][]byte, error) {
+	var (
+		list [][]byte
+		walk func([]*nef.File) error
+	)
+	walk = func(files []*nef.File) error {
+		for _, f := range files {
+			if f.IsJpeg() || (f.IsRaw() && f.ImageType() == "jpeg") {
+				buf, err := f.Bytes()
+				if err != nil {
+					return err
+				}
+				list = append(list, buf)
+			}
+			if err := walk(f.Files); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(m.Files); err != nil {
+		return nil, err
+	}
+	return append(list, m.previews...), nil
+}
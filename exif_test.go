@@ -0,0 +1,120 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/midbel/exif/mov"
+	"github.com/midbel/exif/nef"
+)
+
+// box gives an ISO box of the given type and content.
+func box(typ string, data ...string) []byte {
+	buf := make([]byte, 4, 8)
+	binary.BigEndian.PutUint32(buf, uint32(8+len(strings.Join(data, ""))))
+	return append(append(buf, typ...), strings.Join(data, "")...)
+}
+
+func TestSniff(t *testing.T) {
+	pad := func(buf []byte) []byte {
+		return append(buf, bytes.Repeat([]byte{0}, 32)...)
+	}
+	data := []struct {
+		Name string
+		Buf  []byte
+		Want string
+	}{
+		{Name: "tiff-le", Buf: pad([]byte("II*\x00\x08\x00\x00\x00")), Want: FormatTIFF},
+		{Name: "tiff-be", Buf: pad([]byte("MM\x00*\x00\x00\x00\x08")), Want: FormatTIFF},
+		{Name: "bigtiff-le", Buf: pad([]byte("II+\x00\x08\x00\x00\x00")), Want: FormatBigTIFF},
+		{Name: "bigtiff-be", Buf: pad([]byte("MM\x00+\x00\x08\x00\x00")), Want: FormatBigTIFF},
+		{Name: "orf", Buf: pad([]byte("IIRO\x08\x00\x00\x00")), Want: FormatTIFF},
+		{Name: "rw2", Buf: pad([]byte("IIU\x00\x08\x00\x00\x00")), Want: FormatTIFF},
+		{Name: "jpeg", Buf: pad([]byte{0xff, 0xd8, 0xff, 0xe1}), Want: FormatJPEG},
+		{Name: "png", Buf: pad([]byte("\x89PNG\r\n\x1a\n")), Want: FormatPNG},
+		{Name: "webp", Buf: pad([]byte("RIFF\x00\x00\x00\x00WEBPVP8 ")), Want: FormatWebP},
+		{Name: "avi", Buf: pad([]byte("RIFF\x00\x00\x00\x00AVI LIST")), Want: FormatAVI},
+		{Name: "raf", Buf: pad([]byte("FUJIFILMCCD-RAW 0201")), Want: FormatRAF},
+		{Name: "crw", Buf: pad([]byte("II\x1a\x00\x00\x00HEAPCCDR")), Want: FormatCRW},
+		{Name: "cr3", Buf: pad(box("ftyp", "crx ", "\x00\x00\x00\x01", "crx isom")), Want: FormatCR3},
+		{Name: "heic", Buf: pad(box("ftyp", "heic", "\x00\x00\x00\x00", "mif1heic")), Want: FormatHEIF},
+		{Name: "heif-compatible", Buf: pad(box("ftyp", "mp42", "\x00\x00\x00\x00", "mp42mif1")), Want: FormatHEIF},
+		{Name: "avif", Buf: pad(box("ftyp", "avif", "\x00\x00\x00\x00", "avifmif1")), Want: FormatAVIF},
+		{Name: "qt", Buf: pad(box("ftyp", "qt  ", "\x00\x00\x02\x00", "qt  ")), Want: FormatMOV},
+		{Name: "mp4", Buf: pad(box("ftyp", "isom", "\x00\x00\x02\x00", "isomiso2mp41")), Want: FormatMP4},
+		{Name: "qt-wide", Buf: pad(box("wide")), Want: FormatMOV},
+		{Name: "qt-mdat", Buf: pad(box("mdat", "data")), Want: FormatMOV},
+		{Name: "qt-moov", Buf: pad(box("moov", "\x00\x00\x00\x08mvhd")), Want: FormatMOV},
+		{Name: "unknown", Buf: pad([]byte("GIF89a")), Want: ""},
+		{Name: "empty", Buf: nil, Want: ""},
+		{Name: "short-jpeg", Buf: []byte{0xff, 0xd8, 0xff}, Want: ""},
+		{Name: "short-tiff", Buf: []byte("II*\x00"), Want: ""},
+		{Name: "short-ftyp", Buf: []byte("\x00\x00\x00\x14ftypheic"), Want: ""},
+	}
+	for _, d := range data {
+		if got := Sniff(d.Buf); got != d.Want {
+			t.Errorf("%s: want %q, got %q", d.Name, d.Want, got)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	order := binary.LittleEndian
+	f := nef.NewFile(order, nil, nef.NewString(0x10f, "NIKON CORPORATION"))
+	f.SetTag(nef.NewString(exifDateTime, when.Format(timeLayout)), nef.Exif)
+	var tiff bytes.Buffer
+	if err := nef.Encode(&tiff, []*nef.File{f}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var (
+		p    = mov.Profile{TimeScale: 600}
+		mvhd bytes.Buffer
+	)
+	p.Created = uint32(when.Sub(time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)) / time.Second)
+	binary.Write(&mvhd, binary.BigEndian, p)
+	var (
+		xyz  = box("\xa9xyz", "\x00\x13\x15\xc7+48.8577+002.2950/")
+		moov = box("moov", string(box("mvhd", mvhd.String())), string(box("udta", string(xyz))))
+		qt   = append(box("wide"), moov...)
+	)
+
+	data := []struct {
+		Name   string
+		Buf    []byte
+		Format string
+		GPS    bool
+	}{
+		{Name: "nef", Buf: tiff.Bytes(), Format: FormatNEF},
+		{Name: "quicktime", Buf: qt, Format: FormatMOV, GPS: true},
+	}
+	for _, d := range data {
+		m, err := Detect(bytes.NewReader(d.Buf), int64(len(d.Buf)))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if m.Format != d.Format {
+			t.Errorf("%s: format mismatched: want %s, got %s", d.Name, d.Format, m.Format)
+		}
+		if got, err := m.Time(); err != nil || !got.Equal(when) {
+			t.Errorf("%s: time mismatched: want %s, got %s (%v)", d.Name, when, got, err)
+		}
+		if !d.GPS {
+			continue
+		}
+		lat, lon, err := m.GPS()
+		if err != nil || math.Abs(lat-48.8577) > 1e-9 || math.Abs(lon-2.295) > 1e-9 {
+			t.Errorf("%s: location mismatched: %f,%f (%v)", d.Name, lat, lon, err)
+		}
+	}
+	if _, err := Detect(bytes.NewReader([]byte("GIF89a")), 6); err == nil {
+		t.Errorf("unknown format should fail")
+	}
+}
@@ -0,0 +1,174 @@
+package mov
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrFormat = errors.New("invalid box")
+
+const (
+	uuid = "uuid"
+	meta = "meta"
+	hdlr = "hdlr"
+)
+
+// containers are the boxes made only of other boxes, with the number of bytes
+// to skip before the first of them.
+var containers = map[string]int64{
+	moov:   0,
+	udat:   0,
+	"udta": 0,
+	"trak": 0,
+	"mdia": 0,
+	"minf": 0,
+	"stbl": 0,
+	"dinf": 0,
+	"edts": 0,
+	"mvex": 0,
+	"moof": 0,
+	"traf": 0,
+	"iprp": 0,
+	"ipco": 0,
+	meta:   4,
+}
+
+// Box is a box (or atom) of an ISO base media file. Offset and Size give the
+// position of its content, after its header.
+type Box struct {
+	Type   string
+	UUID   []byte
+	Offset int64
+	Size   int64
+
+	r io.ReaderAt
+}
+
+func (b Box) Reader() *io.SectionReader {
+	return io.NewSectionReader(b.r, b.Offset, b.Size)
+}
+
+func (b Box) Bytes() ([]byte, error) {
+	buf := make([]byte, b.Size)
+	if _, err := io.ReadFull(b.Reader(), buf); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Type, err)
+	}
+	return buf, nil
+}
+
+// Boxes gives the boxes found in a container. The meta box of QuickTime files,
+// which has no version and flags unlike the one of ISO files, is detected by
+// the hdlr box that starts it.
+func (b Box) Boxes() ([]Box, error) {
+	skip, ok := containers[b.Type]
+	if !ok {
+		return nil, fmt.Errorf("%s: not a container: %w", b.Type, ErrFormat)
+	}
+	if b.Type == meta && b.Size >= 8 {
+		buf := make([]byte, 4)
+		if _, err := b.r.ReadAt(buf, b.Offset+4); err != nil {
+			return nil, err
+		}
+		if string(buf) == hdlr {
+			skip = 0
+		}
+	}
+	if skip > b.Size {
+		return nil, fmt.Errorf("%s: %w", b.Type, ErrFormat)
+	}
+	return ReadBoxes(b.r, b.Offset+skip, b.Size-skip)
+}
+
+// Find gives the box found by following the path of types given from a
+// container.
+func (b Box) Find(path ...string) (Box, error) {
+	if len(path) == 0 {
+		return b, nil
+	}
+	list, err := b.Boxes()
+	if err != nil {
+		return b, err
+	}
+	return FindBox(list, path...)
+}
+
+// FindBox gives the first box found by following the path of types given.
+func FindBox(boxes []Box, path ...string) (Box, error) {
+	if len(path) == 0 {
+		return Box{}, fmt.Errorf("%w: empty path", ErrNotFound)
+	}
+	for _, b := range boxes {
+		if b.Type == path[0] {
+			return b.Find(path[1:]...)
+		}
+	}
+	return Box{}, fmt.Errorf("%w: box %s", ErrNotFound, path[0])
+}
+
+// ReadBoxes reads the boxes stored in size bytes from offset. Boxes with a
+// size of 0 extend to the end of the area.
+func ReadBoxes(r io.ReaderAt, offset, size int64) ([]Box, error) {
+	var (
+		list []Box
+		end  = offset + size
+	)
+	for offset+8 <= end {
+		b, err := readBox(r, offset, end)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+		offset = b.Offset + b.Size
+	}
+	return list, nil
+}
+
+func readBox(r io.ReaderAt, offset, end int64) (Box, error) {
+	var (
+		b   = Box{r: r}
+		buf = make([]byte, 16)
+	)
+	if _, err := r.ReadAt(buf[:8], offset); err != nil {
+		return b, err
+	}
+	var (
+		size   = int64(binary.BigEndian.Uint32(buf))
+		header = int64(8)
+	)
+	b.Type = string(buf[4:8])
+	switch size {
+	case 0:
+		size = end - offset
+	case 1:
+		if _, err := r.ReadAt(buf[8:16], offset+8); err != nil {
+			return b, err
+		}
+		size = int64(binary.BigEndian.Uint64(buf[8:]))
+		header += 8
+	}
+	if b.Type == uuid {
+		b.UUID = make([]byte, 16)
+		if _, err := r.ReadAt(b.UUID, offset+header); err != nil {
+			return b, err
+		}
+		header += 16
+	}
+	if size < header || offset+size > end {
+		return b, fmt.Errorf("%s: size %d: %w", b.Type, size, ErrFormat)
+	}
+	b.Offset = offset + header
+	b.Size = size - header
+	return b, nil
+}
+
+// fullBox splits the content of a full box into its version, its flags and
+// the rest of its content.
+func fullBox(buf []byte) (uint8, uint32, []byte, error) {
+	if len(buf) < 4 {
+		return 0, 0, nil, fmt.Errorf("full box: %w", io.ErrUnexpectedEOF)
+	}
+	flags := binary.BigEndian.Uint32(buf) & 0xffffff
+	return buf[0], flags, buf[4:], nil
+}
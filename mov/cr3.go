@@ -0,0 +1,243 @@
+package mov
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/midbel/exif/nef"
+)
+
+const (
+	brandCR3 = "crx "
+
+	cmt1 = "CMT1"
+	cmt2 = "CMT2"
+	cmt3 = "CMT3"
+	cmt4 = "CMT4"
+	thmb = "THMB"
+	prvw = "PRVW"
+	trak = "trak"
+	stsz = "stsz"
+	stco = "stco"
+	co64 = "co64"
+)
+
+var (
+	// uuid of the box of moov holding the metadata of a CR3
+	canonMeta = []byte{0x85, 0xc0, 0xb6, 0x87, 0x82, 0x0f, 0x11, 0xe0, 0x81, 0x11, 0xf4, 0xce, 0x46, 0x2b, 0x6a, 0x48}
+	// uuid of the top level box holding the preview of a CR3
+	canonPreview = []byte{0xea, 0xf4, 0x2b, 0x5e, 0x1c, 0x98, 0x4b, 0x88, 0xb9, 0xfb, 0xb7, 0xdc, 0x40, 0x6e, 0x4d, 0x16}
+)
+
+// IsCR3 reports whether buf is the start of a Canon CR3 file.
+func IsCR3(buf []byte) bool {
+	return len(buf) >= 12 && string(buf[4:8]) == ftyp && string(buf[8:12]) == brandCR3
+}
+
+func DecodeCR3(file string) (*nef.File, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ReadCR3(bytes.NewReader(buf), int64(len(buf)))
+}
+
+// ReadCR3 gives the metadata of a CR3 as a directory: its tags are the ones of
+// the CMT1 to CMT4 boxes (IFD0, Exif, maker notes and GPS) and its image is
+// the thumbnail of the THMB box. Its sub directories are the preview of the
+// PRVW box and the full size JPEG of the first track.
+func ReadCR3(r io.ReaderAt, size int64) (*nef.File, error) {
+	boxes, err := ReadBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(boxes) == 0 || boxes[0].Type != ftyp {
+		return nil, fmt.Errorf("expected %s: %w", ftyp, ErrFormat)
+	}
+	if brand, _, err := readBrands(boxes[0]); err != nil || brand != brandCR3 {
+		return nil, fmt.Errorf("brand %s: %w", brand, ErrFormat)
+	}
+	m, err := FindBox(boxes, moov)
+	if err != nil {
+		return nil, err
+	}
+	list, err := m.Boxes()
+	if err != nil {
+		return nil, err
+	}
+	u, err := findUUID(list, canonMeta)
+	if err != nil {
+		return nil, err
+	}
+	if list, err = ReadBoxes(u.r, u.Offset, u.Size); err != nil {
+		return nil, err
+	}
+
+	var tags [4][]nef.Tag
+	order := binary.ByteOrder(binary.LittleEndian)
+	for i, typ := range []string{cmt1, cmt2, cmt3, cmt4} {
+		b, err := FindBox(list, typ)
+		if err != nil {
+			continue
+		}
+		buf, err := b.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		files, err := nef.Decode(bytes.NewReader(buf))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", typ, err)
+		}
+		if len(files) > 0 {
+			tags[i] = files[0].TagsFor(nef.Tiff)
+			if i == 0 {
+				order = files[0].ByteOrder()
+			}
+		}
+	}
+	var thumb []byte
+	if b, err := FindBox(list, thmb); err == nil {
+		if thumb, err = readJPEG(b); err != nil {
+			return nil, err
+		}
+	}
+	f := newPreview(order, thumb)
+	for _, t := range tags[0] {
+		switch t.Id {
+		case nef.Exif, nef.Gps, nef.Nef:
+		default:
+			f.SetTag(t, nef.Tiff)
+		}
+	}
+	for i, origin := range []int{nef.Exif, nef.Note, nef.Gps} {
+		for _, t := range tags[i+1] {
+			f.SetTag(t, origin)
+		}
+	}
+
+	if u, err := findUUID(boxes, canonPreview); err == nil && u.Size > 8 {
+		list, err := ReadBoxes(u.r, u.Offset+8, u.Size-8)
+		if err != nil {
+			return nil, err
+		}
+		if b, err := FindBox(list, prvw); err == nil {
+			buf, err := readJPEG(b)
+			if err != nil {
+				return nil, err
+			}
+			f.Files = append(f.Files, newPreview(order, buf))
+		}
+	}
+	if buf, err := readTrack(r, m, size); err == nil && len(buf) > 2 && buf[0] == 0xff && buf[1] == 0xd8 {
+		f.Files = append(f.Files, newPreview(order, buf))
+	}
+	f.Index = []int{0}
+	for i, c := range f.Files {
+		c.Index = []int{0, i}
+	}
+	return f, nil
+}
+
+func findUUID(boxes []Box, id []byte) (Box, error) {
+	for _, b := range boxes {
+		if b.Type == uuid && bytes.Equal(b.UUID, id) {
+			return b, nil
+		}
+	}
+	return Box{}, fmt.Errorf("%w: uuid %x", ErrNotFound, id)
+}
+
+// readJPEG gives the JPEG stored in a THMB or PRVW box. Their header (size of
+// the image and of the JPEG) changes with the versions of the box, so the
+// JPEG is looked for from its first marker.
+func readJPEG(b Box) ([]byte, error) {
+	buf, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	x := bytes.Index(buf, []byte{0xff, 0xd8, 0xff})
+	if x < 0 {
+		return nil, fmt.Errorf("%w: jpeg in %s", ErrNotFound, b.Type)
+	}
+	buf = buf[x:]
+	if x := bytes.LastIndex(buf, []byte{0xff, 0xd9}); x > 0 {
+		buf = buf[:x+2]
+	}
+	return buf, nil
+}
+
+// readTrack gives the first sample of the first track of the movie, a full
+// size JPEG in CR3. The sample must fit in the limit bytes of the file.
+func readTrack(r io.ReaderAt, m Box, limit int64) ([]byte, error) {
+	t, err := m.Find(trak, "mdia", "minf", "stbl")
+	if err != nil {
+		return nil, err
+	}
+	list, err := t.Boxes()
+	if err != nil {
+		return nil, err
+	}
+	var size, offset uint64
+	if b, err := FindBox(list, stsz); err == nil {
+		buf, err := b.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		rd := reader{buf: buf}
+		rd.uint(4)
+		if size = uint64(rd.uint(4)); size == 0 && rd.uint(4) > 0 {
+			size = uint64(rd.uint(4))
+		}
+		if rd.err != nil {
+			return nil, fmt.Errorf("%s: %w", stsz, rd.err)
+		}
+	}
+	for _, typ := range []string{co64, stco} {
+		b, err := FindBox(list, typ)
+		if err != nil {
+			continue
+		}
+		buf, err := b.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		rd := reader{buf: buf}
+		rd.uint(4)
+		if rd.uint(4) > 0 {
+			if typ == co64 {
+				offset = rd.uint64(8)
+			} else {
+				offset = uint64(rd.uint(4))
+			}
+		}
+		if rd.err != nil {
+			return nil, fmt.Errorf("%s: %w", typ, rd.err)
+		}
+		break
+	}
+	if size == 0 || offset == 0 {
+		return nil, fmt.Errorf("%w: samples of %s", ErrNotFound, trak)
+	}
+	if offset > uint64(limit) || size > uint64(limit)-offset {
+		return nil, fmt.Errorf("%s: sample %d+%d: %w", trak, offset, size, ErrFormat)
+	}
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, int64(offset)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func newPreview(order binary.ByteOrder, buf []byte) *nef.File {
+	if len(buf) == 0 {
+		return nef.NewFile(order, nil)
+	}
+	return nef.NewFile(order, buf,
+		nef.NewTag(nef.NewSubfileType, nef.Long, order, 1),
+		nef.NewTag(nef.JpegFromRawStart, nef.Long, order, 0),
+		nef.NewTag(nef.JpegFromRawLength, nef.Long, order, uint64(len(buf))),
+	)
+}
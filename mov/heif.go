@@ -0,0 +1,624 @@
+package mov
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/midbel/exif/nef"
+)
+
+const (
+	iinf = "iinf"
+	infe = "infe"
+	iloc = "iloc"
+	iref = "iref"
+	pitm = "pitm"
+	iprp = "iprp"
+	ipco = "ipco"
+	ipma = "ipma"
+	idat = "idat"
+	ispe = "ispe"
+	irot = "irot"
+	imir = "imir"
+)
+
+const (
+	ItemExif = "Exif"
+	ItemMime = "mime"
+	MimeXMP  = "application/rdf+xml"
+	RefDesc  = "cdsc"
+)
+
+var heifBrands = map[string]bool{
+	"heic": true,
+	"heix": true,
+	"heim": true,
+	"heis": true,
+	"mif1": true,
+	"msf1": true,
+	"avif": true,
+	"avis": true,
+}
+
+type Extent struct {
+	Offset uint64
+	Length uint64
+}
+
+// Item is an item of the meta box of a HEIF file: an image, a tile of an
+// image or some metadata.
+type Item struct {
+	Id          uint32
+	Type        string
+	Name        string
+	ContentType string
+	Hidden      bool
+
+	// Method is the construction method of the item: 0 when its extents are
+	// in the file, 1 when they are in the idat box.
+	Method  uint8
+	Base    uint64
+	Extents []Extent
+	// Refs are the items referenced by the item, by type of reference.
+	Refs map[string][]uint32
+	// Props are the indices of the properties of the item in the ipco box,
+	// starting from 1.
+	Props []int
+}
+
+// Image describes the primary image of a HEIF file. Width and Height are the
+// size of the image as coded, before Rotation (anticlockwise, in degrees) and
+// the mirroring are applied.
+type Image struct {
+	Width    int
+	Height   int
+	Rotation int
+	Mirror   bool
+	// Axis is 0 when the image is mirrored about a vertical axis (left and
+	// right are swapped), 1 when it is about an horizontal axis.
+	Axis uint8
+}
+
+// Size gives the size of the image once rotated.
+func (i Image) Size() (int, int) {
+	if i.Rotation == 90 || i.Rotation == 270 {
+		return i.Height, i.Width
+	}
+	return i.Width, i.Height
+}
+
+// HEIF holds the items of a HEIF or AVIF file.
+type HEIF struct {
+	io.Closer
+
+	Brand      string
+	Compatible []string
+	Primary    uint32
+	Items      []Item
+
+	props []Box
+	idat  Box
+	r     io.ReaderAt
+	size  int64
+}
+
+func IsHEIF(brand string, compatible []string) bool {
+	if heifBrands[brand] {
+		return true
+	}
+	for _, b := range compatible {
+		if heifBrands[b] {
+			return true
+		}
+	}
+	return false
+}
+
+func DecodeHEIF(file string) (*HEIF, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	s, err := r.Stat()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	h, err := ReadHEIF(r, s.Size())
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	h.Closer = r
+	return h, nil
+}
+
+// ReadHEIF reads the meta box of a HEIF file of the given size.
+func ReadHEIF(r io.ReaderAt, size int64) (*HEIF, error) {
+	boxes, err := ReadBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(boxes) == 0 || boxes[0].Type != ftyp {
+		return nil, fmt.Errorf("expected %s: %w", ftyp, ErrFormat)
+	}
+	h := HEIF{r: r, size: size}
+	if h.Brand, h.Compatible, err = readBrands(boxes[0]); err != nil {
+		return nil, err
+	}
+	if !IsHEIF(h.Brand, h.Compatible) {
+		return nil, fmt.Errorf("brand %s: %w", h.Brand, ErrFormat)
+	}
+	m, err := FindBox(boxes, meta)
+	if err != nil {
+		return nil, err
+	}
+	list, err := m.Boxes()
+	if err != nil {
+		return nil, err
+	}
+	return &h, h.readMeta(list)
+}
+
+func readBrands(b Box) (string, []string, error) {
+	buf, err := b.Bytes()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(buf) < 8 {
+		return "", nil, fmt.Errorf("%s: %w", ftyp, ErrFormat)
+	}
+	var list []string
+	for i := 8; i+4 <= len(buf); i += 4 {
+		list = append(list, string(buf[i:i+4]))
+	}
+	return string(buf[:4]), list, nil
+}
+
+func (h *HEIF) readMeta(list []Box) error {
+	for _, b := range list {
+		var err error
+		switch b.Type {
+		case pitm:
+			err = h.readPrimary(b)
+		case iinf:
+			err = h.readInfos(b)
+		case idat:
+			h.idat = b
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, b := range list {
+		var err error
+		switch b.Type {
+		case iloc:
+			err = h.readLocations(b)
+		case iref:
+			err = h.readReferences(b)
+		case iprp:
+			err = h.readProperties(b)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HEIF) item(id uint32) *Item {
+	for i := range h.Items {
+		if h.Items[i].Id == id {
+			return &h.Items[i]
+		}
+	}
+	return nil
+}
+
+func (h *HEIF) Item(id uint32) (Item, error) {
+	if i := h.item(id); i != nil {
+		return *i, nil
+	}
+	return Item{}, fmt.Errorf("%w: item %d", ErrNotFound, id)
+}
+
+// ItemData gives the content of an item, made of all its extents.
+func (h *HEIF) ItemData(id uint32) ([]byte, error) {
+	i, err := h.Item(id)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		r     = h.r
+		limit = uint64(h.size)
+		out   []byte
+	)
+	switch i.Method {
+	case 0:
+	case 1:
+		if h.idat.r == nil {
+			return nil, fmt.Errorf("%w: box %s", ErrNotFound, idat)
+		}
+		r, limit = h.idat.Reader(), uint64(h.idat.Size)
+	default:
+		return nil, fmt.Errorf("item %d: construction method %d: %w", id, i.Method, ErrFormat)
+	}
+	for _, e := range i.Extents {
+		if i.Base > limit || e.Offset > limit-i.Base || e.Length > limit-i.Base-e.Offset {
+			return nil, fmt.Errorf("item %d: extent %d+%d: %w", id, e.Offset, e.Length, ErrFormat)
+		}
+		buf := make([]byte, e.Length)
+		if _, err := r.ReadAt(buf, int64(i.Base+e.Offset)); err != nil {
+			return nil, fmt.Errorf("item %d: %w", id, err)
+		}
+		out = append(out, buf...)
+	}
+	return out, nil
+}
+
+// find gives the first item accepted, the one describing the primary image
+// being preferred.
+func (h *HEIF) find(accept func(Item) bool) (Item, error) {
+	var (
+		found Item
+		ok    bool
+	)
+	for _, i := range h.Items {
+		if !accept(i) {
+			continue
+		}
+		for _, r := range i.Refs[RefDesc] {
+			if r == h.Primary {
+				return i, nil
+			}
+		}
+		if !ok {
+			found, ok = i, true
+		}
+	}
+	if !ok {
+		return found, ErrNotFound
+	}
+	return found, nil
+}
+
+// Exif gives the directories stored in the Exif item. The item starts with the
+// offset of the TIFF header from the end of this offset.
+func (h *HEIF) Exif() ([]*nef.File, error) {
+	i, err := h.find(func(i Item) bool { return i.Type == ItemExif })
+	if err != nil {
+		return nil, fmt.Errorf("%w: exif item", err)
+	}
+	buf, err := h.ItemData(i.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("exif item: %w", io.ErrUnexpectedEOF)
+	}
+	offset := 4 + int(binary.BigEndian.Uint32(buf))
+	if offset > len(buf) {
+		return nil, fmt.Errorf("exif item: offset %d: %w", offset, ErrFormat)
+	}
+	return nef.Decode(bytes.NewReader(buf[offset:]))
+}
+
+// XMP gives the XMP packet stored in a mime item.
+func (h *HEIF) XMP() ([]byte, error) {
+	i, err := h.find(func(i Item) bool { return i.Type == ItemMime && i.ContentType == MimeXMP })
+	if err != nil {
+		return nil, fmt.Errorf("%w: xmp item", err)
+	}
+	return h.ItemData(i.Id)
+}
+
+// Image gives the size and the transformations of the primary image.
+func (h *HEIF) Image() (Image, error) {
+	var img Image
+	i, err := h.Item(h.Primary)
+	if err != nil {
+		return img, err
+	}
+	var size bool
+	for _, p := range i.Props {
+		if p <= 0 || p > len(h.props) {
+			continue
+		}
+		b := h.props[p-1]
+		buf, err := b.Bytes()
+		if err != nil {
+			return img, err
+		}
+		switch b.Type {
+		case ispe:
+			_, _, rest, err := fullBox(buf)
+			if err != nil || len(rest) < 8 {
+				return img, fmt.Errorf("%s: %w", ispe, ErrFormat)
+			}
+			img.Width = int(binary.BigEndian.Uint32(rest))
+			img.Height = int(binary.BigEndian.Uint32(rest[4:]))
+			size = true
+		case irot:
+			if len(buf) > 0 {
+				img.Rotation = int(buf[0]&0x3) * 90
+			}
+		case imir:
+			if len(buf) > 0 {
+				img.Mirror, img.Axis = true, buf[0]&0x1
+			}
+		}
+	}
+	if !size {
+		return img, fmt.Errorf("%w: %s of item %d", ErrNotFound, ispe, i.Id)
+	}
+	return img, nil
+}
+
+func (h *HEIF) readPrimary(b Box) error {
+	buf, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	version, _, rest, err := fullBox(buf)
+	if err != nil {
+		return err
+	}
+	rd := reader{buf: rest}
+	h.Primary = rd.uint(idSize(version, 1))
+	return rd.err
+}
+
+func (h *HEIF) readInfos(b Box) error {
+	buf, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	version, _, rest, err := fullBox(buf)
+	if err != nil {
+		return err
+	}
+	skip := 2
+	if version > 0 {
+		skip = 4
+	}
+	if len(rest) < skip {
+		return fmt.Errorf("%s: %w", iinf, io.ErrUnexpectedEOF)
+	}
+	list, err := ReadBoxes(bytes.NewReader(rest), int64(skip), int64(len(rest)-skip))
+	if err != nil {
+		return err
+	}
+	for _, e := range list {
+		if e.Type != infe {
+			continue
+		}
+		buf, err := e.Bytes()
+		if err != nil {
+			return err
+		}
+		i, err := readInfo(buf)
+		if err != nil {
+			return err
+		}
+		h.Items = append(h.Items, i)
+	}
+	return nil
+}
+
+func readInfo(buf []byte) (Item, error) {
+	var i Item
+	version, flags, rest, err := fullBox(buf)
+	if err != nil {
+		return i, err
+	}
+	rd := reader{buf: rest}
+	i.Hidden = flags&1 == 1
+	i.Id = rd.uint(idSize(version, 3))
+	rd.uint(2)
+	if version >= 2 {
+		i.Type = rd.fourcc()
+	}
+	i.Name = rd.string()
+	if version < 2 || i.Type == ItemMime {
+		i.ContentType = rd.string()
+	}
+	return i, rd.err
+}
+
+func (h *HEIF) readLocations(b Box) error {
+	buf, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	version, _, rest, err := fullBox(buf)
+	if err != nil {
+		return err
+	}
+	rd := reader{buf: rest}
+	var (
+		sizes      = rd.uint(2)
+		offsetSize = int(sizes >> 12)
+		lengthSize = int(sizes>>8) & 0xf
+		baseSize   = int(sizes>>4) & 0xf
+		indexSize  int
+		count      = rd.uint(idSize(version, 2))
+	)
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xf)
+	}
+	for n := uint32(0); n < count && rd.err == nil; n++ {
+		var (
+			id     = rd.uint(idSize(version, 2))
+			method uint8
+		)
+		if version == 1 || version == 2 {
+			method = uint8(rd.uint(2) & 0xf)
+		}
+		rd.uint(2)
+		var (
+			base    = rd.uint64(baseSize)
+			extents = make([]Extent, rd.uint(2))
+		)
+		for j := range extents {
+			rd.uint64(indexSize)
+			extents[j].Offset = rd.uint64(offsetSize)
+			extents[j].Length = rd.uint64(lengthSize)
+		}
+		if i := h.item(id); i != nil {
+			i.Method, i.Base, i.Extents = method, base, extents
+		}
+	}
+	return rd.err
+}
+
+func (h *HEIF) readReferences(b Box) error {
+	buf, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	version, _, rest, err := fullBox(buf)
+	if err != nil {
+		return err
+	}
+	list, err := ReadBoxes(bytes.NewReader(rest), 0, int64(len(rest)))
+	if err != nil {
+		return err
+	}
+	size := idSize(version, 1)
+	for _, r := range list {
+		buf, err := r.Bytes()
+		if err != nil {
+			return err
+		}
+		var (
+			rd   = reader{buf: buf}
+			from = rd.uint(size)
+			refs = make([]uint32, rd.uint(2))
+		)
+		for j := range refs {
+			refs[j] = rd.uint(size)
+		}
+		if rd.err != nil {
+			return fmt.Errorf("%s: %w", iref, rd.err)
+		}
+		if i := h.item(from); i != nil {
+			if i.Refs == nil {
+				i.Refs = make(map[string][]uint32)
+			}
+			i.Refs[r.Type] = append(i.Refs[r.Type], refs...)
+		}
+	}
+	return nil
+}
+
+func (h *HEIF) readProperties(b Box) error {
+	list, err := b.Boxes()
+	if err != nil {
+		return err
+	}
+	if c, err := FindBox(list, ipco); err == nil {
+		if h.props, err = c.Boxes(); err != nil {
+			return err
+		}
+	}
+	for _, a := range list {
+		if a.Type != ipma {
+			continue
+		}
+		buf, err := a.Bytes()
+		if err != nil {
+			return err
+		}
+		version, flags, rest, err := fullBox(buf)
+		if err != nil {
+			return err
+		}
+		var (
+			rd    = reader{buf: rest}
+			count = rd.uint(4)
+		)
+		for n := uint32(0); n < count && rd.err == nil; n++ {
+			var (
+				id    = rd.uint(idSize(version, 1))
+				props = make([]int, rd.uint(1))
+			)
+			for j := range props {
+				if flags&1 == 1 {
+					props[j] = int(rd.uint(2) & 0x7fff)
+				} else {
+					props[j] = int(rd.uint(1) & 0x7f)
+				}
+			}
+			if i := h.item(id); i != nil {
+				i.Props = append(i.Props, props...)
+			}
+		}
+		if rd.err != nil {
+			return fmt.Errorf("%s: %w", ipma, rd.err)
+		}
+	}
+	return nil
+}
+
+// idSize gives the size of the item ids in a box: 4 bytes from the given
+// version, 2 before.
+func idSize(version, large uint8) int {
+	if version >= large {
+		return 4
+	}
+	return 2
+}
+
+// reader reads the big endian fields of a box, recording the first error.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.buf) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	buf := r.buf[:n]
+	r.buf = r.buf[n:]
+	return buf
+}
+
+func (r *reader) uint64(n int) uint64 {
+	var v uint64
+	for _, b := range r.next(n) {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func (r *reader) uint(n int) uint32 {
+	return uint32(r.uint64(n))
+}
+
+func (r *reader) fourcc() string {
+	return string(r.next(4))
+}
+
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	x := bytes.IndexByte(r.buf, 0)
+	if x < 0 {
+		str := string(r.buf)
+		r.buf = nil
+		return str
+	}
+	str := string(r.buf[:x])
+	r.buf = r.buf[x+1:]
+	return str
+}
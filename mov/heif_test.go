@@ -0,0 +1,225 @@
+package mov
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/midbel/exif/nef"
+)
+
+func testBox(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	buf := make([]byte, 4, 8+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(8+len(body)))
+	return append(append(buf, typ...), body...)
+}
+
+func testFullBox(typ string, version uint8, flags uint32, parts ...[]byte) []byte {
+	head := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return testBox(typ, append([][]byte{head}, parts...)...)
+}
+
+// be gives v as a big endian integer of n bytes.
+func be(v uint64, n int) []byte {
+	buf := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		buf[i], v = byte(v), v>>8
+	}
+	return buf
+}
+
+// ilocLayout gives the version and the size of the fields of an iloc box.
+type ilocLayout struct {
+	Version    uint8
+	OffsetSize int
+	LengthSize int
+	BaseSize   int
+	IndexSize  int
+}
+
+// testItem is an item of a HEIF file built by heifFile. Its data are stored
+// in the idat box when Idat is set, after the meta box otherwise.
+type testItem struct {
+	Id   uint32
+	Type string
+	Mime string
+	Data []byte
+	Idat bool
+	Refs []uint32
+}
+
+// heifFile gives a HEIF file whose primary item is the first one, made of an
+// ispe, an irot and an imir properties.
+func heifFile(layout ilocLayout, items []testItem) []byte {
+	idsize := 2
+	if layout.Version >= 2 {
+		idsize = 4
+	}
+	build := func(start uint64) ([]byte, int) {
+		var (
+			infos []byte
+			locs  []byte
+			refs  []byte
+			inner []byte
+			data  []byte
+		)
+		locs = append(locs, byte(layout.OffsetSize<<4|layout.LengthSize), byte(layout.BaseSize<<4|layout.IndexSize))
+		locs = append(locs, be(uint64(len(items)), idsize)...)
+		for _, i := range items {
+			info := [][]byte{be(uint64(i.Id), 2), be(0, 2), []byte(i.Type), {0}}
+			if i.Mime != "" {
+				info = append(info, []byte(i.Mime+"\x00"))
+			}
+			infos = append(infos, testFullBox(infe, 2, 0, info...)...)
+
+			var (
+				method uint64
+				base   uint64
+				offset uint64
+			)
+			if i.Idat {
+				method, offset = 1, uint64(len(inner))
+				inner = append(inner, i.Data...)
+			} else {
+				offset = start + uint64(len(data))
+				data = append(data, i.Data...)
+			}
+			if layout.BaseSize > 0 && !i.Idat {
+				base, offset = start, offset-start
+			}
+			locs = append(locs, be(uint64(i.Id), idsize)...)
+			if layout.Version >= 1 {
+				locs = append(locs, be(method, 2)...)
+			}
+			locs = append(locs, be(0, 2)...)
+			locs = append(locs, be(base, layout.BaseSize)...)
+			locs = append(locs, be(1, 2)...)
+			locs = append(locs, be(0, layout.IndexSize)...)
+			locs = append(locs, be(offset, layout.OffsetSize)...)
+			locs = append(locs, be(uint64(len(i.Data)), layout.LengthSize)...)
+
+			if len(i.Refs) > 0 {
+				ref := [][]byte{be(uint64(i.Id), 2), be(uint64(len(i.Refs)), 2)}
+				for _, r := range i.Refs {
+					ref = append(ref, be(uint64(r), 2))
+				}
+				refs = append(refs, testBox(RefDesc, ref...)...)
+			}
+		}
+		var (
+			ispeBox = testFullBox(ispe, 0, 0, be(64, 4), be(48, 4))
+			ipcoBox = testBox(ipco, ispeBox, testBox(irot, []byte{1}), testBox(imir, []byte{1}))
+			ipmaBox = testFullBox(ipma, 0, 0, be(1, 4), be(uint64(items[0].Id), 2), []byte{3, 0x81, 2, 3})
+			hdlrBox = testFullBox(hdlr, 0, 0, be(0, 4), []byte("pict"), make([]byte, 13))
+		)
+		metaBox := testFullBox(meta, 0, 0,
+			hdlrBox,
+			testFullBox(pitm, 0, 0, be(uint64(items[0].Id), 2)),
+			testFullBox(iinf, 0, 0, be(uint64(len(items)), 2), infos),
+			testFullBox(iloc, layout.Version, 0, locs),
+			testFullBox(iref, 0, 0, refs),
+			testBox(iprp, ipcoBox, ipmaBox),
+			testBox(idat, inner),
+		)
+		file := testBox(ftyp, []byte("heic"), be(0, 4), []byte("mif1heic"))
+		file = append(file, metaBox...)
+		return append(file, testBox(mdat, data)...), len(data)
+	}
+	// the size of the boxes does not depend on the offsets of the data
+	file, size := build(0)
+	file, _ = build(uint64(len(file) - size))
+	return file
+}
+
+func exifItem(t *testing.T, model string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	f := nef.NewFile(binary.BigEndian, nil, nef.NewString(0x110, model))
+	if err := nef.Encode(&buf, []*nef.File{f}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return append([]byte("\x00\x00\x00\x06Exif\x00\x00"), buf.Bytes()...)
+}
+
+func TestReadHEIF(t *testing.T) {
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`)
+	data := []struct {
+		Name   string
+		Layout ilocLayout
+		Idat   bool
+	}{
+		{Name: "v0", Layout: ilocLayout{Version: 0, OffsetSize: 4, LengthSize: 4}},
+		{Name: "v0-base", Layout: ilocLayout{Version: 0, OffsetSize: 4, LengthSize: 2, BaseSize: 8}},
+		{Name: "v1", Layout: ilocLayout{Version: 1, OffsetSize: 8, LengthSize: 4, BaseSize: 4}, Idat: true},
+		{Name: "v2", Layout: ilocLayout{Version: 2, OffsetSize: 4, LengthSize: 8, IndexSize: 4}, Idat: true},
+	}
+	for _, d := range data {
+		items := []testItem{
+			{Id: 1, Type: "hvc1", Data: []byte{0, 0, 0, 1}},
+			{Id: 2, Type: ItemExif, Data: exifItem(t, "OTHER")},
+			{Id: 3, Type: ItemMime, Mime: MimeXMP, Data: xmp, Idat: d.Idat, Refs: []uint32{1}},
+			{Id: 4, Type: ItemExif, Data: exifItem(t, "PRIMARY"), Refs: []uint32{1}},
+		}
+		buf := heifFile(d.Layout, items)
+		h, err := ReadHEIF(bytes.NewReader(buf), int64(len(buf)))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if h.Brand != "heic" || h.Primary != 1 || len(h.Items) != len(items) {
+			t.Errorf("%s: brand %s, primary %d, %d items", d.Name, h.Brand, h.Primary, len(h.Items))
+			continue
+		}
+		for _, i := range items {
+			got, err := h.ItemData(i.Id)
+			if err != nil || !bytes.Equal(got, i.Data) {
+				t.Errorf("%s: item %d mismatched: want %x, got %x (%v)", d.Name, i.Id, i.Data, got, err)
+			}
+		}
+		if it, _ := h.Item(3); d.Idat && it.Method != 1 {
+			t.Errorf("%s: xmp should be built from idat", d.Name)
+		}
+		files, err := h.Exif()
+		if err != nil || len(files) == 0 {
+			t.Errorf("%s: exif: %v", d.Name, err)
+		} else if m, err := files[0].GetTag(0x110, nef.Tiff); err != nil || strings.TrimSpace(m.String()) != "PRIMARY" {
+			t.Errorf("%s: exif of the primary item should be preferred, got %q (%v)", d.Name, m.String(), err)
+		}
+		if got, err := h.XMP(); err != nil || !bytes.Equal(got, xmp) {
+			t.Errorf("%s: xmp mismatched: %q (%v)", d.Name, got, err)
+		}
+		img, err := h.Image()
+		if err != nil {
+			t.Errorf("%s: image: %s", d.Name, err)
+			continue
+		}
+		want := Image{Width: 64, Height: 48, Rotation: 90, Mirror: true, Axis: 1}
+		if img != want {
+			t.Errorf("%s: image mismatched: want %+v, got %+v", d.Name, want, img)
+		}
+		if w, h := img.Size(); w != 48 || h != 64 {
+			t.Errorf("%s: rotated size mismatched: got %dx%d", d.Name, w, h)
+		}
+	}
+}
+
+func TestHEIFExtentOutOfFile(t *testing.T) {
+	items := []testItem{
+		{Id: 1, Type: "hvc1", Data: []byte{0, 0, 0, 1}},
+		{Id: 2, Type: ItemExif, Data: []byte{0, 0, 0, 0}},
+	}
+	buf := heifFile(ilocLayout{Version: 1, OffsetSize: 8, LengthSize: 8}, items)
+	h, err := ReadHEIF(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, e := range []Extent{{Offset: 0, Length: 1 << 62}, {Offset: 1<<64 - 1, Length: 2}} {
+		h.item(2).Extents = []Extent{e}
+		if _, err := h.Exif(); !errors.Is(err, ErrFormat) {
+			t.Errorf("extent %d+%d: want ErrFormat, got %v", e.Offset, e.Length, err)
+		}
+	}
+}
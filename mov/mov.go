@@ -1,12 +1,13 @@
 package mov
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -25,6 +26,7 @@ const (
 	moov  = "moov"
 	ftyp  = "ftyp"
 	quick = "qt"
+	xyz   = "\xa9xyz"
 )
 
 type Profile struct {
@@ -62,9 +64,26 @@ func (p Profile) ModTime() time.Time {
 	return time.Unix(int64(p.Modified), 0).Add(delta)
 }
 
+// quickAtoms are the atoms that can start the QuickTime files written before
+// the ftyp atom was introduced.
+var quickAtoms = map[string]bool{
+	moov:   true,
+	mdat:   true,
+	"wide": true,
+	"free": true,
+	"skip": true,
+	"pnot": true,
+}
+
+// IsQuickTime reports whether buf is the start of a QuickTime file without
+// ftyp atom.
+func IsQuickTime(buf []byte) bool {
+	return len(buf) >= 8 && quickAtoms[string(buf[4:8])]
+}
+
 type File struct {
 	io.Closer
-	atoms map[string]*io.SectionReader
+	boxes []Box
 }
 
 func Decode(file string) (*File, error) {
@@ -72,94 +91,116 @@ func Decode(file string) (*File, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := readMagic(r); err != nil {
+	f, err := readAtoms(r)
+	if err != nil {
+		r.Close()
 		return nil, err
 	}
-	return readAtoms(r)
+	return f, nil
+}
+
+// Boxes gives the top level boxes of the file.
+func (f File) Boxes() []Box {
+	return append([]Box{}, f.boxes...)
+}
+
+// Find gives the box found by following the path of types given from the top
+// level boxes of the file.
+func (f File) Find(path ...string) (Box, error) {
+	return FindBox(f.boxes, path...)
 }
 
 func (f File) DecodeProfile() (Profile, error) {
 	var p Profile
-	r, ok := f.atoms[moov]
-	if !ok {
-		return p, fmt.Errorf("%w: atoms %s", ErrNotFound, moov)
-	}
-	if _, err := r.Seek(0, io.SeekStart); err != nil {
+	b, err := f.Find(moov, mvhd)
+	if err != nil {
 		return p, err
 	}
-	rs, err := findAtom(mvhd, r)
+	return p, binary.Read(b.Reader(), binary.BigEndian, &p)
+}
+
+func readAtoms(r *os.File) (*File, error) {
+	s, err := r.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f, err := Read(r, s.Size())
 	if err != nil {
-		return p, nil
+		return nil, err
 	}
-	return p, binary.Read(rs, binary.BigEndian, &p)
+	f.Closer = r
+	return f, nil
 }
 
-func findAtom(atom string, r io.ReadSeeker) (io.Reader, error) {
-	var (
-		buf = make([]byte, 8)
-		rs  io.Reader
-	)
-	for {
-		if _, err := io.ReadFull(r, buf); err != nil {
-			return nil, err
-		}
-		size := binary.BigEndian.Uint32(buf) - 8
-		if string(buf[4:]) == atom {
-			buf = make([]byte, int(size))
-			if _, err := io.ReadFull(r, buf); err != nil {
-				return nil, err
-			}
-			rs = bytes.NewReader(buf)
-			break
-		}
-		if _, err := r.Seek(int64(size), io.SeekCurrent); err != nil {
-			return nil, err
-		}
+// Read reads the top level boxes of a file of the given size, starting with
+// an ftyp box or, for older QuickTime files, with one of their atoms. Closing
+// the returned file does nothing.
+func Read(r io.ReaderAt, size int64) (*File, error) {
+	boxes, err := ReadBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(boxes) == 0 || (boxes[0].Type != ftyp && !quickAtoms[boxes[0].Type]) {
+		return nil, fmt.Errorf("expected %s: %w", ftyp, ErrFormat)
 	}
-	if rs == nil {
-		return nil, fmt.Errorf("%w: atom %s", ErrNotFound, atom)
+	f := File{
+		Closer: ioutil.NopCloser(nil),
+		boxes:  boxes,
 	}
-	return rs, nil
+	return &f, nil
 }
 
-func readAtoms(r *os.File) (*File, error) {
+// Brand gives the major brand of the file and its compatible brands.
+func (f File) Brand() (string, []string, error) {
+	b, err := f.Find(ftyp)
+	if err != nil {
+		return "", nil, err
+	}
+	return readBrands(b)
+}
+
+// Location gives the latitude and the longitude stored by cameras and phones
+// in the ©xyz box of the user data, as an ISO 6709 string.
+func (f File) Location() (float64, float64, error) {
+	b, err := f.Find(moov, "udta", xyz)
+	if err != nil {
+		return 0, 0, err
+	}
+	buf, err := b.Bytes()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(buf) < 4 {
+		return 0, 0, fmt.Errorf("%s: %w", xyz, ErrFormat)
+	}
+	return parseISO6709(string(buf[4:]))
+}
+
+// parseISO6709 reads the latitude and the longitude in decimal degrees of a
+// string like +48.8577+002.2950+035.000/.
+func parseISO6709(str string) (float64, float64, error) {
 	var (
-		buf   = make([]byte, 8)
-		atoms = make(map[string]*io.SectionReader)
+		parts []float64
+		start = -1
 	)
-	for {
-		if _, err := io.ReadFull(r, buf); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return nil, err
+	for i := 0; i <= len(str); i++ {
+		if i < len(str) && start >= 0 && str[i] != '+' && str[i] != '-' && str[i] != '/' {
+			continue
 		}
-		size := binary.BigEndian.Uint32(buf)
-		tell, err := r.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return nil, err
+		if start >= 0 {
+			v, err := strconv.ParseFloat(str[start:i], 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("%s: %w", str, ErrFormat)
+			}
+			parts = append(parts, v)
+			start = -1
 		}
-		atoms[string(buf[4:])] = io.NewSectionReader(r, tell, int64(size)-8)
-		if _, err := r.Seek(int64(size)-8, io.SeekCurrent); err != nil {
-			return nil, err
+		if i < len(str) && (str[i] == '+' || str[i] == '-') {
+			start = i
 		}
 	}
-	f := File{
-		Closer: r,
-		atoms:  atoms,
-	}
-	return &f, nil
-}
-
-func readMagic(r io.ReadSeeker) error {
-	buf := make([]byte, 8)
-	if _, err := io.ReadFull(r, buf); err != nil {
-		return err
-	}
-	if string(buf[4:]) != ftyp {
-		return fmt.Errorf("expected %s, got %s", ftyp, buf[4:])
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("%s: %w", str, ErrFormat)
 	}
-	size := binary.BigEndian.Uint32(buf[:4])
-	_, err := r.Seek(int64(size)-8, io.SeekCurrent)
-	return err
+	return parts[0], parts[1], nil
 }
@@ -0,0 +1,71 @@
+package mov
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseISO6709(t *testing.T) {
+	data := []struct {
+		Str string
+		Lat float64
+		Lon float64
+		Err bool
+	}{
+		{Str: "+48.8577+002.2950/", Lat: 48.8577, Lon: 2.295},
+		{Str: "+48.8577+002.2950+035.000/", Lat: 48.8577, Lon: 2.295},
+		{Str: "-33.8688+151.2093/", Lat: -33.8688, Lon: 151.2093},
+		{Str: "+40.7128-074.0060", Lat: 40.7128, Lon: -74.006},
+		{Str: "-00.5000-000.2500-010.0/", Lat: -0.5, Lon: -0.25},
+		{Str: "+48.8577/", Err: true},
+		{Str: "", Err: true},
+		{Str: "+4x.85+002.29/", Err: true},
+	}
+	for _, d := range data {
+		lat, lon, err := parseISO6709(d.Str)
+		if d.Err {
+			if err == nil {
+				t.Errorf("%q: should fail", d.Str)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", d.Str, err)
+			continue
+		}
+		if math.Abs(lat-d.Lat) > 1e-9 || math.Abs(lon-d.Lon) > 1e-9 {
+			t.Errorf("%q: want %f,%f, got %f,%f", d.Str, d.Lat, d.Lon, lat, lon)
+		}
+	}
+}
+
+func TestDecodeProfile(t *testing.T) {
+	var (
+		p    = Profile{TimeScale: 600, Duration: 6000, Created: 3786912000}
+		body bytes.Buffer
+	)
+	binary.Write(&body, binary.BigEndian, p)
+	buf := testBox(ftyp, []byte("qt  "), be(0, 4), []byte("qt  "))
+	buf = append(buf, testBox(moov, testBox(mvhd, body.Bytes()))...)
+
+	f, err := Read(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := f.DecodeProfile()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got != p {
+		t.Errorf("profile mismatched: want %+v, got %+v", p, got)
+	}
+	if got.Length() != 10*time.Second {
+		t.Errorf("length mismatched: want 10s, got %s", got.Length())
+	}
+	if want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); !got.AcqTime().Equal(want) {
+		t.Errorf("time mismatched: want %s, got %s", want, got.AcqTime())
+	}
+}
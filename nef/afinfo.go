@@ -0,0 +1,447 @@
+package nef
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+const (
+	noteAFInfo = 0xb7
+	tiffModel  = 0x110
+)
+
+const (
+	AFSchemeOff uint8 = iota
+	AFScheme51
+	AFScheme11
+	AFScheme39
+	AFScheme73
+	AFScheme5
+	AFScheme105
+	AFScheme153
+	AFScheme81
+	AFScheme105b
+)
+
+var afAreaModes = map[uint8]string{
+	0:   "single area",
+	1:   "dynamic area",
+	2:   "dynamic area (closest subject)",
+	3:   "group dynamic",
+	4:   "dynamic area (9 points)",
+	5:   "dynamic area (21 points)",
+	6:   "dynamic area (51 points)",
+	7:   "dynamic area (51 points, 3d-tracking)",
+	8:   "auto-area",
+	9:   "dynamic area (3d-tracking)",
+	10:  "single area (wide)",
+	11:  "dynamic area (wide)",
+	12:  "dynamic area (wide, 3d-tracking)",
+	13:  "group area",
+	14:  "dynamic area (25 points)",
+	15:  "dynamic area (72 points)",
+	16:  "group area (hl)",
+	17:  "group area (vl)",
+	18:  "dynamic area (49 points)",
+	128: "single",
+	129: "auto (41 points)",
+	130: "subject tracking (41 points)",
+	131: "face priority (41 points)",
+	192: "pinpoint",
+	193: "single",
+	194: "dynamic",
+	195: "wide (s)",
+	196: "wide (l)",
+	197: "auto",
+}
+
+var afContrastModes = map[uint8]string{
+	0:   "contrast-detect",
+	1:   "contrast-detect (normal area)",
+	2:   "contrast-detect (wide area)",
+	3:   "contrast-detect (face priority)",
+	4:   "contrast-detect (subject tracking)",
+	128: "single",
+	129: "auto (41 points)",
+	130: "subject tracking (41 points)",
+	131: "face priority (41 points)",
+	192: "pinpoint",
+	193: "single",
+	194: "dynamic",
+	195: "wide (s)",
+	196: "wide (l)",
+	197: "auto",
+	198: "auto (people)",
+	199: "auto (animal)",
+	200: "normal-area af",
+	201: "wide-area af",
+	202: "face-priority af",
+	203: "subject-tracking af",
+	204: "dynamic area (s)",
+	205: "dynamic area (m)",
+	206: "dynamic area (l)",
+	207: "3d-tracking",
+	208: "wide-area (c1/c2)",
+}
+
+// afPosition is a focus point of a scheme, with its position from the centre
+// of the frame in units of the spacing of the points.
+type afPosition struct {
+	name string
+	x    float64
+	y    float64
+}
+
+// afScheme lists the points of a focus scheme in the order the camera numbers
+// them, which starts from the centre of the frame. cover is the share of the
+// frame spanned by the points, an approximation that is the same for every
+// body using the scheme.
+type afScheme struct {
+	points []afPosition
+	cover  [2]float64
+}
+
+var afSchemes = map[uint8]afScheme{
+	AFScheme11: {
+		points: []afPosition{
+			{"Center", 0, 0},
+			{"Top", 0, -1},
+			{"Bottom", 0, 1},
+			{"Mid-left", -1, 0},
+			{"Mid-right", 1, 0},
+			{"Upper-left", -1, -1},
+			{"Upper-right", 1, -1},
+			{"Lower-left", -1, 1},
+			{"Lower-right", 1, 1},
+			{"Far Left", -2, 0},
+			{"Far Right", 2, 0},
+		},
+		cover: [2]float64{0.55, 0.35},
+	},
+	AFScheme39: {
+		points: gridPoints([]int{3, 11, 11, 11, 3},
+			"C6", "B6", "A2", "D6", "E2", "C7", "B7", "A3", "D7", "E3",
+			"C5", "B5", "A1", "D5", "E1", "C8", "B8", "D8", "C9", "B9",
+			"D9", "C10", "B10", "D10", "C11", "B11", "D11", "C4", "B4", "D4",
+			"C3", "B3", "D3", "C2", "B2", "D2", "C1", "B1", "D1",
+		),
+		cover: [2]float64{0.55, 0.3},
+	},
+	AFScheme51: {
+		points: gridPoints([]int{9, 11, 11, 11, 9},
+			"C6", "B6", "A5", "D6", "E5", "C7", "B7", "A6", "D7", "E6",
+			"C5", "B5", "A4", "D5", "E4", "C8", "B8", "A7", "D8", "E7",
+			"C9", "B9", "A8", "D9", "E8", "C10", "B10", "A9", "D10", "E9",
+			"C11", "B11", "D11", "C4", "B4", "A3", "D4", "E3", "C3", "B3",
+			"A2", "D3", "E2", "C2", "B2", "A1", "D2", "E1", "C1", "B1",
+			"D1",
+		),
+		cover: [2]float64{0.55, 0.4},
+	},
+}
+
+// afPointCounts gives the number of points of each scheme, which sets the
+// size of the bit fields of the points used and in focus. Schemes missing from
+// afSchemes have no known numbering of their points.
+var afPointCounts = map[uint8]int{
+	AFScheme51:   51,
+	AFScheme11:   11,
+	AFScheme39:   39,
+	AFScheme73:   73,
+	AFScheme5:    5,
+	AFScheme105:  105,
+	AFScheme153:  153,
+	AFScheme81:   81,
+	AFScheme105b: 105,
+}
+
+// gridPoints gives the position of points named by their row, a letter from
+// the top, and their column, a number from the left. rows gives the number of
+// points of each row, shorter rows being centred.
+func gridPoints(rows []int, names ...string) []afPosition {
+	list := make([]afPosition, 0, len(names))
+	for _, n := range names {
+		var (
+			r    = int(n[0] - 'A')
+			c, _ = strconv.Atoi(n[1:])
+			p    = afPosition{name: n}
+		)
+		if r < 0 || r >= len(rows) {
+			continue
+		}
+		p.x = float64(c-1) - float64(rows[r]-1)/2
+		p.y = float64(r) - float64(len(rows)-1)/2
+		list = append(list, p)
+	}
+	return list
+}
+
+// afLayout gives the position of each field in a given version of the
+// AFInfo2 block. A negative position means the field is not recorded.
+type afLayout struct {
+	scheme  int
+	primary int
+	used    int
+	focus   int
+	area    int
+	inarea  int
+}
+
+var afLayouts = map[string]afLayout{
+	"0100": {scheme: 6, primary: 7, used: 8, focus: -1, area: 0x10, inarea: 0x1c},
+	"0101": {scheme: 6, primary: 7, used: 8, focus: -1, area: 0x10, inarea: 0x1c},
+	"0200": {scheme: 6, primary: 7, used: 8, focus: -1, area: 0x10, inarea: 0x1c},
+	"0300": {scheme: 6, primary: 8, used: 0x0a, focus: 0x3e, area: 0x2a, inarea: -1},
+	"0400": {scheme: -1, primary: -1, used: -1, focus: -1, area: 0x3e, inarea: 0x4a},
+}
+
+type AFPoint struct {
+	Index int
+	Name  string
+	X     int
+	Y     int
+}
+
+type AFInfo struct {
+	Version    string
+	ContrastAF bool
+	PhaseAF    bool
+	AreaMode   uint8
+	Scheme     uint8
+	Primary    int
+	Used       []int
+	// InFocus is empty for the versions of AFInfo2 that do not record the
+	// points in focus.
+	InFocus       []int
+	ContrastFocus bool
+
+	ImageWidth  int
+	ImageHeight int
+	Area        image.Rectangle
+
+	Model string
+}
+
+func (a AFInfo) Mode() string {
+	var (
+		str string
+		ok  bool
+	)
+	if a.ContrastAF {
+		str, ok = afContrastModes[a.AreaMode]
+	} else {
+		str, ok = afAreaModes[a.AreaMode]
+	}
+	if !ok {
+		str = fmt.Sprintf("other (%d)", a.AreaMode)
+	}
+	return str
+}
+
+func (a AFInfo) PointCount() int {
+	return afPointCounts[a.Scheme]
+}
+
+// Points gives the position of every focus point of the camera in an image of
+// the given dimensions, assuming this image covers the full sensor. Points are
+// given in the order the camera numbers them. Schemes whose numbering is not
+// known give no points.
+func (a AFInfo) Points(width, height int) []AFPoint {
+	s, ok := afSchemes[a.Scheme]
+	if !ok {
+		return nil
+	}
+	var spanx, spany float64
+	for _, p := range s.points {
+		spanx = math.Max(spanx, math.Abs(p.x))
+		spany = math.Max(spany, math.Abs(p.y))
+	}
+	var (
+		ps = make([]AFPoint, 0, len(s.points))
+		cx = float64(width) / 2
+		cy = float64(height) / 2
+		dx = s.cover[0] * float64(width) / 2
+		dy = s.cover[1] * float64(height) / 2
+	)
+	for i, p := range s.points {
+		pt := AFPoint{
+			Index: i + 1,
+			Name:  p.name,
+			X:     int(cx),
+			Y:     int(cy),
+		}
+		if spanx > 0 {
+			pt.X = int(cx + dx*p.x/spanx)
+		}
+		if spany > 0 {
+			pt.Y = int(cy + dy*p.y/spany)
+		}
+		ps = append(ps, pt)
+	}
+	return ps
+}
+
+// PrimaryPoint gives the position of the primary focus point in an image of
+// the given dimensions.
+func (a AFInfo) PrimaryPoint(width, height int) (AFPoint, error) {
+	var pt AFPoint
+	if a.Primary <= 0 {
+		return pt, fmt.Errorf("primary af point: %w", ErrExist)
+	}
+	ps := a.Points(width, height)
+	if a.Primary > len(ps) {
+		return pt, fmt.Errorf("primary af point %d: %w", a.Primary, ErrExist)
+	}
+	return ps[a.Primary-1], nil
+}
+
+// UsedPoints gives the position of the points used by the camera to focus in
+// an image of the given dimensions.
+func (a AFInfo) UsedPoints(width, height int) []AFPoint {
+	return selectPoints(a.Points(width, height), a.Used)
+}
+
+// FocusPoints gives the position of the points reported in focus in an image
+// of the given dimensions.
+func (a AFInfo) FocusPoints(width, height int) []AFPoint {
+	return selectPoints(a.Points(width, height), a.InFocus)
+}
+
+// FocusArea gives the contrast-detect focus area scaled to an image of the
+// given dimensions.
+func (a AFInfo) FocusArea(width, height int) image.Rectangle {
+	if a.ImageWidth == 0 || a.ImageHeight == 0 || a.Area.Empty() {
+		return image.Rectangle{}
+	}
+	scale := func(v, from, to int) int {
+		return v * to / from
+	}
+	return image.Rect(
+		scale(a.Area.Min.X, a.ImageWidth, width),
+		scale(a.Area.Min.Y, a.ImageHeight, height),
+		scale(a.Area.Max.X, a.ImageWidth, width),
+		scale(a.Area.Max.Y, a.ImageHeight, height),
+	)
+}
+
+func (a AFInfo) String() string {
+	var str []string
+	if a.PhaseAF {
+		str = append(str, "phase-detect")
+	}
+	if a.ContrastAF {
+		str = append(str, "contrast-detect")
+	}
+	if len(str) == 0 {
+		str = append(str, "off")
+	}
+	str = append(str, a.Mode())
+	if a.Primary > 0 {
+		str = append(str, fmt.Sprintf("primary: %d", a.Primary))
+	}
+	if len(a.Used) > 0 {
+		str = append(str, fmt.Sprintf("used: %v", a.Used))
+	}
+	if len(a.InFocus) > 0 {
+		str = append(str, fmt.Sprintf("in focus: %v", a.InFocus))
+	}
+	if !a.Area.Empty() {
+		str = append(str, fmt.Sprintf("area: %s", a.Area))
+	}
+	return fmt.Sprintf("%s (%s)", a.Version, strings.Join(str, ", "))
+}
+
+func (f File) AFInfo() (AFInfo, error) {
+	t, err := f.GetTag(noteAFInfo, Note)
+	if err != nil {
+		return AFInfo{}, err
+	}
+	a, err := DecodeAFInfo(t)
+	if err != nil {
+		return a, err
+	}
+	if m, err := f.get(tiffModel); err == nil {
+		a.Model = strings.TrimSpace(m.String())
+	}
+	return a, nil
+}
+
+func DecodeAFInfo(t Tag) (AFInfo, error) {
+	var (
+		a   AFInfo
+		buf = t.Raw
+	)
+	if t.Id != noteAFInfo || len(buf) < 8 {
+		return a, fmt.Errorf("af info: %w", ErrFormat)
+	}
+	a.Version = string(buf[:4])
+	layout, ok := afLayouts[a.Version]
+	if !ok {
+		return a, fmt.Errorf("af info version %s: %w", a.Version, ErrFormat)
+	}
+	a.ContrastAF = buf[4] != 0
+	a.AreaMode = buf[5]
+	if layout.scheme >= 0 {
+		a.Scheme = buf[layout.scheme]
+		a.PhaseAF = a.Scheme != AFSchemeOff
+	}
+	if layout.primary >= 0 && layout.primary < len(buf) {
+		a.Primary = int(buf[layout.primary])
+	}
+	if size := (a.PointCount() + 7) / 8; layout.used >= 0 && size > 0 {
+		a.Used = readPoints(buf, layout.used, size)
+		if layout.focus >= 0 {
+			a.InFocus = readPoints(buf, layout.focus, size)
+		}
+	}
+	if layout.area >= 0 && layout.area+12 <= len(buf) {
+		var (
+			order = t.ByteOrder()
+			pos   = buf[layout.area:]
+		)
+		a.ImageWidth = int(order.Uint16(pos[0:]))
+		a.ImageHeight = int(order.Uint16(pos[2:]))
+		var (
+			x = int(order.Uint16(pos[4:]))
+			y = int(order.Uint16(pos[6:]))
+			w = int(order.Uint16(pos[8:]))
+			h = int(order.Uint16(pos[10:]))
+		)
+		if w > 0 && h > 0 {
+			a.Area = image.Rect(x-w/2, y-h/2, x+w/2, y+h/2)
+		}
+	}
+	if layout.inarea >= 0 && layout.inarea < len(buf) {
+		a.ContrastFocus = buf[layout.inarea] != 0
+	}
+	return a, nil
+}
+
+func readPoints(buf []byte, at, size int) []int {
+	if at+size > len(buf) {
+		return nil
+	}
+	var ps []int
+	for i, b := range buf[at : at+size] {
+		for b != 0 {
+			j := bits.TrailingZeros8(b)
+			ps = append(ps, i*8+j+1)
+			b &^= 1 << j
+		}
+	}
+	return ps
+}
+
+func selectPoints(ps []AFPoint, which []int) []AFPoint {
+	var list []AFPoint
+	for _, i := range which {
+		if i > 0 && i <= len(ps) {
+			list = append(list, ps[i-1])
+		}
+	}
+	return list
+}
@@ -0,0 +1,55 @@
+package nef
+
+import "testing"
+
+func TestAFPoints(t *testing.T) {
+	data := []struct {
+		Scheme uint8
+		Names  []string
+	}{
+		{Scheme: AFScheme51, Names: []string{"C6", "B6", "A5", "D6", "E5", "C7"}},
+		{Scheme: AFScheme39, Names: []string{"C6", "B6", "A2", "D6", "E2", "C7"}},
+		{Scheme: AFScheme11, Names: []string{"Center", "Top", "Bottom", "Mid-left"}},
+	}
+	for _, d := range data {
+		a := AFInfo{Scheme: d.Scheme}
+		ps := a.Points(600, 400)
+		if len(ps) != a.PointCount() {
+			t.Errorf("scheme %d: points mismatched: want %d, got %d", d.Scheme, a.PointCount(), len(ps))
+			continue
+		}
+		for i, n := range d.Names {
+			if ps[i].Name != n || ps[i].Index != i+1 {
+				t.Errorf("scheme %d: point %d: want %s, got %s (%d)", d.Scheme, i+1, n, ps[i].Name, ps[i].Index)
+			}
+		}
+		if c := ps[0]; c.X != 300 || c.Y != 200 {
+			t.Errorf("scheme %d: first point should be at the centre, got %d,%d", d.Scheme, c.X, c.Y)
+		}
+	}
+}
+
+func TestAFPointsUnknown(t *testing.T) {
+	a := AFInfo{Scheme: AFScheme153}
+	if ps := a.Points(600, 400); len(ps) != 0 {
+		t.Errorf("scheme without numbering should give no points, got %d", len(ps))
+	}
+	if a.PointCount() != 153 {
+		t.Errorf("points count mismatched: want 153, got %d", a.PointCount())
+	}
+}
+
+func TestDecodeAFInfoNoFocus(t *testing.T) {
+	raw := make([]byte, 0x30)
+	copy(raw, "0100")
+	raw[6] = AFScheme11
+	raw[7] = 1
+	raw[8] = 0x03
+	a, err := DecodeAFInfo(NewBytes(noteAFInfo, Undef, raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Used) != 2 || len(a.InFocus) != 0 {
+		t.Errorf("used %v and in focus %v mismatched", a.Used, a.InFocus)
+	}
+}
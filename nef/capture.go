@@ -0,0 +1,273 @@
+package nef
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	noteCaptureData         = 0x0e01
+	noteCaptureVersion      = 0x0e09
+	noteCaptureEditVersions = 0x0e13
+)
+
+const (
+	captureHeaderLen = 0x22
+	captureRecordLen = 22
+)
+
+var captureRecords = map[uint32]string{
+	0x008ae85e: "LCHEditor",
+	0x083a1a25: "HistogramXML",
+	0x0c89224b: "ColorAberrationControl",
+	0x116fea21: "HighlightData",
+	0x2175eb78: "D-LightingHQ",
+	0x2fc08431: "StraightenAngle",
+	0x374233e0: "CropData",
+	0x39c456ac: "PictureCtrl",
+	0x3cfc73c6: "RedEyeData",
+	0x3d136244: "EditVersionName",
+	0x56a54260: "Exposure",
+	0x5f0e7d23: "ColorBoost",
+	0x6a6e36b6: "D-LightingHQSelected",
+	0x753dcbc0: "NoiseReduction",
+	0x76a43200: "UnsharpMask",
+	0x76a43201: "Curves",
+	0x76a43202: "ColorBalanceAdj",
+	0x76a43203: "AdvancedRaw",
+	0x76a43204: "WhiteBalanceAdj",
+	0x76a43205: "VignetteControl",
+	0x76a43206: "FlipHorizontal",
+	0x76a43207: "Rotate",
+	0x84589434: "BrightnessData",
+	0x890ff591: "D-LightingHQData",
+	0x926f13e0: "NoiseReductionData",
+	0x9ef5f6e0: "IPTCData",
+	0xab5eca5e: "PhotoEffects",
+	0xac6bd5c0: "VignetteInfo",
+	0xb0384e1e: "PhotoEffectsData",
+	0xb999a36f: "ColorBoostData",
+	0xbf3c6c20: "WBAdjData",
+	0xce5554aa: "D-LightingHS",
+	0xe2173c47: "PictureControl",
+	0xe37b4337: "D-LightingHSData",
+	0xe42b5161: "UnsharpData",
+	0xe9651831: "PhotoEffectHistoryXML",
+	0xfe28a44f: "AutoRedEye",
+	0xfe443a45: "ImageDustOff",
+}
+
+type CaptureRecord struct {
+	Id   uint32
+	Data []byte
+}
+
+func (r CaptureRecord) Name() string {
+	str, ok := captureRecords[r.Id]
+	if !ok {
+		str = fmt.Sprintf("0x%08x", r.Id)
+	}
+	return str
+}
+
+type CaptureData struct {
+	Version string
+	Records []CaptureRecord
+	// EditVersions holds the raw content of the NikonCaptureEditVersions tag
+	// when the file has been saved with more than one version of the edits.
+	EditVersions []byte
+}
+
+func (c CaptureData) Names() []string {
+	str := make([]string, len(c.Records))
+	for i := range c.Records {
+		str[i] = c.Records[i].Name()
+	}
+	return str
+}
+
+func (c CaptureData) String() string {
+	return fmt.Sprintf("%s (%s)", c.Version, strings.Join(c.Names(), ", "))
+}
+
+// CaptureData gives the edits saved in the file by Nikon Capture NX. It fails
+// with ErrExist if the file has never been edited with Capture NX.
+func (f File) CaptureData() (CaptureData, error) {
+	var c CaptureData
+	t, err := f.GetTag(noteCaptureData, Note)
+	if err != nil {
+		return c, err
+	}
+	if c.Records, err = DecodeCaptureData(t); err != nil {
+		return c, err
+	}
+	if v, err := f.GetTag(noteCaptureVersion, Note); err == nil {
+		c.Version = v.String()
+	}
+	if v, err := f.GetTag(noteCaptureEditVersions, Note); err == nil {
+		c.EditVersions = v.Bytes()
+	}
+	return c, nil
+}
+
+// DecodeCaptureData splits the content of the NikonCaptureData tag into its
+// records. The data starts with a 34 bytes header, each record is itself
+// preceded by a 22 bytes header giving its identifier and its size, the size
+// counting 4 bytes more than the content of the record. The content of the
+// records is always little endian.
+func DecodeCaptureData(t Tag) ([]CaptureRecord, error) {
+	if t.Id != noteCaptureData || len(t.Raw) < captureHeaderLen {
+		return nil, fmt.Errorf("capture data: %w", ErrFormat)
+	}
+	var (
+		list []CaptureRecord
+		buf  = t.Raw[captureHeaderLen:]
+	)
+	for len(buf) > captureRecordLen {
+		var (
+			id   = binary.LittleEndian.Uint32(buf)
+			size = int64(binary.LittleEndian.Uint32(buf[18:])) - 4
+		)
+		buf = buf[captureRecordLen:]
+		if size < 0 || size > int64(len(buf)) {
+			return list, fmt.Errorf("capture data: record 0x%08x too long (%d bytes): %w", id, size, ErrFormat)
+		}
+		r := CaptureRecord{
+			Id:   id,
+			Data: append([]byte{}, buf[:size]...),
+		}
+		list = append(list, r)
+		buf = buf[size:]
+	}
+	return list, nil
+}
+
+const sidecarDir = "NKSC_PARAM"
+
+// EditSetting is one of the setting found in a NX Studio sidecar. Space is the
+// XML namespace of the setting and Path the names of the elements enclosing
+// it.
+type EditSetting struct {
+	Space string
+	Path  []string
+	Name  string
+	Value string
+}
+
+func (e EditSetting) String() string {
+	name := append(append([]string{}, e.Path...), e.Name)
+	return fmt.Sprintf("%s=%s", strings.Join(name, "/"), e.Value)
+}
+
+type Sidecar struct {
+	File     string
+	Settings []EditSetting
+}
+
+// SidecarPath gives the location of the sidecar written by NX Studio for the
+// given file: a .nksc file with the same name in the NKSC_PARAM directory next
+// to it.
+func SidecarPath(file string) string {
+	dir, base := filepath.Split(file)
+	return filepath.Join(dir, sidecarDir, base+".nksc")
+}
+
+// HasSidecar reports whether edits made with NX Studio exist for the given
+// file.
+func HasSidecar(file string) bool {
+	i, err := os.Stat(SidecarPath(file))
+	return err == nil && i.Mode().IsRegular()
+}
+
+func DecodeSidecarFile(file string) (Sidecar, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return Sidecar{}, err
+	}
+	defer r.Close()
+
+	s, err := DecodeSidecar(r)
+	s.File = file
+	return s, err
+}
+
+// DecodeSidecar reads the settings of a NX Studio sidecar. Settings are
+// collected from the leaf elements and from the attributes of the elements
+// (XMP shorthand) except the ones belonging to the RDF and XMP namespaces.
+func DecodeSidecar(r io.Reader) (Sidecar, error) {
+	var (
+		s     Sidecar
+		rs    = xml.NewDecoder(r)
+		path  []string
+		text  strings.Builder
+		inner []bool
+	)
+	for {
+		tok, err := rs.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return s, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if n := len(inner); n > 0 {
+				inner[n-1] = true
+			}
+			for _, a := range tok.Attr {
+				if a.Name.Space == "" || skipSidecarSpace(a.Name.Space) {
+					continue
+				}
+				e := EditSetting{
+					Space: a.Name.Space,
+					Path:  sidecarPath(path, tok.Name),
+					Name:  a.Name.Local,
+					Value: a.Value,
+				}
+				s.Settings = append(s.Settings, e)
+			}
+			path = append(path, tok.Name.Local)
+			inner = append(inner, false)
+			text.Reset()
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			n := len(path) - 1
+			if n < 0 {
+				return s, fmt.Errorf("sidecar: unexpected end element %s", tok.Name.Local)
+			}
+			if !inner[n] && !skipSidecarSpace(tok.Name.Space) {
+				e := EditSetting{
+					Space: tok.Name.Space,
+					Path:  append([]string{}, path[:n]...),
+					Name:  tok.Name.Local,
+					Value: strings.TrimSpace(text.String()),
+				}
+				s.Settings = append(s.Settings, e)
+			}
+			path, inner = path[:n], inner[:n]
+			text.Reset()
+		}
+	}
+	return s, nil
+}
+
+func sidecarPath(path []string, name xml.Name) []string {
+	return append(append([]string{}, path...), name.Local)
+}
+
+func skipSidecarSpace(space string) bool {
+	switch space {
+	case "xmlns", "adobe:ns:meta/", "http://www.w3.org/1999/02/22-rdf-syntax-ns#":
+		return true
+	default:
+		return strings.HasPrefix(space, "http://www.w3.org/")
+	}
+}
@@ -0,0 +1,78 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// captureBlob builds a NikonCaptureData blob laid out as Capture NX writes
+// it: a 34 bytes header then records with a 22 bytes header whose size
+// counts 4 bytes more than the data of the record.
+func captureBlob(records []CaptureRecord) []byte {
+	buf := make([]byte, captureHeaderLen)
+	copy(buf, "\x00\x00\x00\x01Nikon Capture Data")
+	for _, r := range records {
+		head := make([]byte, captureRecordLen)
+		binary.LittleEndian.PutUint32(head, r.Id)
+		binary.LittleEndian.PutUint32(head[18:], uint32(len(r.Data)+4))
+		buf = append(buf, head...)
+		buf = append(buf, r.Data...)
+	}
+	return buf
+}
+
+func TestDecodeCaptureData(t *testing.T) {
+	data := []struct {
+		Name    string
+		Records []CaptureRecord
+	}{
+		{
+			Name: "empty",
+		},
+		{
+			Name: "single",
+			Records: []CaptureRecord{
+				{Id: 0x76a43207, Data: []byte{0x5a, 0x00, 0x00, 0x00}},
+			},
+		},
+		{
+			Name: "many",
+			Records: []CaptureRecord{
+				{Id: 0x374233e0, Data: bytes.Repeat([]byte{0x01}, 48)},
+				{Id: 0x3d136244, Data: []byte("Version 1\x00")},
+				{Id: 0x76a43206, Data: []byte{0x00}},
+				{Id: 0xfe443a45, Data: []byte{}},
+				{Id: 0x56a54260, Data: []byte{0x00, 0x00, 0x80, 0x3f, 0x01}},
+			},
+		},
+	}
+	for _, d := range data {
+		tag := NewBytes(noteCaptureData, Undef, captureBlob(d.Records))
+		got, err := DecodeCaptureData(tag)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if len(got) != len(d.Records) {
+			t.Errorf("%s: records mismatched: want %d, got %d", d.Name, len(d.Records), len(got))
+			continue
+		}
+		for i := range got {
+			want := d.Records[i]
+			if got[i].Id != want.Id || !bytes.Equal(got[i].Data, want.Data) {
+				t.Errorf("%s: record %d mismatched: want %s (%x), got %s (%x)", d.Name, i, want.Name(), want.Data, got[i].Name(), got[i].Data)
+			}
+		}
+	}
+}
+
+func TestDecodeCaptureDataTruncated(t *testing.T) {
+	blob := captureBlob([]CaptureRecord{
+		{Id: 0x76a43207, Data: []byte{1, 2, 3, 4}},
+	})
+	tag := NewBytes(noteCaptureData, Undef, blob[:len(blob)-2])
+	if _, err := DecodeCaptureData(tag); err == nil {
+		t.Errorf("truncated record should fail")
+	}
+}
@@ -0,0 +1,298 @@
+package nef
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+)
+
+const (
+	Predictor    = 0x13d
+	SampleFormat = 0x153
+)
+
+const (
+	CompressionNone         = 1
+	CompressionOJPEG        = 6
+	CompressionLZW          = 5
+	CompressionDeflate      = 8
+	CompressionPackBits     = 32773
+	CompressionAdobeDeflate = 32946
+)
+
+const (
+	PredictorNone       = 1
+	PredictorHorizontal = 2
+	PredictorFloat      = 3
+)
+
+const (
+	SampleUint  = 1
+	SampleInt   = 2
+	SampleFloat = 3
+)
+
+func (f File) compression() uint32 {
+	t, err := f.get(Compression)
+	if err != nil {
+		return CompressionNone
+	}
+	return t.Uint()
+}
+
+// canDecompress reports whether the strips of the image are stored as is or
+// compressed with one of the codecs known by decompress. The compression used
+// by Nikon for its raw data is handled by RawImage.
+func (f File) canDecompress() bool {
+	switch f.compression() {
+	case CompressionNone, CompressionNikon, CompressionJPEG, CompressionLZW, CompressionDeflate, CompressionAdobeDeflate, CompressionPackBits:
+		return true
+	default:
+		return false
+	}
+}
+
+// decompress gives the bytes of one strip once uncompressed and once the
+// predictor reverted. Strips compressed with an unknown scheme are given as
+// they are stored.
+func (f File) decompress(buf []byte) ([]byte, error) {
+	var err error
+	switch f.compression() {
+	case CompressionJPEG:
+		return f.decompressJPEG(buf)
+	case CompressionLZW:
+		buf, err = decodeLZW(buf)
+	case CompressionDeflate, CompressionAdobeDeflate:
+		buf, err = decodeDeflate(buf)
+	case CompressionPackBits:
+		buf, err = decodePackBits(buf)
+	default:
+		return buf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf, f.unpredict(buf)
+}
+
+func (f File) predictor() uint32 {
+	t, err := f.get(Predictor)
+	if err != nil {
+		return PredictorNone
+	}
+	return t.Uint()
+}
+
+func (f File) sampleFormat() uint32 {
+	t, err := f.get(SampleFormat)
+	if err != nil {
+		return SampleUint
+	}
+	return t.Uint()
+}
+
+// unpredict reverts, row by row, the differences computed by the predictor
+// before the compression of a strip.
+func (f File) unpredict(buf []byte) error {
+	predictor := f.predictor()
+	if predictor == PredictorNone {
+		return nil
+	}
+	var (
+		width, _ = f.get(ImageWidth)
+		bits     = f.bitsPerSample()
+		samples  = f.samplesPerPixel()
+	)
+	if f.checkChunky() != nil {
+		samples = 1
+	}
+	var (
+		count = int(width.Uint()) * samples
+		size  = (count*bits + 7) / 8
+	)
+	if size == 0 {
+		return nil
+	}
+	for len(buf) >= size {
+		row := buf[:size]
+		buf = buf[size:]
+		switch predictor {
+		case PredictorHorizontal:
+			if err := unpredictHorizontal(row, samples, bits, f.order); err != nil {
+				return err
+			}
+		case PredictorFloat:
+			if err := unpredictFloat(row, samples, bits, f.order); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("predictor %d: %w", predictor, ErrFormat)
+		}
+	}
+	return nil
+}
+
+func unpredictHorizontal(row []byte, samples, bits int, order binary.ByteOrder) error {
+	switch bits {
+	case 8:
+		for i := samples; i < len(row); i++ {
+			row[i] += row[i-samples]
+		}
+	case 16:
+		for i := samples * 2; i+1 < len(row); i += 2 {
+			v := order.Uint16(row[i:]) + order.Uint16(row[i-samples*2:])
+			order.PutUint16(row[i:], v)
+		}
+	case 32:
+		for i := samples * 4; i+3 < len(row); i += 4 {
+			v := order.Uint32(row[i:]) + order.Uint32(row[i-samples*4:])
+			order.PutUint32(row[i:], v)
+		}
+	default:
+		return fmt.Errorf("horizontal predictor with %d bits per sample: %w", bits, ErrFormat)
+	}
+	return nil
+}
+
+// unpredictFloat reverts the floating point predictor: the bytes of a row are
+// differences of the previous bytes and the bytes of the samples are stored by
+// significance, all the most significant bytes first.
+func unpredictFloat(row []byte, samples, bits int, order binary.ByteOrder) error {
+	if bits != 16 && bits != 32 && bits != 64 {
+		return fmt.Errorf("floating point predictor with %d bits per sample: %w", bits, ErrFormat)
+	}
+	for i := samples; i < len(row); i++ {
+		row[i] += row[i-samples]
+	}
+	var (
+		size  = bits / 8
+		count = len(row) / size
+		tmp   = append([]byte{}, row...)
+	)
+	for i := 0; i < count; i++ {
+		for b := 0; b < size; b++ {
+			x := b
+			if order == binary.LittleEndian {
+				x = size - b - 1
+			}
+			row[i*size+x] = tmp[b*count+i]
+		}
+	}
+	return nil
+}
+
+// decodeLZW uncompresses the LZW variant of TIFF: codes are written from the
+// most significant bit and their width grows one code earlier than required.
+func decodeLZW(buf []byte) ([]byte, error) {
+	const (
+		clearCode = 256
+		eoiCode   = 257
+		maxWidth  = 12
+	)
+	if len(buf) >= 2 && buf[0] == 0 && buf[1]&1 == 1 {
+		return nil, fmt.Errorf("lzw: old style codes: %w", ErrFormat)
+	}
+	var (
+		br    = newBitReader(buf)
+		total = len(buf) * 8
+		read  int
+		width = 9
+		table = make([][]byte, eoiCode+1, 1<<maxWidth)
+		prev  []byte
+		out   []byte
+	)
+	for i := 0; i < clearCode; i++ {
+		table[i] = []byte{byte(i)}
+	}
+	for read+width <= total {
+		code := int(br.bits(uint(width)))
+		read += width
+		if code == eoiCode {
+			break
+		}
+		if code == clearCode {
+			table, width, prev = table[:eoiCode+1], 9, nil
+			continue
+		}
+		var entry []byte
+		switch {
+		case code < len(table):
+			entry = table[code]
+		case code == len(table) && prev != nil:
+			entry = append(prev[:len(prev):len(prev)], prev[0])
+		default:
+			return nil, fmt.Errorf("lzw: invalid code %d: %w", code, ErrFormat)
+		}
+		out = append(out, entry...)
+		if prev != nil && len(table) < cap(table) {
+			table = append(table, append(prev[:len(prev):len(prev)], entry[0]))
+		}
+		prev = entry
+		if len(table) >= 1<<width-1 && width < maxWidth {
+			width++
+		}
+	}
+	return out, nil
+}
+
+func decodeDeflate(buf []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("deflate: %w", err)
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// decodePackBits uncompresses runs of bytes: a header n from 0 to 127 is
+// followed by n+1 literal bytes, a header from -1 to -127 by one byte repeated
+// 1-n times. -128 is skipped.
+func decodePackBits(buf []byte) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(buf); {
+		n := int(int8(buf[i]))
+		i++
+		switch {
+		case n >= 0:
+			if i+n+1 > len(buf) {
+				return nil, fmt.Errorf("packbits: %w", errTruncated)
+			}
+			out = append(out, buf[i:i+n+1]...)
+			i += n + 1
+		case n > -128:
+			if i >= len(buf) {
+				return nil, fmt.Errorf("packbits: %w", errTruncated)
+			}
+			out = append(out, bytes.Repeat(buf[i:i+1], 1-n)...)
+			i++
+		}
+	}
+	return out, nil
+}
+
+// unpackFloats converts floating point samples in the range [0, 1] to 16 bits
+// samples.
+func (f File) unpackFloats(buf []byte) ([]uint16, error) {
+	bits := f.bitsPerSample()
+	if bits != 32 && bits != 64 {
+		return nil, fmt.Errorf("%d bits floating point samples: %w", bits, ErrFormat)
+	}
+	var (
+		size = bits / 8
+		list = make([]uint16, 0, len(buf)/size)
+	)
+	for i := 0; i+size <= len(buf); i += size {
+		var v float64
+		if bits == 32 {
+			v = float64(math.Float32frombits(f.order.Uint32(buf[i:])))
+		} else {
+			v = math.Float64frombits(f.order.Uint64(buf[i:]))
+		}
+		v = math.Max(0, math.Min(1, v))
+		list = append(list, uint16(math.Round(v*0xffff)))
+	}
+	return list, nil
+}
@@ -0,0 +1,244 @@
+package nef
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// encodeLZW compresses buf with the LZW variant of TIFF, growing the width of
+// the codes one code earlier than required like decodeLZW expects.
+func encodeLZW(buf []byte) []byte {
+	const (
+		clearCode = 256
+		eoiCode   = 257
+	)
+	var (
+		out   []byte
+		acc   uint64
+		size  uint
+		width uint = 9
+		next       = eoiCode + 1
+		table      = make(map[string]int)
+	)
+	emit := func(code int) {
+		acc = acc<<width | uint64(code)
+		size += width
+		for size >= 8 {
+			out = append(out, byte(acc>>(size-8)))
+			size -= 8
+		}
+	}
+	for i := 0; i < clearCode; i++ {
+		table[string([]byte{byte(i)})] = i
+	}
+	emit(clearCode)
+	var prefix []byte
+	for _, c := range buf {
+		str := append(prefix[:len(prefix):len(prefix)], c)
+		if _, ok := table[string(str)]; ok {
+			prefix = str
+			continue
+		}
+		emit(table[string(prefix)])
+		table[string(str)] = next
+		next++
+		if next-1 >= 1<<width-1 {
+			width++
+		}
+		prefix = []byte{c}
+	}
+	if len(prefix) > 0 {
+		emit(table[string(prefix)])
+		if next >= 1<<width-1 {
+			width++
+		}
+	}
+	emit(eoiCode)
+	if size > 0 {
+		out = append(out, byte(acc<<(8-size)))
+	}
+	return out
+}
+
+func TestDecodeLZW(t *testing.T) {
+	long := make([]byte, 3000)
+	for i := range long {
+		long[i] = byte(i * i >> 3)
+	}
+	data := []struct {
+		Name string
+		Buf  []byte
+	}{
+		{Name: "empty", Buf: []byte{}},
+		{Name: "single", Buf: []byte{42}},
+		{Name: "text", Buf: []byte("TOBEORNOTTOBEORTOBEORNOT#")},
+		{Name: "runs", Buf: bytes.Repeat([]byte{7}, 1000)},
+		{Name: "long", Buf: long},
+	}
+	for _, d := range data {
+		got, err := decodeLZW(encodeLZW(d.Buf))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if !bytes.Equal(got, d.Buf) {
+			t.Errorf("%s: bytes mismatched: want %d bytes, got %d", d.Name, len(d.Buf), len(got))
+		}
+	}
+}
+
+func TestDecodePackBits(t *testing.T) {
+	data := []struct {
+		Name string
+		Buf  []byte
+		Want []byte
+	}{
+		{
+			// example of the TIFF specification
+			Name: "spec",
+			Buf:  []byte{0xfe, 0xaa, 0x02, 0x80, 0x00, 0x2a, 0xfd, 0xaa, 0x03, 0x80, 0x00, 0x2a, 0x22, 0xf7, 0xaa},
+			Want: []byte{
+				0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a, 0xaa, 0xaa, 0xaa, 0xaa, 0x80, 0x00,
+				0x2a, 0x22, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa,
+			},
+		},
+		{
+			Name: "noop",
+			Buf:  []byte{0x80, 0x01, 0x01, 0x02},
+			Want: []byte{0x01, 0x02},
+		},
+	}
+	for _, d := range data {
+		got, err := decodePackBits(d.Buf)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if !bytes.Equal(got, d.Want) {
+			t.Errorf("%s: bytes mismatched: want %x, got %x", d.Name, d.Want, got)
+		}
+	}
+	for _, buf := range [][]byte{{0x02, 0x01}, {0xfe}} {
+		if _, err := decodePackBits(buf); err == nil {
+			t.Errorf("%x: truncated run should fail", buf)
+		}
+	}
+}
+
+func TestDecodeDeflate(t *testing.T) {
+	data := [][]byte{
+		{},
+		[]byte("deflate"),
+		bytes.Repeat([]byte{1, 2, 3, 4}, 512),
+	}
+	for _, want := range data {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(want)
+		w.Close()
+
+		got, err := decodeDeflate(buf.Bytes())
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("bytes mismatched: want %x, got %x", want, got)
+		}
+	}
+}
+
+func TestUnpredictHorizontal(t *testing.T) {
+	data := []struct {
+		Name    string
+		Bits    int
+		Samples int
+		Order   binary.ByteOrder
+		Values  []uint64
+	}{
+		{Name: "8-gray", Bits: 8, Samples: 1, Values: []uint64{10, 20, 5, 255, 0}},
+		{Name: "8-rgb", Bits: 8, Samples: 3, Values: []uint64{1, 2, 3, 250, 4, 9}},
+		{Name: "16-le", Bits: 16, Samples: 1, Order: binary.LittleEndian, Values: []uint64{1000, 65535, 3, 40000}},
+		{Name: "16-be", Bits: 16, Samples: 2, Order: binary.BigEndian, Values: []uint64{1000, 2, 65535, 3}},
+		{Name: "32-le", Bits: 32, Samples: 1, Order: binary.LittleEndian, Values: []uint64{1 << 31, 7, 1<<32 - 1}},
+	}
+	for _, d := range data {
+		var (
+			size  = d.Bits / 8
+			order = d.Order
+			want  = make([]byte, len(d.Values)*size)
+			row   = make([]byte, len(want))
+		)
+		if order == nil {
+			order = binary.BigEndian
+		}
+		put := func(buf []byte, i int, v uint64) {
+			switch size {
+			case 1:
+				buf[i] = byte(v)
+			case 2:
+				order.PutUint16(buf[i*2:], uint16(v))
+			case 4:
+				order.PutUint32(buf[i*4:], uint32(v))
+			}
+		}
+		for i, v := range d.Values {
+			put(want, i, v)
+			if i < d.Samples {
+				put(row, i, v)
+			} else {
+				put(row, i, v-d.Values[i-d.Samples])
+			}
+		}
+		if err := unpredictHorizontal(row, d.Samples, d.Bits, order); err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if !bytes.Equal(row, want) {
+			t.Errorf("%s: samples mismatched: want %x, got %x", d.Name, want, row)
+		}
+	}
+	if err := unpredictHorizontal(make([]byte, 4), 1, 12, binary.BigEndian); err == nil {
+		t.Errorf("horizontal predictor with 12 bits should fail")
+	}
+}
+
+func TestUnpredictFloat(t *testing.T) {
+	data := []struct {
+		Name    string
+		Samples int
+		Order   binary.ByteOrder
+		Values  []float32
+	}{
+		{Name: "le", Samples: 1, Order: binary.LittleEndian, Values: []float32{0, 0.5, 1, 0.25}},
+		{Name: "be", Samples: 1, Order: binary.BigEndian, Values: []float32{1, 0.125, 0.75}},
+		{Name: "rgb", Samples: 3, Order: binary.LittleEndian, Values: []float32{0.1, 0.2, 0.3, 0.4, 0.5, 0.6}},
+	}
+	for _, d := range data {
+		var (
+			count = len(d.Values)
+			want  = make([]byte, count*4)
+			row   = make([]byte, count*4)
+		)
+		for i, v := range d.Values {
+			bits := math.Float32bits(v)
+			d.Order.PutUint32(want[i*4:], bits)
+			for b := 0; b < 4; b++ {
+				row[b*count+i] = byte(bits >> (24 - 8*b))
+			}
+		}
+		for i := len(row) - 1; i >= d.Samples; i-- {
+			row[i] -= row[i-d.Samples]
+		}
+		if err := unpredictFloat(row, d.Samples, 32, d.Order); err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if !bytes.Equal(row, want) {
+			t.Errorf("%s: samples mismatched: want %x, got %x", d.Name, want, row)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package nef
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+const (
+	CFARepeatPatternDim = 0x828d
+	CFAPattern          = 0x828e
+	exifCFAPattern      = 0xa302
+)
+
+const ImgCFA uint32 = 32803
+
+const (
+	CFARed uint8 = iota
+	CFAGreen
+	CFABlue
+)
+
+type Demosaic uint8
+
+const (
+	// Bilinear averages, for each missing colour, the neighbours of the pixel
+	// having this colour.
+	Bilinear Demosaic = iota
+	// EdgeAware interpolates the green channel along the direction with the
+	// smallest gradient (Hamilton-Adams) and the red and blue channels from
+	// the colour differences with the green channel. It only works with Bayer
+	// patterns, other patterns fall back to Bilinear.
+	EdgeAware
+)
+
+// CFA is the layout of the colour filter array of a sensor: a pattern of
+// Width x Height colours repeated over the sensor.
+type CFA struct {
+	Width  int
+	Height int
+	Colors []uint8
+}
+
+func (c CFA) At(x, y int) uint8 {
+	return c.Colors[(y%c.Height)*c.Width+(x%c.Width)]
+}
+
+func (c CFA) IsBayer() bool {
+	if c.Width != 2 || c.Height != 2 || len(c.Colors) != 4 {
+		return false
+	}
+	var count [3]int
+	for _, c := range c.Colors {
+		if c > CFABlue {
+			return false
+		}
+		count[c]++
+	}
+	return count[CFARed] == 1 && count[CFAGreen] == 2 && count[CFABlue] == 1
+}
+
+func (c CFA) String() string {
+	names := []byte("RGB")
+	str := make([]byte, 0, len(c.Colors))
+	for _, c := range c.Colors {
+		if int(c) < len(names) {
+			str = append(str, names[c])
+		} else {
+			str = append(str, '?')
+		}
+	}
+	return string(str)
+}
+
+// CFA gives the layout of the colour filter array from the CFARepeatPatternDim
+// and CFAPattern tags of the image or, when missing, from the CFAPattern tag
+// of the Exif directory.
+func (f File) CFA() (CFA, error) {
+	var c CFA
+	dim, err1 := f.get(CFARepeatPatternDim)
+	pat, err2 := f.get(CFAPattern)
+	if err1 == nil && err2 == nil {
+		ds := tagUints(dim)
+		if len(ds) != 2 {
+			return c, fmt.Errorf("cfa pattern dim: %w", ErrFormat)
+		}
+		c.Height, c.Width = int(ds[0]), int(ds[1])
+		c.Colors = append(c.Colors, pat.Raw...)
+	} else {
+		t, err := f.GetTag(exifCFAPattern, Exif)
+		if err != nil {
+			return c, fmt.Errorf("cfa pattern: %w", err)
+		}
+		if c, err = decodeExifCFA(t); err != nil {
+			return c, err
+		}
+	}
+	if c.Width <= 0 || c.Height <= 0 || len(c.Colors) < c.Width*c.Height {
+		return c, fmt.Errorf("cfa pattern %dx%d: %w", c.Width, c.Height, ErrFormat)
+	}
+	c.Colors = c.Colors[:c.Width*c.Height]
+	return c, nil
+}
+
+// decodeExifCFA decodes the Exif version of the CFA pattern: the dimension of
+// the pattern is given by two shorts, in the byte order of the file or,
+// written by some cameras, in the opposite one.
+func decodeExifCFA(t Tag) (CFA, error) {
+	var c CFA
+	if len(t.Raw) < 4 {
+		return c, fmt.Errorf("exif cfa pattern: %w", ErrFormat)
+	}
+	order := t.ByteOrder()
+	c.Width = int(order.Uint16(t.Raw))
+	c.Height = int(order.Uint16(t.Raw[2:]))
+	if c.Width*c.Height != len(t.Raw)-4 {
+		c.Width = int(binary.BigEndian.Uint16(t.Raw))
+		c.Height = int(binary.BigEndian.Uint16(t.Raw[2:]))
+		if c.Width*c.Height != len(t.Raw)-4 {
+			c.Width = int(binary.LittleEndian.Uint16(t.Raw))
+			c.Height = int(binary.LittleEndian.Uint16(t.Raw[2:]))
+		}
+	}
+	c.Colors = append(c.Colors, t.Raw[4:]...)
+	return c, nil
+}
+
+// DemosaicImage interpolates the missing colours of each pixel of a mosaic
+// recorded through the given colour filter array. The samples of the returned
+// image have the same scale than the ones of the mosaic.
+func DemosaicImage(img *image.Gray16, cfa CFA, method Demosaic) (*image.RGBA64, error) {
+	if cfa.Width <= 0 || cfa.Height <= 0 || len(cfa.Colors) < cfa.Width*cfa.Height {
+		return nil, fmt.Errorf("cfa pattern %dx%d: %w", cfa.Width, cfa.Height, ErrFormat)
+	}
+	for _, c := range cfa.Colors {
+		if c > CFABlue {
+			return nil, fmt.Errorf("cfa pattern %s: %w", cfa, ErrFormat)
+		}
+	}
+	planes := newPlanes(img, cfa)
+	if method == EdgeAware && cfa.IsBayer() {
+		planes.edgeAware()
+	} else {
+		planes.bilinear()
+	}
+	return planes.image(), nil
+}
+
+type planes struct {
+	width  int
+	height int
+	cfa    CFA
+	chans  [3][]int32
+	rect   image.Rectangle
+}
+
+func newPlanes(img *image.Gray16, cfa CFA) *planes {
+	var (
+		rect = img.Bounds()
+		p    = planes{
+			width:  rect.Dx(),
+			height: rect.Dy(),
+			cfa:    cfa,
+			rect:   rect,
+		}
+	)
+	for i := range p.chans {
+		p.chans[i] = make([]int32, p.width*p.height)
+	}
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			var (
+				v = img.Gray16At(rect.Min.X+x, rect.Min.Y+y).Y
+				c = cfa.At(x, y)
+			)
+			p.chans[c][y*p.width+x] = int32(v)
+		}
+	}
+	return &p
+}
+
+func (p *planes) color(x, y int) uint8 {
+	return p.cfa.At(x, y)
+}
+
+func (p *planes) inside(x, y int) bool {
+	return x >= 0 && x < p.width && y >= 0 && y < p.height
+}
+
+func (p *planes) bilinear() {
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			var (
+				sum   [3]int32
+				count [3]int32
+				own   = p.color(x, y)
+			)
+			for j := -1; j <= 1; j++ {
+				for i := -1; i <= 1; i++ {
+					if (i == 0 && j == 0) || !p.inside(x+i, y+j) {
+						continue
+					}
+					c := p.color(x+i, y+j)
+					sum[c] += p.chans[c][(y+j)*p.width+x+i]
+					count[c]++
+				}
+			}
+			for c := range p.chans {
+				if uint8(c) == own || count[c] == 0 {
+					continue
+				}
+				p.chans[c][y*p.width+x] = sum[c] / count[c]
+			}
+		}
+	}
+}
+
+func (p *planes) edgeAware() {
+	p.interpolateGreen()
+	for _, c := range []uint8{CFARed, CFABlue} {
+		p.interpolateDiff(c)
+	}
+}
+
+func (p *planes) interpolateGreen() {
+	var (
+		green = p.chans[CFAGreen]
+		at    = func(c uint8, x, y int) int32 {
+			x = reflect(x, p.width)
+			y = reflect(y, p.height)
+			return p.chans[c][y*p.width+x]
+		}
+	)
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			c := p.color(x, y)
+			if c == CFAGreen {
+				continue
+			}
+			var (
+				cur = 2 * at(c, x, y)
+				gh  = at(CFAGreen, x-1, y) + at(CFAGreen, x+1, y)
+				gv  = at(CFAGreen, x, y-1) + at(CFAGreen, x, y+1)
+				lh  = cur - at(c, x-2, y) - at(c, x+2, y)
+				lv  = cur - at(c, x, y-2) - at(c, x, y+2)
+				dh  = abs32(at(CFAGreen, x-1, y)-at(CFAGreen, x+1, y)) + abs32(lh)
+				dv  = abs32(at(CFAGreen, x, y-1)-at(CFAGreen, x, y+1)) + abs32(lv)
+				val int32
+			)
+			switch {
+			case dh < dv:
+				val = gh/2 + lh/4
+			case dv < dh:
+				val = gv/2 + lv/4
+			default:
+				val = (gh+gv)/4 + (lh+lv)/8
+			}
+			green[y*p.width+x] = clamp32(val, 0, 0xffff)
+		}
+	}
+}
+
+func (p *planes) interpolateDiff(c uint8) {
+	var (
+		plane = p.chans[c]
+		green = p.chans[CFAGreen]
+		diffs = make([]int32, len(plane))
+	)
+	for i := range plane {
+		diffs[i] = plane[i] - green[i]
+	}
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			if p.color(x, y) == c {
+				continue
+			}
+			var sum, count int32
+			for j := -1; j <= 1; j++ {
+				for i := -1; i <= 1; i++ {
+					if !p.inside(x+i, y+j) || p.color(x+i, y+j) != c {
+						continue
+					}
+					sum += diffs[(y+j)*p.width+x+i]
+					count++
+				}
+			}
+			if count == 0 {
+				continue
+			}
+			plane[y*p.width+x] = clamp32(green[y*p.width+x]+sum/count, 0, 0xffff)
+		}
+	}
+}
+
+func (p *planes) image() *image.RGBA64 {
+	var (
+		img = image.NewRGBA64(p.rect)
+		rgb color.RGBA64
+	)
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			i := y*p.width + x
+			rgb.R = uint16(p.chans[CFARed][i])
+			rgb.G = uint16(p.chans[CFAGreen][i])
+			rgb.B = uint16(p.chans[CFABlue][i])
+			rgb.A = 0xffff
+			img.SetRGBA64(p.rect.Min.X+x, p.rect.Min.Y+y, rgb)
+		}
+	}
+	return img
+}
+
+func (f File) decodeCFA() (image.Image, error) {
+	raw, err := f.RawImage()
+	if err != nil {
+		return nil, err
+	}
+	cfa, err := f.CFA()
+	if err != nil {
+		return nil, err
+	}
+	if bits := f.bitsPerSample(); bits < 16 {
+		shift := uint(16 - bits)
+		for i := 0; i+1 < len(raw.Pix); i += 2 {
+			v := binary.BigEndian.Uint16(raw.Pix[i:]) << shift
+			binary.BigEndian.PutUint16(raw.Pix[i:], v)
+		}
+	}
+	return DemosaicImage(raw, cfa, EdgeAware)
+}
+
+// reflect mirrors a coordinate falling outside of the image on the border so
+// that it keeps the same position in the colour filter array.
+func reflect(x, size int) int {
+	if x < 0 {
+		x = -x
+	}
+	if x >= size {
+		x = 2*(size-1) - x
+	}
+	return clamp(x, 0, size-1)
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func clamp32(v, lo, hi int32) int32 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,44 @@
+package develop
+
+// cameras gives, for each model, the matrix converting XYZ (D65) values into
+// the camera space as published by Adobe, multiplied by 10000.
+var cameras = map[string][9]float64{
+	"NIKON D3":    {8139, -2171, -663, -8747, 16541, 2295, -1925, 2008, 8093},
+	"NIKON D3S":   {8828, -2406, -694, -4874, 12603, 2541, -660, 1509, 7587},
+	"NIKON D3X":   {7171, -1986, -648, -8085, 15555, 2718, -2170, 2512, 7457},
+	"NIKON D4":    {8598, -2848, -857, -5618, 13606, 2195, -1002, 1773, 7137},
+	"NIKON D4S":   {8598, -2848, -857, -5618, 13606, 2195, -1002, 1773, 7137},
+	"NIKON D5":    {9200, -3522, -992, -5755, 13803, 2117, -753, 1486, 6338},
+	"NIKON D90":   {7309, -1403, -519, -8474, 16008, 2622, -2434, 2826, 8064},
+	"NIKON D200":  {8367, -2248, -763, -8758, 16447, 2422, -1527, 1550, 8053},
+	"NIKON D300":  {9030, -1992, -715, -8465, 16302, 2255, -2689, 3217, 8069},
+	"NIKON D300S": {9030, -1992, -715, -8465, 16302, 2255, -2689, 3217, 8069},
+	"NIKON D500":  {8813, -3210, -1036, -4703, 12868, 2021, -1054, 1940, 6129},
+	"NIKON D600":  {8178, -2245, -609, -4857, 12394, 2776, -1207, 2086, 7298},
+	"NIKON D610":  {8178, -2245, -609, -4857, 12394, 2776, -1207, 2086, 7298},
+	"NIKON D700":  {8139, -2171, -663, -8747, 16541, 2295, -1925, 2008, 8093},
+	"NIKON D750":  {9020, -2890, -715, -4535, 12436, 2348, -934, 1919, 7086},
+	"NIKON D800":  {7866, -2108, -555, -4869, 12483, 2681, -1176, 2069, 7501},
+	"NIKON D800E": {7866, -2108, -555, -4869, 12483, 2681, -1176, 2069, 7501},
+	"NIKON D810":  {9369, -3195, -791, -4488, 12430, 2301, -893, 1796, 6872},
+	"NIKON D850":  {10405, -3755, -1270, -5461, 13787, 1793, -1040, 2015, 6785},
+	"NIKON D5100": {8198, -2239, -724, -4871, 12389, 2798, -1043, 2050, 7181},
+	"NIKON D7000": {8198, -2239, -724, -4871, 12389, 2798, -1043, 2050, 7181},
+	"NIKON D7100": {8322, -3112, -1047, -6367, 14342, 2179, -988, 1638, 6394},
+	"NIKON D7200": {8322, -3112, -1047, -6367, 14342, 2179, -988, 1638, 6394},
+	"NIKON D7500": {8813, -3210, -1036, -4703, 12868, 2021, -1054, 1940, 6129},
+	"NIKON Z 6":   {8210, -2534, -683, -5355, 13338, 2212, -1143, 1929, 6464},
+	"NIKON Z 7":   {10405, -3755, -1270, -5461, 13787, 1793, -1040, 2015, 6785},
+}
+
+// CameraMatrix gives the XYZ to camera matrix known for the given model.
+func CameraMatrix(model string) ([9]float64, bool) {
+	m, ok := cameras[model]
+	if !ok {
+		return m, ok
+	}
+	for i := range m {
+		m[i] /= 10000
+	}
+	return m, ok
+}
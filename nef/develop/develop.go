@@ -0,0 +1,379 @@
+package develop
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/midbel/exif/nef"
+)
+
+var (
+	ErrRaw       = errors.New("no raw image")
+	ErrEncrypted = errors.New("encrypted")
+)
+
+const (
+	tiffModel        = 0x110
+	noteWBLevels     = 0xc
+	noteBlackLevel   = 0x3d
+	noteColorBalance = 0x97
+)
+
+type Space uint8
+
+const (
+	// SRGB gives sRGB values encoded with the sRGB tone curve.
+	SRGB Space = iota
+	// ProPhoto gives linear ProPhoto RGB values.
+	ProPhoto
+)
+
+func (s Space) String() string {
+	switch s {
+	case SRGB:
+		return "srgb"
+	case ProPhoto:
+		return "prophoto"
+	default:
+		return "unknown"
+	}
+}
+
+type Curve func(float64) float64
+
+func Linear(v float64) float64 {
+	return v
+}
+
+func SRGBCurve(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func Gamma(g float64) Curve {
+	return func(v float64) float64 {
+		return math.Pow(v, 1/g)
+	}
+}
+
+type Options struct {
+	Space    Space
+	Demosaic nef.Demosaic
+	// Curve is applied on the output values. The default curve is the one of
+	// the output space.
+	Curve Curve
+}
+
+func (o Options) curve() Curve {
+	if o.Curve != nil {
+		return o.Curve
+	}
+	if o.Space == SRGB {
+		return SRGBCurve
+	}
+	return Linear
+}
+
+// Params are the values needed to develop the sensor data of a camera.
+type Params struct {
+	// Black is the black level for each colour of the CFA pattern, or a single
+	// value for all of them.
+	Black []float64
+	White float64
+	// Multipliers are the white balance multipliers for the red, green and blue
+	// channels. They are left to 1 when the camera only records them in an
+	// encrypted ColorBalance tag.
+	Multipliers [3]float64
+	// Matrix converts XYZ (D65) values to camera values. The zero matrix means
+	// that camera values are used as is.
+	Matrix [9]float64
+}
+
+func (p Params) black(cfa nef.CFA, x, y int) float64 {
+	switch n := len(p.Black); {
+	case n == 0:
+		return 0
+	case n == cfa.Width*cfa.Height:
+		return p.Black[(y%cfa.Height)*cfa.Width+(x%cfa.Width)]
+	default:
+		return p.Black[0]
+	}
+}
+
+// DevelopFile renders the raw image of a NEF. f is the main directory of the
+// file, the raw image being found in it or in one of its sub directories.
+func DevelopFile(f *nef.File, opt Options) (*image.RGBA64, error) {
+	r, err := Find(f)
+	if err != nil {
+		return nil, err
+	}
+	params, err := ReadParams(f, r)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := r.RawImage()
+	if err != nil {
+		return nil, err
+	}
+	cfa, err := r.CFA()
+	if err != nil {
+		return nil, err
+	}
+	return Develop(raw, cfa, params, opt)
+}
+
+// Find gives the directory holding the sensor data.
+func Find(f *nef.File) (*nef.File, error) {
+	if f.ImageType() == "raw/cfa" {
+		return f, nil
+	}
+	for _, c := range f.Files {
+		if r, err := Find(c); err == nil {
+			return r, nil
+		}
+	}
+	return nil, ErrRaw
+}
+
+// ReadParams gives the parameters recorded by the camera: the model is read
+// from the main directory, everything else from the raw directory or from the
+// maker notes.
+func ReadParams(main, raw *nef.File) (Params, error) {
+	if main.IsDNG() {
+		return readDNGParams(main, raw)
+	}
+	p := Params{
+		Multipliers: [3]float64{1, 1, 1},
+	}
+	bits, err := raw.GetTag(nef.BitsPerSample, nef.Tiff)
+	if err != nil {
+		return p, err
+	}
+	p.White = float64(uint32(1)<<bits.Uint() - 1)
+
+	if t, err := raw.GetTag(noteBlackLevel, nef.Note); err == nil {
+		vs, _ := t.Values()
+		for _, v := range vs {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return p, fmt.Errorf("black level: %w", err)
+			}
+			p.Black = append(p.Black, f)
+		}
+	}
+	if mul, err := readMultipliers(raw); err == nil {
+		p.Multipliers = mul
+	} else if !errors.Is(err, nef.ErrExist) && !errors.Is(err, ErrEncrypted) {
+		return p, err
+	}
+	if t, err := main.GetTag(tiffModel, nef.Tiff); err == nil {
+		model := strings.TrimSpace(t.String())
+		if m, ok := CameraMatrix(model); ok {
+			p.Matrix = m
+		}
+	}
+	return p, nil
+}
+
+// readDNGParams gives the parameters of a DNG, the white balance being the one
+// of AsShotNeutral and the matrix the one calibrated for D65.
+func readDNGParams(main, raw *nef.File) (Params, error) {
+	p := Params{
+		Multipliers: [3]float64{1, 1, 1},
+	}
+	d, err := main.DNG()
+	if err != nil {
+		return p, err
+	}
+	r, err := raw.DNGRaw()
+	if err != nil {
+		return p, err
+	}
+	p.Black = r.Black
+	if len(r.White) > 0 {
+		p.White = r.White[0]
+	}
+	if n := d.AsShotNeutral; len(n) == 3 && n[0] > 0 && n[1] > 0 && n[2] > 0 {
+		for c := range p.Multipliers {
+			p.Multipliers[c] = n[nef.CFAGreen] / n[c]
+		}
+	}
+	if m := d.Matrix(nef.IlluminantD65); len(m) == len(p.Matrix) {
+		copy(p.Matrix[:], m)
+	}
+	return p, nil
+}
+
+// readMultipliers gives the white balance multipliers from the WB_RBLevels tag
+// or from the ColorBalance tag when it is not encrypted.
+func readMultipliers(f *nef.File) ([3]float64, error) {
+	mul := [3]float64{1, 1, 1}
+	if t, err := f.GetTag(noteWBLevels, nef.Note); err == nil {
+		fs := t.Floats()
+		if len(fs) >= 2 && fs[0] > 0 && fs[1] > 0 {
+			mul[0], mul[2] = fs[0], fs[1]
+			return mul, nil
+		}
+	}
+	t, err := f.GetTag(noteColorBalance, nef.Note)
+	if err != nil {
+		return mul, err
+	}
+	return decodeColorBalance(t)
+}
+
+// colorBalances gives, for the versions of the ColorBalance tag that are not
+// encrypted, the offset of the levels and the colour of each of them. Versions
+// 0200 and later are encrypted with keys derived from the serial number and
+// the shutter count of the camera. Their decryption is out of scope: only the
+// WB_RBLevels tag is used for these cameras.
+var colorBalances = map[string]struct {
+	offset int
+	colors [4]uint8
+}{
+	"0100": {offset: 0x48, colors: [4]uint8{nef.CFARed, nef.CFABlue, nef.CFAGreen, nef.CFAGreen}},
+	"0102": {offset: 0x0a, colors: [4]uint8{nef.CFARed, nef.CFAGreen, nef.CFAGreen, nef.CFABlue}},
+	"0103": {offset: 0x14, colors: [4]uint8{nef.CFARed, nef.CFAGreen, nef.CFABlue, nef.CFAGreen}},
+}
+
+func decodeColorBalance(t nef.Tag) ([3]float64, error) {
+	mul := [3]float64{1, 1, 1}
+	if len(t.Raw) < 4 {
+		return mul, fmt.Errorf("color balance: %w", nef.ErrFormat)
+	}
+	version := string(t.Raw[:4])
+	cb, ok := colorBalances[version]
+	if !ok && version >= "0200" {
+		return mul, fmt.Errorf("color balance %s: %w", version, ErrEncrypted)
+	}
+	if !ok {
+		return mul, fmt.Errorf("color balance %s: %w", version, nef.ErrFormat)
+	}
+	if len(t.Raw) < cb.offset+8 {
+		return mul, fmt.Errorf("color balance: %w", nef.ErrFormat)
+	}
+	var (
+		sum   [3]float64
+		count [3]float64
+		order = t.ByteOrder()
+	)
+	for i, c := range cb.colors {
+		sum[c] += float64(order.Uint16(t.Raw[cb.offset+i*2:]))
+		count[c]++
+	}
+	for c := range mul {
+		if count[c] == 0 || sum[c] == 0 {
+			return mul, fmt.Errorf("color balance: invalid levels: %w", nef.ErrFormat)
+		}
+		mul[c] = sum[c] / count[c]
+	}
+	for c := range mul {
+		mul[c] /= mul[nef.CFAGreen]
+	}
+	return mul, nil
+}
+
+// Develop renders the sensor data of a camera: the black level is subtracted
+// and the values scaled to the white level and white balanced before the
+// missing colours are interpolated. The result is converted to the output
+// space and the tone curve applied.
+func Develop(raw *image.Gray16, cfa nef.CFA, p Params, opt Options) (*image.RGBA64, error) {
+	if p.White <= 0 {
+		return nil, fmt.Errorf("white level %f: %w", p.White, nef.ErrFormat)
+	}
+	if cfa.Width <= 0 || cfa.Height <= 0 || len(cfa.Colors) < cfa.Width*cfa.Height {
+		return nil, fmt.Errorf("cfa pattern: %w", nef.ErrFormat)
+	}
+	for _, c := range cfa.Colors {
+		if c > nef.CFABlue {
+			return nil, fmt.Errorf("cfa pattern %s: %w", cfa, nef.ErrFormat)
+		}
+	}
+	conv, err := conversion(p.Matrix, opt.Space)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		rect   = raw.Bounds()
+		scaled = image.NewGray16(rect)
+		mul    = p.Multipliers
+		min    = math.Min(mul[0], math.Min(mul[1], mul[2]))
+	)
+	if min <= 0 {
+		return nil, fmt.Errorf("white balance %v: %w", mul, nef.ErrFormat)
+	}
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			var (
+				px    = raw.Gray16At(rect.Min.X+x, rect.Min.Y+y).Y
+				c     = cfa.At(x, y)
+				black = p.black(cfa, x, y)
+				v     = (float64(px) - black) / (p.White - black)
+			)
+			v *= mul[c] / min
+			scaled.SetGray16(rect.Min.X+x, rect.Min.Y+y, color.Gray16{Y: toUint16(v)})
+		}
+	}
+	img, err := nef.DemosaicImage(scaled, cfa, opt.Demosaic)
+	if err != nil {
+		return nil, err
+	}
+	curve := opt.curve()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			var (
+				px  = img.RGBA64At(x, y)
+				cam = [3]float64{
+					float64(px.R) / 0xffff,
+					float64(px.G) / 0xffff,
+					float64(px.B) / 0xffff,
+				}
+				out = conv.apply(cam)
+			)
+			px.R = toUint16(curve(clip(out[0])))
+			px.G = toUint16(curve(clip(out[1])))
+			px.B = toUint16(curve(clip(out[2])))
+			img.SetRGBA64(x, y, px)
+		}
+	}
+	return img, nil
+}
+
+// conversion gives the matrix converting camera values (white balanced) into
+// values of the output space.
+func conversion(xyz [9]float64, space Space) (matrix, error) {
+	var zero [9]float64
+	if xyz == zero {
+		return identity, nil
+	}
+	var out matrix
+	switch space {
+	case SRGB:
+		out = srgbToXYZ
+	case ProPhoto:
+		out = d50ToD65.mul(prophotoToXYZ)
+	default:
+		return identity, fmt.Errorf("%s: unsupported space", space)
+	}
+	cam := matrixFrom(xyz).mul(out).normalize()
+	inv, ok := cam.inverse()
+	if !ok {
+		return identity, fmt.Errorf("camera matrix can not be inverted")
+	}
+	return inv, nil
+}
+
+func clip(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+func toUint16(v float64) uint16 {
+	return uint16(math.Round(clip(v) * 0xffff))
+}
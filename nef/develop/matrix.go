@@ -0,0 +1,96 @@
+package develop
+
+type matrix [3][3]float64
+
+var identity = matrix{
+	{1, 0, 0},
+	{0, 1, 0},
+	{0, 0, 1},
+}
+
+// sRGB (D65) to XYZ.
+var srgbToXYZ = matrix{
+	{0.412453, 0.357580, 0.180423},
+	{0.212671, 0.715160, 0.072169},
+	{0.019334, 0.119193, 0.950227},
+}
+
+// ProPhoto (D50) to XYZ.
+var prophotoToXYZ = matrix{
+	{0.7976749, 0.1351917, 0.0313534},
+	{0.2880402, 0.7118741, 0.0000857},
+	{0.0000000, 0.0000000, 0.8252100},
+}
+
+// Bradford adaptation from D50 to D65 since camera matrices are given for a
+// D65 illuminant.
+var d50ToD65 = matrix{
+	{0.9555766, -0.0230393, 0.0631636},
+	{-0.0282895, 1.0099416, 0.0210077},
+	{0.0122982, -0.0204830, 1.3299098},
+}
+
+func (m matrix) mul(o matrix) matrix {
+	var r matrix
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += m[i][k] * o[k][j]
+			}
+		}
+	}
+	return r
+}
+
+func (m matrix) apply(v [3]float64) [3]float64 {
+	var r [3]float64
+	for i := 0; i < 3; i++ {
+		r[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return r
+}
+
+// normalize scales each row so that it sums to one: white in the output space
+// is then white for the camera.
+func (m matrix) normalize() matrix {
+	for i := 0; i < 3; i++ {
+		sum := m[i][0] + m[i][1] + m[i][2]
+		if sum == 0 {
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			m[i][j] /= sum
+		}
+	}
+	return m
+}
+
+func (m matrix) inverse() (matrix, bool) {
+	var (
+		r   matrix
+		det = m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+			m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+			m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	)
+	if det == 0 {
+		return r, false
+	}
+	r[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	r[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	r[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	r[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	r[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	r[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	r[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	r[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	r[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+	return r, true
+}
+
+func matrixFrom(vs [9]float64) matrix {
+	var m matrix
+	for i := range vs {
+		m[i/3][i%3] = vs[i]
+	}
+	return m
+}
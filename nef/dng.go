@@ -0,0 +1,479 @@
+package nef
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+const (
+	DNGVersion             = 0xc612
+	DNGBackwardVersion     = 0xc613
+	UniqueCameraModel      = 0xc614
+	BlackLevelRepeatDim    = 0xc619
+	BlackLevel             = 0xc61a
+	BlackLevelDeltaH       = 0xc61b
+	BlackLevelDeltaV       = 0xc61c
+	WhiteLevel             = 0xc61d
+	DefaultCropOrigin      = 0xc61f
+	DefaultCropSize        = 0xc620
+	ColorMatrix1           = 0xc621
+	ColorMatrix2           = 0xc622
+	CameraCalibration1     = 0xc623
+	CameraCalibration2     = 0xc624
+	AnalogBalance          = 0xc627
+	AsShotNeutral          = 0xc628
+	BaselineExposure       = 0xc62a
+	MakerNoteSafety        = 0xc635
+	CalibrationIlluminant1 = 0xc65a
+	CalibrationIlluminant2 = 0xc65b
+	ActiveArea             = 0xc68d
+	ForwardMatrix1         = 0xc714
+	ForwardMatrix2         = 0xc715
+	OpcodeList1            = 0xc740
+	OpcodeList2            = 0xc741
+	OpcodeList3            = 0xc74e
+)
+
+// Illuminants used by the CalibrationIlluminant tags (EXIF LightSource).
+const (
+	IlluminantA   = 17
+	IlluminantD55 = 20
+	IlluminantD65 = 21
+	IlluminantD50 = 23
+)
+
+func (f File) IsDNG() bool {
+	return f.Has(DNGVersion)
+}
+
+// DNG holds the colour metadata of a DNG, found in its main directory. The
+// matrices are given row by row, with 3 columns and as many rows as colours in
+// the camera; the entries of index 0 and 1 match the two calibration
+// illuminants.
+type DNG struct {
+	Version         string
+	BackwardVersion string
+	Model           string
+
+	Illuminants       [2]uint16
+	ColorMatrix       [2][]float64
+	ForwardMatrix     [2][]float64
+	CameraCalibration [2][]float64
+
+	AnalogBalance    []float64
+	AsShotNeutral    []float64
+	BaselineExposure float64
+}
+
+// Matrix gives the colour matrix calibrated for the illuminant given or the
+// other one when there is no matrix for it.
+func (d DNG) Matrix(illuminant uint16) []float64 {
+	if len(d.ColorMatrix[1]) == 0 || d.Illuminants[0] == illuminant {
+		return d.ColorMatrix[0]
+	}
+	return d.ColorMatrix[1]
+}
+
+func (f File) DNG() (DNG, error) {
+	var d DNG
+	t, err := f.get(DNGVersion)
+	if err != nil {
+		return d, err
+	}
+	d.Version = dngVersion(t)
+	if t, err := f.get(DNGBackwardVersion); err == nil {
+		d.BackwardVersion = dngVersion(t)
+	}
+	if t, err := f.get(UniqueCameraModel); err == nil {
+		d.Model = strings.TrimSpace(t.String())
+	}
+	for i, id := range []uint16{CalibrationIlluminant1, CalibrationIlluminant2} {
+		if t, err := f.get(id); err == nil {
+			d.Illuminants[i] = uint16(t.Uint())
+		}
+	}
+	d.ColorMatrix[0] = f.floats(ColorMatrix1)
+	d.ColorMatrix[1] = f.floats(ColorMatrix2)
+	d.ForwardMatrix[0] = f.floats(ForwardMatrix1)
+	d.ForwardMatrix[1] = f.floats(ForwardMatrix2)
+	d.CameraCalibration[0] = f.floats(CameraCalibration1)
+	d.CameraCalibration[1] = f.floats(CameraCalibration2)
+	d.AnalogBalance = f.floats(AnalogBalance)
+	d.AsShotNeutral = f.floats(AsShotNeutral)
+	if t, err := f.get(BaselineExposure); err == nil {
+		d.BaselineExposure = t.Float()
+	}
+	for _, m := range d.ColorMatrix {
+		if len(m)%3 != 0 {
+			return d, fmt.Errorf("color matrix with %d values: %w", len(m), ErrFormat)
+		}
+	}
+	return d, nil
+}
+
+// DNGRaw holds the values describing the sensor data of a raw directory of a
+// DNG.
+type DNGRaw struct {
+	// BlackRepeat gives the rows and columns of the black level pattern.
+	BlackRepeat [2]int
+	Black       []float64
+	BlackDeltaH []float64
+	BlackDeltaV []float64
+	White       []float64
+
+	// ActiveArea is the part of the image holding valid sensor data, the
+	// default crop being relative to it.
+	ActiveArea image.Rectangle
+	CropOrigin [2]float64
+	CropSize   [2]float64
+
+	Opcodes [3][]Opcode
+}
+
+// Crop gives the default crop of the image in the coordinates of the raw
+// image.
+func (d DNGRaw) Crop() image.Rectangle {
+	if d.CropSize[0] == 0 || d.CropSize[1] == 0 {
+		return d.ActiveArea
+	}
+	var (
+		x = d.ActiveArea.Min.X + int(d.CropOrigin[0])
+		y = d.ActiveArea.Min.Y + int(d.CropOrigin[1])
+		r = image.Rect(x, y, x+int(d.CropSize[0]), y+int(d.CropSize[1]))
+	)
+	return r.Intersect(d.ActiveArea)
+}
+
+// BlackAt gives the black level of the first sample of the pixel at x, y
+// (relative to the active area).
+func (d DNGRaw) BlackAt(x, y int) float64 {
+	var black float64
+	if rows, cols := d.BlackRepeat[0], d.BlackRepeat[1]; rows > 0 && cols > 0 && len(d.Black) > 0 {
+		i := ((y%rows)*cols + x%cols) * (len(d.Black) / (rows * cols))
+		if i < len(d.Black) {
+			black = d.Black[i]
+		}
+	}
+	if x < len(d.BlackDeltaH) {
+		black += d.BlackDeltaH[x]
+	}
+	if y < len(d.BlackDeltaV) {
+		black += d.BlackDeltaV[y]
+	}
+	return black
+}
+
+func (f File) DNGRaw() (DNGRaw, error) {
+	var (
+		d         DNGRaw
+		width, _  = f.get(ImageWidth)
+		height, _ = f.get(ImageLength)
+		bits      = f.bitsPerSample()
+	)
+	d.BlackRepeat = [2]int{1, 1}
+	if vs := f.floats(BlackLevelRepeatDim); len(vs) == 2 {
+		d.BlackRepeat = [2]int{int(vs[0]), int(vs[1])}
+	}
+	d.Black = f.floats(BlackLevel)
+	d.BlackDeltaH = f.floats(BlackLevelDeltaH)
+	d.BlackDeltaV = f.floats(BlackLevelDeltaV)
+	d.White = f.floats(WhiteLevel)
+	if len(d.White) == 0 && bits > 0 {
+		d.White = []float64{math.Exp2(float64(bits)) - 1}
+	}
+	d.ActiveArea = image.Rect(0, 0, int(width.Uint()), int(height.Uint()))
+	if vs := f.floats(ActiveArea); len(vs) == 4 {
+		// top, left, bottom, right
+		d.ActiveArea = image.Rect(int(vs[1]), int(vs[0]), int(vs[3]), int(vs[2]))
+	}
+	if vs := f.floats(DefaultCropOrigin); len(vs) == 2 {
+		copy(d.CropOrigin[:], vs)
+	}
+	if vs := f.floats(DefaultCropSize); len(vs) == 2 {
+		copy(d.CropSize[:], vs)
+	}
+	for i, id := range []uint16{OpcodeList1, OpcodeList2, OpcodeList3} {
+		t, err := f.get(id)
+		if err != nil {
+			continue
+		}
+		if d.Opcodes[i], err = DecodeOpcodeList(t); err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+// floats gives the values of a tag holding integers or rationals.
+func (f File) floats(id uint16) []float64 {
+	t, err := f.get(id)
+	if err != nil {
+		return nil
+	}
+	switch t.Type {
+	case Byte, Short, Long, Long8:
+		var fs []float64
+		for _, v := range tagUint64s(t) {
+			fs = append(fs, float64(v))
+		}
+		return fs
+	default:
+		return t.Floats()
+	}
+}
+
+func dngVersion(t Tag) string {
+	if len(t.Raw) < 4 {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d.%d.%d", t.Raw[0], t.Raw[1], t.Raw[2], t.Raw[3])
+}
+
+const (
+	OpWarpRectilinear = iota + 1
+	OpWarpFisheye
+	OpFixVignetteRadial
+	OpFixBadPixelsConstant
+	OpFixBadPixelsList
+	OpTrimBounds
+	OpMapTable
+	OpMapPolynomial
+	OpGainMap
+	OpDeltaPerRow
+	OpDeltaPerColumn
+	OpScalePerRow
+	OpScalePerColumn
+	OpWarpRectilinear2
+)
+
+var opcodes = map[uint32]string{
+	OpWarpRectilinear:      "WarpRectilinear",
+	OpWarpFisheye:          "WarpFisheye",
+	OpFixVignetteRadial:    "FixVignetteRadial",
+	OpFixBadPixelsConstant: "FixBadPixelsConstant",
+	OpFixBadPixelsList:     "FixBadPixelsList",
+	OpTrimBounds:           "TrimBounds",
+	OpMapTable:             "MapTable",
+	OpMapPolynomial:        "MapPolynomial",
+	OpGainMap:              "GainMap",
+	OpDeltaPerRow:          "DeltaPerRow",
+	OpDeltaPerColumn:       "DeltaPerColumn",
+	OpScalePerRow:          "ScalePerRow",
+	OpScalePerColumn:       "ScalePerColumn",
+	OpWarpRectilinear2:     "WarpRectilinear2",
+}
+
+// Opcode is an operation to apply on the image. Its parameters are kept as
+// found in the list, always in big endian.
+type Opcode struct {
+	Id      uint32
+	Version string
+	// Optional opcodes can be skipped by readers that do not know them.
+	Optional bool
+	// Preview reports that the opcode can be skipped for preview quality
+	// processing.
+	Preview bool
+	Data    []byte
+}
+
+func (o Opcode) Name() string {
+	str, ok := opcodes[o.Id]
+	if !ok {
+		str = fmt.Sprintf("Unknown(%d)", o.Id)
+	}
+	return str
+}
+
+func (o Opcode) String() string {
+	return o.Name()
+}
+
+// DecodeOpcodeList decodes the OpcodeList tags. Lists are stored in big
+// endian whatever the byte order of the file.
+func DecodeOpcodeList(t Tag) ([]Opcode, error) {
+	switch t.Id {
+	case OpcodeList1, OpcodeList2, OpcodeList3:
+	default:
+		return nil, fmt.Errorf("opcode list: %w", ErrFormat)
+	}
+	buf := t.Raw[:t.Size()]
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("opcode list: %w", errTruncated)
+	}
+	var (
+		count = binary.BigEndian.Uint32(buf)
+		list  []Opcode
+	)
+	buf = buf[4:]
+	for i := 0; i < int(count); i++ {
+		if len(buf) < 16 {
+			return nil, fmt.Errorf("opcode list: %w", errTruncated)
+		}
+		var (
+			o = Opcode{
+				Id:      binary.BigEndian.Uint32(buf),
+				Version: fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7]),
+			}
+			flags = binary.BigEndian.Uint32(buf[8:])
+			size  = binary.BigEndian.Uint32(buf[12:])
+		)
+		buf = buf[16:]
+		if int(size) > len(buf) {
+			return nil, fmt.Errorf("opcode %s: %w", o.Name(), errTruncated)
+		}
+		o.Optional = flags&1 == 1
+		o.Preview = flags&2 == 2
+		o.Data, buf = buf[:size], buf[size:]
+		list = append(list, o)
+	}
+	return list, nil
+}
+
+// Area is the part of the image on which an opcode is applied: the samples
+// of the planes from Plane to Plane+Planes in the rows and columns of Bounds
+// selected by the pitches.
+type Area struct {
+	Bounds   image.Rectangle
+	Plane    int
+	Planes   int
+	RowPitch int
+	ColPitch int
+}
+
+func decodeArea(buf []byte) Area {
+	var (
+		top    = int(binary.BigEndian.Uint32(buf))
+		left   = int(binary.BigEndian.Uint32(buf[4:]))
+		bottom = int(binary.BigEndian.Uint32(buf[8:]))
+		right  = int(binary.BigEndian.Uint32(buf[12:]))
+	)
+	return Area{
+		Bounds:   image.Rect(left, top, right, bottom),
+		Plane:    int(binary.BigEndian.Uint32(buf[16:])),
+		Planes:   int(binary.BigEndian.Uint32(buf[20:])),
+		RowPitch: int(binary.BigEndian.Uint32(buf[24:])),
+		ColPitch: int(binary.BigEndian.Uint32(buf[28:])),
+	}
+}
+
+// GainMap gives the gains to apply on an area of the image: the gains are
+// sampled on a grid of Points and are interpolated between them.
+type GainMap struct {
+	Area
+	Points    [2]int
+	Spacing   [2]float64
+	Origin    [2]float64
+	MapPlanes int
+	Gains     []float32
+}
+
+// Gain gives the gain of a pixel at x, y of the plane given relative to the
+// top left corner of the image of size w x h.
+func (g GainMap) Gain(x, y, plane int, w, h int) float64 {
+	var (
+		rows = g.Points[0]
+		cols = g.Points[1]
+		maps = g.MapPlanes
+	)
+	if rows == 0 || cols == 0 || maps == 0 || w == 0 || h == 0 || len(g.Gains) < rows*cols*maps {
+		return 1
+	}
+	if plane -= g.Plane; plane >= maps {
+		plane = maps - 1
+	} else if plane < 0 {
+		plane = 0
+	}
+	var (
+		u  = gainIndex((float64(y)/float64(h)-g.Origin[0])/g.Spacing[0], rows)
+		v  = gainIndex((float64(x)/float64(w)-g.Origin[1])/g.Spacing[1], cols)
+		r  = int(u)
+		c  = int(v)
+		r1 = r
+		c1 = c
+	)
+	if r1 < rows-1 {
+		r1++
+	}
+	if c1 < cols-1 {
+		c1++
+	}
+	var (
+		at = func(r, c int) float64 {
+			return float64(g.Gains[(r*cols+c)*maps+plane])
+		}
+		top = at(r, c) + (at(r, c1)-at(r, c))*(v-float64(c))
+		bot = at(r1, c) + (at(r1, c1)-at(r1, c))*(v-float64(c))
+	)
+	return top + (bot-top)*(u-float64(r))
+}
+
+func gainIndex(v float64, n int) float64 {
+	return math.Max(0, math.Min(v, float64(n-1)))
+}
+
+func (o Opcode) GainMap() (GainMap, error) {
+	var g GainMap
+	if o.Id != OpGainMap || len(o.Data) < 76 {
+		return g, fmt.Errorf("gain map: %w", ErrFormat)
+	}
+	buf := o.Data
+	g.Area = decodeArea(buf)
+	g.Points[0] = int(binary.BigEndian.Uint32(buf[32:]))
+	g.Points[1] = int(binary.BigEndian.Uint32(buf[36:]))
+	g.Spacing[0] = math.Float64frombits(binary.BigEndian.Uint64(buf[40:]))
+	g.Spacing[1] = math.Float64frombits(binary.BigEndian.Uint64(buf[48:]))
+	g.Origin[0] = math.Float64frombits(binary.BigEndian.Uint64(buf[56:]))
+	g.Origin[1] = math.Float64frombits(binary.BigEndian.Uint64(buf[64:]))
+	g.MapPlanes = int(binary.BigEndian.Uint32(buf[72:]))
+	var (
+		count = 1
+		avail = (len(buf) - 76) / 4
+	)
+	for _, n := range []int{g.Points[0], g.Points[1], g.MapPlanes} {
+		if n < 0 || n > 0 && count > avail/n {
+			return g, fmt.Errorf("gain map: %w", errTruncated)
+		}
+		count *= n
+	}
+	g.Gains = make([]float32, count)
+	for i := range g.Gains {
+		g.Gains[i] = math.Float32frombits(binary.BigEndian.Uint32(buf[76+i*4:]))
+	}
+	return g, nil
+}
+
+// WarpRectilinear gives the coefficients of the radial and tangential lens
+// distortion model for each plane, the centre being relative to the image.
+type WarpRectilinear struct {
+	Coefficients [][6]float64
+	Center       [2]float64
+}
+
+func (o Opcode) WarpRectilinear() (WarpRectilinear, error) {
+	var w WarpRectilinear
+	if o.Id != OpWarpRectilinear || len(o.Data) < 4 {
+		return w, fmt.Errorf("warp rectilinear: %w", ErrFormat)
+	}
+	var (
+		buf    = o.Data
+		planes = int(binary.BigEndian.Uint32(buf))
+	)
+	if len(buf) < 20 || planes > (len(buf)-20)/48 {
+		return w, fmt.Errorf("warp rectilinear: %w", errTruncated)
+	}
+	buf = buf[4:]
+	for i := 0; i < planes; i++ {
+		var cs [6]float64
+		for j := range cs {
+			cs[j] = math.Float64frombits(binary.BigEndian.Uint64(buf[j*8:]))
+		}
+		w.Coefficients = append(w.Coefficients, cs)
+		buf = buf[48:]
+	}
+	w.Center[0] = math.Float64frombits(binary.BigEndian.Uint64(buf))
+	w.Center[1] = math.Float64frombits(binary.BigEndian.Uint64(buf[8:]))
+	return w, nil
+}
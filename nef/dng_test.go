@@ -0,0 +1,31 @@
+package nef
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestOpcodeMalformed(t *testing.T) {
+	gain := make([]byte, 80)
+	binary.BigEndian.PutUint32(gain[32:], 1<<31)
+	binary.BigEndian.PutUint32(gain[36:], 1<<31)
+	binary.BigEndian.PutUint32(gain[72:], 1)
+	if _, err := (Opcode{Id: OpGainMap, Data: gain}).GainMap(); err == nil {
+		t.Errorf("gain map: oversized map should fail")
+	}
+	binary.BigEndian.PutUint32(gain[32:], 1)
+	binary.BigEndian.PutUint32(gain[36:], 1)
+	g, err := (Opcode{Id: OpGainMap, Data: gain}).GainMap()
+	if err != nil {
+		t.Fatalf("gain map: unexpected error: %s", err)
+	}
+	if len(g.Gains) != 1 {
+		t.Errorf("gain map: want 1 gain, got %d", len(g.Gains))
+	}
+
+	warp := make([]byte, 20)
+	binary.BigEndian.PutUint32(warp, 1<<30)
+	if _, err := (Opcode{Id: OpWarpRectilinear, Data: warp}).WarpRectilinear(); err == nil {
+		t.Errorf("warp rectilinear: oversized planes should fail")
+	}
+}
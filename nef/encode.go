@@ -0,0 +1,559 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+var ErrLarge = errors.New("file too large for classic tiff")
+
+const interopIFD = 0xa005
+
+// Encoder writes directories as a TIFF file. The image data of the
+// directories (strips, tiles and embedded JPEG) are copied and their offsets
+// updated. Maker notes are copied as they are.
+type Encoder struct {
+	// Order is the byte order of the file. The order of the first directory
+	// is used when it is not set.
+	Order   binary.ByteOrder
+	BigTiff bool
+}
+
+// Encode writes the files as a TIFF file using the byte order of the first
+// one. A BigTIFF is written if the files were decoded from a BigTIFF.
+func Encode(w io.Writer, files []*File) error {
+	var e Encoder
+	if len(files) > 0 {
+		e.BigTiff = files[0].bigtiff
+	}
+	return e.Encode(w, files)
+}
+
+func EncodeFile(file string, files []*File) error {
+	w, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return Encode(w, files)
+}
+
+func (e Encoder) Encode(w io.Writer, files []*File) error {
+	if len(files) == 0 {
+		return ErrImage
+	}
+	tw := tiffWriter{
+		order:   e.Order,
+		bigtiff: e.BigTiff,
+	}
+	if tw.order == nil {
+		tw.order = files[0].order
+	}
+	if tw.order == nil {
+		tw.order = binary.BigEndian
+	}
+	// the directories are written after the image data, whose size is known
+	// once the files are laid out a first time.
+	if _, err := tw.layout(files); err != nil {
+		return err
+	}
+	tw.base = tw.size + tw.size%2
+	first, err := tw.layout(files)
+	if err != nil {
+		return err
+	}
+	if !tw.bigtiff && tw.base+uint64(len(tw.buf)) > math.MaxUint32 {
+		return ErrLarge
+	}
+	if _, err := w.Write(tw.header(first)); err != nil {
+		return err
+	}
+	pos := tw.headerLen()
+	for _, b := range tw.blocks {
+		if err := writeZeros(w, b.at-pos); err != nil {
+			return err
+		}
+		n, err := io.Copy(w, io.NewSectionReader(b.reader, b.offset, b.length))
+		if err != nil {
+			return err
+		}
+		if n < b.length {
+			return fmt.Errorf("image data at %d: %w", b.offset, errTruncated)
+		}
+		pos = b.at + uint64(b.length)
+	}
+	if err := writeZeros(w, tw.base-pos); err != nil {
+		return err
+	}
+	_, err = w.Write(tw.buf)
+	return err
+}
+
+// tiffWriter lays out the directories of a file. The image data are copied
+// from the files only once the directories are written in buf: they come
+// first in the file, after the header, and the directories, starting at base,
+// follow them.
+type tiffWriter struct {
+	buf     []byte
+	base    uint64
+	size    uint64
+	blocks  []dataBlock
+	order   binary.ByteOrder
+	bigtiff bool
+}
+
+// dataBlock is a part of a file to copy at the given offset of the output.
+type dataBlock struct {
+	reader io.ReaderAt
+	offset int64
+	length int64
+	at     uint64
+}
+
+// layout writes the directories of the files and gives the offset of the
+// first one.
+func (w *tiffWriter) layout(files []*File) (uint64, error) {
+	w.buf, w.blocks = w.buf[:0], w.blocks[:0]
+	w.size = uint64(w.headerLen())
+	var (
+		first uint64
+		next  = -1
+	)
+	for _, f := range files {
+		at, pos, err := w.writeFile(f, false)
+		if err != nil {
+			return 0, err
+		}
+		if next < 0 {
+			first = at
+		} else {
+			w.putOffset(next, at)
+		}
+		next = pos
+	}
+	return first, nil
+}
+
+func (w *tiffWriter) headerLen() uint64 {
+	if w.bigtiff {
+		return 16
+	}
+	return 8
+}
+
+// header gives the header of the file with the offset of the first directory.
+func (w *tiffWriter) header(first uint64) []byte {
+	var buf, magic []byte
+	if w.order == binary.LittleEndian {
+		buf, magic = append(buf, little...), magicle
+		if w.bigtiff {
+			magic = bigle
+		}
+	} else {
+		buf, magic = append(buf, big...), magicbe
+		if w.bigtiff {
+			magic = bigbe
+		}
+	}
+	buf = append(buf, magic...)
+	if w.bigtiff {
+		buf = append(buf, make([]byte, 8)...)
+		w.order.PutUint64(buf[len(buf)-8:], first)
+	} else {
+		buf = append(buf, make([]byte, 4)...)
+		w.order.PutUint32(buf[len(buf)-4:], uint32(first))
+	}
+	return buf
+}
+
+func writeZeros(w io.Writer, n uint64) error {
+	if n == 0 {
+		return nil
+	}
+	_, err := w.Write(make([]byte, n))
+	return err
+}
+
+// writeFile writes the data and the sub directories of a file followed by its
+// directory. It gives the offset of the directory and the position of the
+// offset of the next one.
+func (w *tiffWriter) writeFile(f *File, sub bool) (uint64, int, error) {
+	var (
+		tags = append([]Tag{}, f.tiff...)
+		err  error
+	)
+	if f.Has(StripOffsets) && f.Has(StripByteCounts) {
+		if tags, err = w.copyData(f, tags, StripOffsets, StripByteCounts); err != nil {
+			return 0, 0, err
+		}
+	}
+	if f.IsTiled() {
+		if tags, err = w.copyData(f, tags, TileOffsets, TileByteCounts); err != nil {
+			return 0, 0, err
+		}
+	}
+	if f.IsJpeg() {
+		var (
+			start, _  = f.get(JpegFromRawStart)
+			length, _ = f.get(JpegFromRawLength)
+			at        = w.writeData(f.reader, start.Uint64(), length.Uint64())
+		)
+		tags = setTag(tags, w.offsetTag(JpegFromRawStart, at))
+	}
+	tags = removeTags(tags, Nef, Exif, Gps)
+	if len(f.Files) > 0 {
+		var list []uint64
+		for _, c := range f.Files {
+			at, _, err := w.writeFile(c, true)
+			if err != nil {
+				return 0, 0, err
+			}
+			list = append(list, at)
+		}
+		tags = setTag(tags, w.offsetTag(Nef, list...))
+	}
+	if !sub && len(f.exif) > 0 {
+		at, _, err := w.writeTags(removeTags(f.exif, interopIFD))
+		if err != nil {
+			return 0, 0, err
+		}
+		tags = setTag(tags, w.offsetTag(Exif, at))
+	}
+	if !sub && len(f.gps) > 0 {
+		at, _, err := w.writeTags(f.gps)
+		if err != nil {
+			return 0, 0, err
+		}
+		tags = setTag(tags, w.offsetTag(Gps, at))
+	}
+	return w.writeTags(tags)
+}
+
+func (w *tiffWriter) copyData(f *File, tags []Tag, offsetId, countId uint16) ([]Tag, error) {
+	var (
+		offset, _ = f.get(offsetId)
+		count, _  = f.get(countId)
+		offsets   = tagUint64s(offset)
+		counts    = tagUint64s(count)
+		list      = make([]uint64, len(offsets))
+	)
+	if len(offsets) != len(counts) {
+		return nil, fmt.Errorf("%d offsets for %d byte counts: %w", len(offsets), len(counts), ErrFormat)
+	}
+	for i := range offsets {
+		list[i] = w.writeData(f.reader, offsets[i], counts[i])
+	}
+	tags = setTag(tags, w.offsetTag(offsetId, list...))
+	return setTag(tags, w.offsetTag(countId, counts...)), nil
+}
+
+// writeTags writes the values that do not fit in the entries followed by the
+// directory.
+func (w *tiffWriter) writeTags(tags []Tag) (uint64, int, error) {
+	tags = append([]Tag{}, tags...)
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].Id < tags[j].Id })
+
+	size := 4
+	if w.bigtiff {
+		size = 8
+	}
+	var (
+		types  = make([]Format, len(tags))
+		fields = make([][]byte, len(tags))
+	)
+	for i, t := range tags {
+		typ, raw, err := w.value(t)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%04x: %w", t.Id, err)
+		}
+		types[i] = typ
+		if len(raw) > size {
+			at := w.write(raw)
+			raw = make([]byte, size)
+			if w.bigtiff {
+				w.order.PutUint64(raw, at)
+			} else {
+				w.order.PutUint32(raw, uint32(at))
+			}
+		}
+		fields[i] = append(raw, make([]byte, size-len(raw))...)
+	}
+	if !w.bigtiff && len(tags) > math.MaxUint16 {
+		return 0, 0, fmt.Errorf("%d tags: %w", len(tags), ErrLarge)
+	}
+	w.align()
+	at := w.base + uint64(len(w.buf))
+	if w.bigtiff {
+		w.put64(uint64(len(tags)))
+	} else {
+		w.put16(uint16(len(tags)))
+	}
+	for i, t := range tags {
+		w.put16(t.Id)
+		w.put16(uint16(types[i]))
+		if w.bigtiff {
+			w.put64(t.Count)
+		} else if t.Count > math.MaxUint32 {
+			return 0, 0, fmt.Errorf("%04x: %d values: %w", t.Id, t.Count, ErrLarge)
+		} else {
+			w.put32(uint32(t.Count))
+		}
+		w.buf = append(w.buf, fields[i]...)
+	}
+	pos := len(w.buf)
+	w.putOffset(-1, 0)
+	return at, pos, nil
+}
+
+// value gives the type and the bytes of the value of a tag in the byte order
+// of the file. Values on 8 bytes are written on 4 bytes in classic files.
+func (w *tiffWriter) value(t Tag) (Format, []byte, error) {
+	size := t.Size()
+	if len(t.Raw) < size {
+		return t.Type, nil, fmt.Errorf("%d bytes for %d: %w", len(t.Raw), size, errTruncated)
+	}
+	var (
+		raw   = append([]byte{}, t.Raw[:size]...)
+		order = t.ByteOrder()
+	)
+	if order != w.order {
+		n := t.Type.Size()
+		if t.Type == Rational || t.Type == SRational {
+			n = 4
+		}
+		for i := 0; n > 1 && i+n <= len(raw); i += n {
+			for j := 0; j < n/2; j++ {
+				raw[i+j], raw[i+n-1-j] = raw[i+n-1-j], raw[i+j]
+			}
+		}
+	}
+	if w.bigtiff {
+		return t.Type, raw, nil
+	}
+	var typ Format
+	switch t.Type {
+	case Long8, Ifd8:
+		typ = Long
+	case SLong8:
+		typ = SLong
+	default:
+		return t.Type, raw, nil
+	}
+	narrow := make([]byte, 0, len(raw)/2)
+	for i := 0; i+8 <= len(raw); i += 8 {
+		v := w.order.Uint64(raw[i:])
+		if typ == SLong && (int64(v) < math.MinInt32 || int64(v) > math.MaxInt32) {
+			return typ, nil, ErrLarge
+		}
+		if typ == Long && v > math.MaxUint32 {
+			return typ, nil, ErrLarge
+		}
+		narrow = narrow[:len(narrow)+4]
+		w.order.PutUint32(narrow[len(narrow)-4:], uint32(v))
+	}
+	return typ, narrow, nil
+}
+
+func (w *tiffWriter) offsetTag(id uint16, values ...uint64) Tag {
+	typ := Format(Long)
+	if w.bigtiff {
+		typ = Long8
+	}
+	return NewTag(id, typ, w.order, values...)
+}
+
+func (w *tiffWriter) write(buf []byte) uint64 {
+	w.align()
+	at := w.base + uint64(len(w.buf))
+	w.buf = append(w.buf, buf...)
+	return at
+}
+
+// writeData adds a block of image data and gives its offset in the output.
+func (w *tiffWriter) writeData(r io.ReaderAt, offset, length uint64) uint64 {
+	w.size += w.size % 2
+	b := dataBlock{
+		reader: r,
+		offset: int64(offset),
+		length: int64(length),
+		at:     w.size,
+	}
+	w.blocks = append(w.blocks, b)
+	w.size += length
+	return b.at
+}
+
+func (w *tiffWriter) align() {
+	if len(w.buf)%2 == 1 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *tiffWriter) put16(v uint16) {
+	w.buf = append(w.buf, 0, 0)
+	w.order.PutUint16(w.buf[len(w.buf)-2:], v)
+}
+
+func (w *tiffWriter) put32(v uint32) {
+	w.buf = append(w.buf, 0, 0, 0, 0)
+	w.order.PutUint32(w.buf[len(w.buf)-4:], v)
+}
+
+func (w *tiffWriter) put64(v uint64) {
+	w.buf = append(w.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+	w.order.PutUint64(w.buf[len(w.buf)-8:], v)
+}
+
+// putOffset writes an offset at the given position or at the end of the
+// directories when the position is negative.
+func (w *tiffWriter) putOffset(pos int, v uint64) {
+	if pos < 0 {
+		if w.bigtiff {
+			w.put64(v)
+		} else {
+			w.put32(uint32(v))
+		}
+		return
+	}
+	if w.bigtiff {
+		w.order.PutUint64(w.buf[pos:], v)
+	} else {
+		w.order.PutUint32(w.buf[pos:], uint32(v))
+	}
+}
+
+// NewTag gives a tag holding unsigned integers: Byte, Short, Long, Long8 and
+// their Ifd counterparts.
+func NewTag(id uint16, typ Format, order binary.ByteOrder, values ...uint64) Tag {
+	t := Tag{
+		Id:    id,
+		Type:  typ,
+		Count: uint64(len(values)),
+		order: order,
+	}
+	t.Raw = make([]byte, t.Size())
+	for i, v := range values {
+		switch typ {
+		case Byte:
+			t.Raw[i] = byte(v)
+		case Short:
+			order.PutUint16(t.Raw[i*2:], uint16(v))
+		case Long, Ifd:
+			order.PutUint32(t.Raw[i*4:], uint32(v))
+		case Long8, Ifd8:
+			order.PutUint64(t.Raw[i*8:], v)
+		}
+	}
+	if len(t.Raw) < 4 {
+		t.Raw = append(t.Raw, make([]byte, 4-len(t.Raw))...)
+	}
+	if t.Size() <= 4 {
+		t.Offset = uint64(order.Uint32(t.Raw))
+	}
+	return t
+}
+
+func NewString(id uint16, str string) Tag {
+	raw := append([]byte(str), 0)
+	t := Tag{
+		Id:    id,
+		Type:  String,
+		Count: uint64(len(raw)),
+		order: binary.BigEndian,
+	}
+	if len(raw) < 4 {
+		raw = append(raw, make([]byte, 4-len(raw))...)
+	}
+	t.Raw = raw
+	return t
+}
+
+// NewBytes gives a tag of type Byte or Undef holding raw.
+func NewBytes(id uint16, typ Format, raw []byte) Tag {
+	t := Tag{
+		Id:    id,
+		Type:  typ,
+		Count: uint64(len(raw)),
+		order: binary.BigEndian,
+		Raw:   append([]byte{}, raw...),
+	}
+	if len(t.Raw) < 4 {
+		t.Raw = append(t.Raw, make([]byte, 4-len(t.Raw))...)
+	}
+	return t
+}
+
+// NewRationals gives a tag of type Rational or SRational, the values being
+// written with the given denominator.
+func NewRationals(id uint16, typ Format, order binary.ByteOrder, denom int32, values ...float64) Tag {
+	t := Tag{
+		Id:    id,
+		Type:  typ,
+		Count: uint64(len(values)),
+		order: order,
+	}
+	t.Raw = make([]byte, t.Size())
+	for i, v := range values {
+		n := uint32(int32(math.Round(v * float64(denom))))
+		if typ == Rational {
+			n = uint32(math.Round(math.Max(0, v) * float64(denom)))
+		}
+		order.PutUint32(t.Raw[i*8:], n)
+		order.PutUint32(t.Raw[i*8+4:], uint32(denom))
+	}
+	return t
+}
+
+// NewFile gives a directory made of the tags given, the offsets of its
+// strips and tiles being relative to the start of data.
+func NewFile(order binary.ByteOrder, data []byte, tags ...Tag) *File {
+	f := File{
+		reader: bytes.NewReader(data),
+		order:  order,
+	}
+	for _, t := range tags {
+		f.SetTag(t, Tiff)
+	}
+	return &f
+}
+
+// SetTag adds or replaces a tag of a directory.
+func (f *File) SetTag(t Tag, origin int) {
+	switch origin {
+	case Tiff, Nef:
+		t.family = Tiff
+		f.tiff = setTag(f.tiff, t)
+	case Exif:
+		t.family = Exif
+		f.exif = setTag(f.exif, t)
+	case Gps:
+		t.family = Gps
+		f.gps = setTag(f.gps, t)
+	case Note:
+		t.family = Note
+		f.notes = setTag(f.notes, t)
+	}
+}
+
+func removeTags(tags []Tag, ids ...uint16) []Tag {
+	list := make([]Tag, 0, len(tags))
+	for _, t := range tags {
+		keep := true
+		for _, id := range ids {
+			if t.Id == id {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			list = append(list, t)
+		}
+	}
+	return list
+}
@@ -0,0 +1,79 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	data := []struct {
+		Name    string
+		Order   binary.ByteOrder
+		BigTiff bool
+	}{
+		{Name: "tiff-le", Order: binary.LittleEndian},
+		{Name: "tiff-be", Order: binary.BigEndian},
+		{Name: "bigtiff-le", Order: binary.LittleEndian, BigTiff: true},
+		{Name: "bigtiff-be", Order: binary.BigEndian, BigTiff: true},
+	}
+	var (
+		order = binary.LittleEndian
+		strip = []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}
+		jpeg  = []byte{0xff, 0xd8, 0xff, 0xd9}
+		blob  = append(append([]byte{}, strip...), jpeg...)
+	)
+	for _, d := range data {
+		main := NewFile(order, blob,
+			NewTag(ImageWidth, Short, order, 3),
+			NewTag(ImageLength, Short, order, 3),
+			NewTag(BitsPerSample, Short, order, 8),
+			NewTag(Photometric, Short, order, uint64(ImgBlackIsZero)),
+			NewTag(StripOffsets, Long, order, 0, 5),
+			NewTag(RowsPerStrip, Short, order, 2),
+			NewTag(StripByteCounts, Long, order, 5, 4),
+			NewString(tiffMake, "NIKON CORPORATION"),
+		)
+		main.SetTag(NewString(0x9003, "2020:01:02 03:04:05"), Exif)
+		preview := NewFile(order, blob,
+			NewTag(NewSubfileType, Long, order, 1),
+			NewTag(JpegFromRawStart, Long, order, uint64(len(strip))),
+			NewTag(JpegFromRawLength, Long, order, uint64(len(jpeg))),
+		)
+		main.Files = append(main.Files, preview)
+
+		var (
+			buf bytes.Buffer
+			enc = Encoder{Order: d.Order, BigTiff: d.BigTiff}
+		)
+		if err := enc.Encode(&buf, []*File{main}); err != nil {
+			t.Errorf("%s: encode: %s", d.Name, err)
+			continue
+		}
+		files, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("%s: decode: %s", d.Name, err)
+			continue
+		}
+		if len(files) != 1 || len(files[0].Files) != 1 {
+			t.Errorf("%s: directories mismatched", d.Name)
+			continue
+		}
+		f := files[0]
+		if f.IsBigTiff() != d.BigTiff || f.ByteOrder() != d.Order {
+			t.Errorf("%s: header mismatched: bigtiff %t, order %s", d.Name, f.IsBigTiff(), f.ByteOrder())
+		}
+		if got, err := f.Bytes(); err != nil || !bytes.Equal(got, strip) {
+			t.Errorf("%s: strips mismatched: want %x, got %x (%v)", d.Name, strip, got, err)
+		}
+		if got, err := f.Files[0].Bytes(); err != nil || !bytes.Equal(got, jpeg) {
+			t.Errorf("%s: preview mismatched: want %x, got %x (%v)", d.Name, jpeg, got, err)
+		}
+		if tag, err := f.GetTag(0x9003, Exif); err != nil || tag.String() != "2020:01:02 03:04:05" {
+			t.Errorf("%s: exif mismatched: %q (%v)", d.Name, tag.String(), err)
+		}
+		if tag, err := f.GetTag(tiffMake, Tiff); err != nil || tag.String() != "NIKON CORPORATION" {
+			t.Errorf("%s: make mismatched: %q (%v)", d.Name, tag.String(), err)
+		}
+	}
+}
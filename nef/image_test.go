@@ -0,0 +1,101 @@
+package nef
+
+import (
+	"encoding/binary"
+	"image/color"
+	"testing"
+)
+
+func TestImageRGB(t *testing.T) {
+	var (
+		order = binary.LittleEndian
+		want  = []color.RGBA{
+			{R: 255, G: 0, B: 0, A: 255},
+			{R: 200, G: 10, B: 20, A: 255},
+		}
+	)
+	data := []struct {
+		Name    string
+		Samples uint64
+		Planar  uint64
+		Strip   []byte
+	}{
+		{
+			Name:    "rgb",
+			Samples: 3,
+			Planar:  1,
+			Strip:   []byte{255, 0, 0, 200, 10, 20},
+		},
+		{
+			Name:    "rgba",
+			Samples: 4,
+			Planar:  1,
+			Strip:   []byte{255, 0, 0, 128, 200, 10, 20, 64},
+		},
+		{
+			Name:    "planar",
+			Samples: 3,
+			Planar:  2,
+			Strip:   []byte{255, 200, 0, 10, 0, 20},
+		},
+	}
+	for _, d := range data {
+		f := NewFile(order, d.Strip,
+			NewTag(ImageWidth, Short, order, 2),
+			NewTag(ImageLength, Short, order, 1),
+			NewTag(BitsPerSample, Short, order, 8),
+			NewTag(Photometric, Short, order, uint64(ImgRGB)),
+			NewTag(StripOffsets, Long, order, 0),
+			NewTag(SamplesPerPixel, Short, order, d.Samples),
+			NewTag(RowsPerStrip, Short, order, 1),
+			NewTag(StripByteCounts, Long, order, uint64(len(d.Strip))),
+			NewTag(PlanarConfiguration, Short, order, d.Planar),
+		)
+		img, err := f.Image()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		for i, w := range want {
+			got := color.RGBAModel.Convert(img.At(i, 0)).(color.RGBA)
+			if got != w {
+				t.Errorf("%s: pixel %d mismatched: want %v, got %v", d.Name, i, w, got)
+			}
+		}
+	}
+}
+
+func TestImageGray(t *testing.T) {
+	order := binary.LittleEndian
+	data := []struct {
+		Name        string
+		Photometric uint32
+		Want        []uint8
+	}{
+		{Name: "white-is-zero", Photometric: ImgWhiteIsZero, Want: []uint8{255, 155, 0}},
+		{Name: "black-is-zero", Photometric: ImgBlackIsZero, Want: []uint8{0, 100, 255}},
+	}
+	strip := []byte{0, 100, 255}
+	for _, d := range data {
+		f := NewFile(order, strip,
+			NewTag(ImageWidth, Short, order, 3),
+			NewTag(ImageLength, Short, order, 1),
+			NewTag(BitsPerSample, Short, order, 8),
+			NewTag(Photometric, Short, order, uint64(d.Photometric)),
+			NewTag(StripOffsets, Long, order, 0),
+			NewTag(RowsPerStrip, Short, order, 1),
+			NewTag(StripByteCounts, Long, order, uint64(len(strip))),
+		)
+		img, err := f.Image()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		for i, w := range d.Want {
+			got := color.GrayModel.Convert(img.At(i, 0)).(color.Gray)
+			if got.Y != w {
+				t.Errorf("%s: pixel %d mismatched: want %d, got %d", d.Name, i, w, got.Y)
+			}
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package nef
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	noteVRInfo        = 0x1f
+	noteISOInfo       = 0x25
+	noteMultiExposure = 0xb0
+	noteHighISONoise  = 0xb1
+	notePowerUpTime   = 0xb6
+	noteFileInfo      = 0xb8
+)
+
+var isoExpansions = map[uint16]string{
+	0x000: "off",
+	0x101: "hi 0.3",
+	0x102: "hi 0.5",
+	0x103: "hi 0.7",
+	0x104: "hi 1.0",
+	0x105: "hi 1.3",
+	0x106: "hi 1.5",
+	0x107: "hi 1.7",
+	0x108: "hi 2.0",
+	0x109: "hi 2.3",
+	0x10a: "hi 2.5",
+	0x10b: "hi 2.7",
+	0x10c: "hi 3.0",
+	0x10d: "hi 3.3",
+	0x10e: "hi 3.5",
+	0x10f: "hi 3.7",
+	0x110: "hi 4.0",
+	0x111: "hi 4.3",
+	0x112: "hi 4.5",
+	0x113: "hi 4.7",
+	0x114: "hi 5.0",
+	0x201: "lo 0.3",
+	0x202: "lo 0.5",
+	0x203: "lo 0.7",
+	0x204: "lo 1.0",
+}
+
+type ISOInfo struct {
+	ISO        int
+	Expansion  uint16
+	ISO2       int
+	Expansion2 uint16
+}
+
+func (i ISOInfo) String() string {
+	return fmt.Sprintf("ISO %d (expansion: %s), ISO2 %d (expansion: %s)", i.ISO, isoExpansion(i.Expansion), i.ISO2, isoExpansion(i.Expansion2))
+}
+
+func (f File) ISOInfo() (ISOInfo, error) {
+	t, err := f.GetTag(noteISOInfo, Note)
+	if err != nil {
+		return ISOInfo{}, err
+	}
+	return DecodeISOInfo(t)
+}
+
+func DecodeISOInfo(t Tag) (ISOInfo, error) {
+	var i ISOInfo
+	if t.Id != noteISOInfo || len(t.Raw) < 12 {
+		return i, fmt.Errorf("iso info: %w", ErrFormat)
+	}
+	order := t.ByteOrder()
+	i.ISO = isoValue(t.Raw[0])
+	i.Expansion = order.Uint16(t.Raw[4:])
+	i.ISO2 = isoValue(t.Raw[6])
+	i.Expansion2 = order.Uint16(t.Raw[10:])
+	return i, nil
+}
+
+const (
+	VRNone uint8 = iota
+	VROn
+	VROff
+)
+
+type VRInfo struct {
+	Version string
+	VR      uint8
+	Mode    uint8
+}
+
+func (v VRInfo) String() string {
+	var vr, mode string
+	switch v.VR {
+	case VRNone:
+		vr = "n/a"
+	case VROn:
+		vr = "on"
+	case VROff:
+		vr = "off"
+	default:
+		vr = fmt.Sprintf("other (%d)", v.VR)
+	}
+	switch v.Mode {
+	case 0:
+		mode = "normal"
+	case 1:
+		mode = "on (1)"
+	case 2:
+		mode = "active"
+	case 3:
+		mode = "sport"
+	default:
+		mode = fmt.Sprintf("other (%d)", v.Mode)
+	}
+	return fmt.Sprintf("%s (vibration reduction: %s, mode: %s)", v.Version, vr, mode)
+}
+
+func (f File) VRInfo() (VRInfo, error) {
+	t, err := f.GetTag(noteVRInfo, Note)
+	if err != nil {
+		return VRInfo{}, err
+	}
+	return DecodeVRInfo(t)
+}
+
+func DecodeVRInfo(t Tag) (VRInfo, error) {
+	var v VRInfo
+	if t.Id != noteVRInfo || len(t.Raw) < 7 {
+		return v, fmt.Errorf("vr info: %w", ErrFormat)
+	}
+	v.Version = string(t.Raw[:4])
+	v.VR = t.Raw[4]
+	v.Mode = t.Raw[6]
+	return v, nil
+}
+
+type FileInfo struct {
+	Version   string
+	Card      int
+	Directory int
+	Number    int
+}
+
+func (i FileInfo) String() string {
+	return fmt.Sprintf("%s (card: %d, directory: %03d, file: %04d)", i.Version, i.Card, i.Directory, i.Number)
+}
+
+func (f File) FileInfo() (FileInfo, error) {
+	t, err := f.GetTag(noteFileInfo, Note)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return DecodeFileInfo(t)
+}
+
+func DecodeFileInfo(t Tag) (FileInfo, error) {
+	var i FileInfo
+	if t.Id != noteFileInfo || len(t.Raw) < 10 {
+		return i, fmt.Errorf("file info: %w", ErrFormat)
+	}
+	order := t.ByteOrder()
+	i.Version = string(t.Raw[:4])
+	i.Card = int(order.Uint16(t.Raw[4:]))
+	i.Directory = int(order.Uint16(t.Raw[6:]))
+	i.Number = int(order.Uint16(t.Raw[8:]))
+	return i, nil
+}
+
+const (
+	MultiOff uint32 = iota
+	MultiExposures
+	MultiOverlay
+	MultiHDR
+)
+
+type MultiExposure struct {
+	Version  string
+	Mode     uint32
+	Shots    int
+	AutoGain bool
+}
+
+func (m MultiExposure) String() string {
+	var mode string
+	switch m.Mode {
+	case MultiOff:
+		mode = "off"
+	case MultiExposures:
+		mode = "multiple exposure"
+	case MultiOverlay:
+		mode = "image overlay"
+	case MultiHDR:
+		mode = "hdr"
+	default:
+		mode = fmt.Sprintf("other (%d)", m.Mode)
+	}
+	return fmt.Sprintf("%s (mode: %s, shots: %d, auto gain: %t)", m.Version, mode, m.Shots, m.AutoGain)
+}
+
+func (f File) MultiExposure() (MultiExposure, error) {
+	t, err := f.GetTag(noteMultiExposure, Note)
+	if err != nil {
+		return MultiExposure{}, err
+	}
+	return DecodeMultiExposure(t)
+}
+
+func DecodeMultiExposure(t Tag) (MultiExposure, error) {
+	var m MultiExposure
+	if t.Id != noteMultiExposure || len(t.Raw) < 16 {
+		return m, fmt.Errorf("multi exposure: %w", ErrFormat)
+	}
+	order := t.ByteOrder()
+	m.Version = string(t.Raw[:4])
+	m.Mode = order.Uint32(t.Raw[4:])
+	m.Shots = int(order.Uint32(t.Raw[8:]))
+	m.AutoGain = order.Uint32(t.Raw[12:]) != 0
+	return m, nil
+}
+
+var noiseReductions = []string{
+	"off",
+	"minimal",
+	"low",
+	"medium low",
+	"normal",
+	"medium high",
+	"high",
+}
+
+type NoiseReduction uint16
+
+func (n NoiseReduction) String() string {
+	if int(n) < len(noiseReductions) {
+		return noiseReductions[n]
+	}
+	return fmt.Sprintf("other (%d)", n)
+}
+
+func (f File) HighISONoiseReduction() (NoiseReduction, error) {
+	t, err := f.GetTag(noteHighISONoise, Note)
+	if err != nil {
+		return 0, err
+	}
+	return DecodeHighISONoiseReduction(t)
+}
+
+func DecodeHighISONoiseReduction(t Tag) (NoiseReduction, error) {
+	if t.Id != noteHighISONoise || t.Type != Short {
+		return 0, fmt.Errorf("high iso noise reduction: %w", ErrFormat)
+	}
+	return NoiseReduction(t.Uint()), nil
+}
+
+func (f File) PowerUpTime() (time.Time, error) {
+	t, err := f.GetTag(notePowerUpTime, Note)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return DecodePowerUpTime(t)
+}
+
+// DecodePowerUpTime decodes the time the camera was switched on. The year is
+// always recorded in big endian whatever the byte order of the file.
+func DecodePowerUpTime(t Tag) (time.Time, error) {
+	if t.Id != notePowerUpTime || len(t.Raw) < 7 {
+		return time.Time{}, fmt.Errorf("power up time: %w", ErrFormat)
+	}
+	var (
+		year  = int(binary.BigEndian.Uint16(t.Raw))
+		month = time.Month(t.Raw[2])
+		when  = time.Date(year, month, int(t.Raw[3]), int(t.Raw[4]), int(t.Raw[5]), int(t.Raw[6]), 0, time.UTC)
+	)
+	return when, nil
+}
+
+func isoValue(b byte) int {
+	if b == 0 {
+		return 0
+	}
+	iso := 100 * math.Pow(2, float64(b)/12-5)
+	return int(math.Round(iso))
+}
+
+func isoExpansion(x uint16) string {
+	str, ok := isoExpansions[x]
+	if !ok {
+		str = fmt.Sprintf("other (0x%04x)", x)
+	}
+	return str
+}
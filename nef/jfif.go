@@ -0,0 +1,209 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+const (
+	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP13 = 0xed
+	markerCOM   = 0xfe
+	markerTEM   = 0x01
+)
+
+var (
+	exifHeader      = []byte("Exif\x00\x00")
+	xmpHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader       = []byte("ICC_PROFILE\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+)
+
+// Segment is a marker segment found before the image data of a JPEG file.
+// Data is the content of the segment without its marker and its length.
+type Segment struct {
+	Marker byte
+	Offset int64
+	Data   []byte
+}
+
+// JPEG holds the metadata of a JPEG file: the directories of its Exif
+// segment, its XMP packet, its ICC profile (put back together when split in
+// several segments), its Photoshop resources and its comments. A damaged Exif
+// segment does not stop the decoding: its error is kept in ExifErr.
+type JPEG struct {
+	Width  int
+	Height int
+
+	Files     []*File
+	ExifErr   error
+	XMP       []byte
+	ICC       []byte
+	Photoshop []byte
+	Comments  []string
+	Segments  []Segment
+}
+
+func IsJPEG(buf []byte) bool {
+	return len(buf) >= 3 && buf[0] == 0xff && buf[1] == markerSOI && buf[2] == 0xff
+}
+
+func DecodeJPEGFile(file string) (*JPEG, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return DecodeJPEG(r)
+}
+
+func DecodeJPEG(r io.Reader) (*JPEG, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJPEG(buf)
+}
+
+// decodeJPEG walks the segments of a JPEG file until the start of its image
+// data.
+func decodeJPEG(buf []byte) (*JPEG, error) {
+	if !IsJPEG(buf) {
+		return nil, fmt.Errorf("jpeg: missing SOI: %w", ErrFormat)
+	}
+	var (
+		j   JPEG
+		icc = make(map[byte][]byte)
+		pos = 2
+	)
+	for pos < len(buf) {
+		if buf[pos] != 0xff {
+			return nil, fmt.Errorf("jpeg: invalid marker at %d: %w", pos, ErrFormat)
+		}
+		for pos < len(buf) && buf[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(buf) {
+			break
+		}
+		marker := buf[pos]
+		pos++
+		if marker == markerEOI || marker == markerSOS {
+			break
+		}
+		if marker == markerTEM || (marker >= markerRST0 && marker <= markerRST7) {
+			continue
+		}
+		if pos+2 > len(buf) {
+			return nil, fmt.Errorf("jpeg: %w", errTruncated)
+		}
+		size := int(binary.BigEndian.Uint16(buf[pos:]))
+		if size < 2 || pos+size > len(buf) {
+			return nil, fmt.Errorf("jpeg: segment %02x: %w", marker, errTruncated)
+		}
+		seg := Segment{
+			Marker: marker,
+			Offset: int64(pos - 2),
+			Data:   buf[pos+2 : pos+size],
+		}
+		pos += size
+		j.Segments = append(j.Segments, seg)
+		if err := j.readSegment(seg, icc); err != nil {
+			return nil, err
+		}
+	}
+	if len(icc) > 0 {
+		seqs := make([]int, 0, len(icc))
+		for s := range icc {
+			seqs = append(seqs, int(s))
+		}
+		sort.Ints(seqs)
+		for _, s := range seqs {
+			j.ICC = append(j.ICC, icc[byte(s)]...)
+		}
+	}
+	return &j, nil
+}
+
+func (j *JPEG) readSegment(seg Segment, icc map[byte][]byte) error {
+	data := seg.Data
+	switch m := seg.Marker; {
+	case m == markerAPP1 && bytes.HasPrefix(data, exifHeader) && j.Files == nil:
+		files, err := decodeTiff(data[len(exifHeader):])
+		if err != nil {
+			j.ExifErr = fmt.Errorf("jpeg: exif: %w", err)
+			break
+		}
+		j.Files, j.ExifErr = files, nil
+	case m == markerAPP1 && bytes.HasPrefix(data, xmpHeader):
+		j.XMP = data[len(xmpHeader):]
+	case m == markerAPP2 && bytes.HasPrefix(data, iccHeader) && len(data) >= len(iccHeader)+2:
+		icc[data[len(iccHeader)]] = data[len(iccHeader)+2:]
+	case m == markerAPP13 && bytes.HasPrefix(data, photoshopHeader):
+		j.Photoshop = data[len(photoshopHeader):]
+	case m == markerCOM:
+		j.Comments = append(j.Comments, string(bytes.TrimRight(data, "\x00")))
+	case m >= markerSOF0 && m <= 0xcf && m != markerDHT && m != 0xc8 && m != 0xcc:
+		if len(data) < 5 {
+			return fmt.Errorf("jpeg: frame: %w", errTruncated)
+		}
+		j.Height = int(binary.BigEndian.Uint16(data[1:]))
+		j.Width = int(binary.BigEndian.Uint16(data[3:]))
+	}
+	return nil
+}
+
+// EncodeExif gives the content of an APP1 segment holding the directories
+// given as a little TIFF file: the first one with its Exif and GPS
+// directories, the second one being usually the thumbnail of the image.
+func EncodeExif(files []*File) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, files); err != nil {
+		return nil, err
+	}
+	if buf.Len()+len(exifHeader)+2 > 0xffff {
+		return nil, fmt.Errorf("exif: %d bytes: %w", buf.Len(), ErrLarge)
+	}
+	return append(append([]byte{}, exifHeader...), buf.Bytes()...), nil
+}
+
+// EmbedExif gives a copy of a JPEG file with the directories given in its
+// Exif segment. The segment replaces the existing one or is inserted after
+// the JFIF segment.
+func EmbedExif(buf []byte, files []*File) ([]byte, error) {
+	j, err := decodeJPEG(buf)
+	if err != nil {
+		return nil, err
+	}
+	exif, err := EncodeExif(files)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		out = make([]byte, 0, len(buf)+len(exif)+4)
+		pos = 2
+	)
+	out = append(out, buf[:2]...)
+	for _, s := range j.Segments {
+		end := int(s.Offset) + len(s.Data) + 4
+		switch {
+		case s.Marker == markerAPP0 && int(s.Offset) == pos:
+			out = append(out, buf[pos:end]...)
+		case s.Marker == markerAPP1 && bytes.HasPrefix(s.Data, exifHeader):
+			out = append(out, buf[pos:s.Offset]...)
+		default:
+			continue
+		}
+		pos = end
+	}
+	out = append(out, 0xff, markerAPP1, byte((len(exif)+2)>>8), byte(len(exif)+2))
+	out = append(out, exif...)
+	return append(out, buf[pos:]...), nil
+}
@@ -0,0 +1,134 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// jpegFile gives a JPEG file made of the given segments followed by a scan.
+func jpegFile(segments ...Segment) []byte {
+	buf := []byte{0xff, markerSOI}
+	for _, s := range segments {
+		size := len(s.Data) + 2
+		buf = append(buf, 0xff, s.Marker, byte(size>>8), byte(size))
+		buf = append(buf, s.Data...)
+	}
+	return append(buf, jpegScan...)
+}
+
+var jpegScan = []byte{0xff, markerSOS, 0x00, 0x02, 0x12, 0x34, 0xff, markerEOI}
+
+func TestDecodeJPEGDamagedExif(t *testing.T) {
+	var (
+		xmp  = append(append([]byte{}, xmpHeader...), embedXMP...)
+		exif = append(append([]byte{}, exifHeader...), "XX\x00\x2a"...)
+		file = jpegFile(
+			Segment{Marker: markerAPP1, Data: exif},
+			Segment{Marker: markerAPP1, Data: xmp},
+			Segment{Marker: markerCOM, Data: []byte("comment")},
+		)
+	)
+	j, err := DecodeJPEG(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if j.ExifErr == nil {
+		t.Errorf("damaged exif should be reported")
+	}
+	if !bytes.Equal(j.XMP, embedXMP) {
+		t.Errorf("xmp mismatched: want %q, got %q", embedXMP, j.XMP)
+	}
+	if len(j.Comments) != 1 || j.Comments[0] != "comment" {
+		t.Errorf("comments mismatched: %q", j.Comments)
+	}
+	if _, err := Decode(bytes.NewReader(file)); err == nil || errors.Is(err, ErrExist) {
+		t.Errorf("decoding damaged exif should fail with its error, got %v", err)
+	}
+}
+
+// withoutExif gives a JPEG file without its Exif segments.
+func withoutExif(t *testing.T, buf []byte) []byte {
+	t.Helper()
+	j, err := DecodeJPEG(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var (
+		out []byte
+		pos int
+	)
+	for _, s := range j.Segments {
+		if s.Marker == markerAPP1 && bytes.HasPrefix(s.Data, exifHeader) {
+			out = append(out, buf[pos:s.Offset]...)
+			pos = int(s.Offset) + len(s.Data) + 4
+		}
+	}
+	return append(out, buf[pos:]...)
+}
+
+func TestEmbedExif(t *testing.T) {
+	var (
+		order = binary.LittleEndian
+		old   bytes.Buffer
+	)
+	if err := Encode(&old, []*File{NewFile(order, nil, NewString(tiffMake, "OLD"))}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var (
+		jfif = Segment{Marker: markerAPP0, Data: []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")}
+		dqt  = Segment{Marker: 0xdb, Data: make([]byte, 65)}
+		exif = Segment{Marker: markerAPP1, Data: append(append([]byte{}, exifHeader...), old.Bytes()...)}
+	)
+	data := []struct {
+		Name  string
+		File  []byte
+		Index int
+	}{
+		{Name: "jfif", File: jpegFile(jfif, dqt), Index: 1},
+		{Name: "exif", File: jpegFile(jfif, exif, dqt), Index: 1},
+		{Name: "bare", File: jpegFile(dqt), Index: 0},
+	}
+	thumb := []byte{0xff, 0xd8, 0xff, 0xd9}
+	for _, d := range data {
+		files := append(embedFiles(), NewFile(order, thumb,
+			NewTag(JpegFromRawStart, Long, order, 0),
+			NewTag(JpegFromRawLength, Long, order, uint64(len(thumb))),
+		))
+		buf, err := EmbedExif(d.File, files)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		j, err := DecodeJPEG(bytes.NewReader(buf))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		var count int
+		for i, s := range j.Segments {
+			if s.Marker != markerAPP1 || !bytes.HasPrefix(s.Data, exifHeader) {
+				continue
+			}
+			count++
+			if i != d.Index {
+				t.Errorf("%s: exif segment at %d, want %d", d.Name, i, d.Index)
+			}
+		}
+		if count != 1 {
+			t.Errorf("%s: want 1 exif segment, got %d", d.Name, count)
+		}
+		if len(j.Files) != 2 {
+			t.Errorf("%s: want 2 directories, got %d", d.Name, len(j.Files))
+			continue
+		}
+		checkFiles(t, d.Name, j.Files[:1])
+		if got, err := j.Files[1].Bytes(); err != nil || !bytes.Equal(got, thumb) {
+			t.Errorf("%s: thumbnail mismatched: want %x, got %x (%v)", d.Name, thumb, got, err)
+		}
+		if !bytes.Equal(withoutExif(t, buf), withoutExif(t, d.File)) {
+			t.Errorf("%s: segments and image data should be left unchanged", d.Name)
+		}
+	}
+}
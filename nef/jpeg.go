@@ -0,0 +1,660 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+const (
+	JPEGTables      = 0x15b
+	CompressionJPEG = 7
+)
+
+const (
+	markerSOF0 = 0xc0
+	markerSOF3 = 0xc3
+	markerDHT  = 0xc4
+	markerRST0 = 0xd0
+	markerRST7 = 0xd7
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerDRI  = 0xdd
+)
+
+// decompressJPEG decodes a strip or a tile compressed with JPEG. Lossless
+// streams, as found in DNG, give their samples packed as in an uncompressed
+// strip, the others are decoded as 8 bits gray or RGB samples.
+func (f File) decompressJPEG(buf []byte) ([]byte, error) {
+	var (
+		tables []byte
+		j      ljpeg
+	)
+	if t, err := f.get(JPEGTables); err == nil && t.Size() > 4 {
+		tables = t.Raw
+		if _, err := j.parse(tables); err != nil {
+			return nil, fmt.Errorf("jpeg tables: %w", err)
+		}
+	}
+	data, err := j.parse(buf)
+	if err != nil {
+		return nil, err
+	}
+	if j.frame != markerSOF3 {
+		return decodeBaseline(tables, buf)
+	}
+	list, err := j.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		width, _ = f.get(ImageWidth)
+		count    = int(width.Uint()) * f.samplesPerPixel()
+	)
+	return packSamples(list, f.bitsPerSample(), count, f.order), nil
+}
+
+func decodeBaseline(tables, buf []byte) ([]byte, error) {
+	if len(tables) > 4 && len(buf) > 2 {
+		stream := append([]byte{}, bytes.TrimSuffix(tables, []byte{0xff, markerEOI})...)
+		buf = append(stream, buf[2:]...)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	var (
+		rect = img.Bounds()
+		out  []byte
+	)
+	if g, ok := img.(*image.Gray); ok {
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			i := g.PixOffset(rect.Min.X, y)
+			out = append(out, g.Pix[i:i+rect.Dx()]...)
+		}
+		return out, nil
+	}
+	out = make([]byte, 0, rect.Dx()*rect.Dy()*3)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			out = append(out, byte(r>>8), byte(g>>8), byte(b>>8))
+		}
+	}
+	return out, nil
+}
+
+// packSamples packs samples of the given number of bits as in an uncompressed
+// strip, each row of count samples starting on a new byte.
+func packSamples(list []uint16, bits, count int, order binary.ByteOrder) []byte {
+	switch bits {
+	case 8:
+		out := make([]byte, len(list))
+		for i, v := range list {
+			out[i] = byte(v)
+		}
+		return out
+	case 16:
+		out := make([]byte, len(list)*2)
+		for i, v := range list {
+			order.PutUint16(out[i*2:], v)
+		}
+		return out
+	}
+	var (
+		out  = make([]byte, 0, (len(list)*bits+7)/8)
+		acc  uint32
+		size int
+	)
+	for i, v := range list {
+		acc = acc<<bits | uint32(v)&(1<<bits-1)
+		size += bits
+		for size >= 8 {
+			out = append(out, byte(acc>>(size-8)))
+			size -= 8
+		}
+		if size > 0 && (i == len(list)-1 || (count > 0 && (i+1)%count == 0)) {
+			out = append(out, byte(acc<<(8-size)))
+			size = 0
+		}
+	}
+	return out
+}
+
+// ljpeg decodes lossless JPEG (SOF3) streams. Only components without
+// subsampling are supported.
+type ljpeg struct {
+	frame     byte
+	bits      int
+	width     int
+	height    int
+	comps     []byte
+	tables    [4]*ljpegTable
+	selectors []int
+	predictor int
+	transform int
+	restart   int
+}
+
+// parse reads the markers of a stream until the start of the scan, giving the
+// data that follow it. A stream without scan, like the one of JPEGTables,
+// gives no data.
+func (j *ljpeg) parse(buf []byte) ([]byte, error) {
+	if len(buf) < 2 || buf[0] != 0xff || buf[1] != markerSOI {
+		return nil, fmt.Errorf("jpeg: missing SOI: %w", ErrFormat)
+	}
+	buf = buf[2:]
+	for {
+		for len(buf) > 1 && buf[0] == 0xff && buf[1] == 0xff {
+			buf = buf[1:]
+		}
+		if len(buf) < 2 {
+			return nil, fmt.Errorf("jpeg: %w", errTruncated)
+		}
+		if buf[0] != 0xff {
+			return nil, fmt.Errorf("jpeg: invalid marker: %w", ErrFormat)
+		}
+		marker := buf[1]
+		buf = buf[2:]
+		if marker == markerEOI {
+			return nil, nil
+		}
+		if len(buf) < 2 {
+			return nil, fmt.Errorf("jpeg: %w", errTruncated)
+		}
+		size := int(binary.BigEndian.Uint16(buf))
+		if size < 2 || size > len(buf) {
+			return nil, fmt.Errorf("jpeg: segment size: %w", errTruncated)
+		}
+		var (
+			seg = buf[2:size]
+			err error
+		)
+		buf = buf[size:]
+		switch {
+		case marker == markerDHT:
+			err = j.readTables(seg)
+		case marker == markerSOF3:
+			j.frame = marker
+			err = j.readFrame(seg)
+		case marker >= markerSOF0 && marker <= 0xcf && marker != markerDHT && marker != 0xc8 && marker != 0xcc:
+			j.frame = marker
+		case marker == markerDRI:
+			if len(seg) >= 2 {
+				j.restart = int(binary.BigEndian.Uint16(seg))
+			}
+		case marker == markerSOS:
+			return buf, j.readScan(seg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (j *ljpeg) readTables(seg []byte) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return fmt.Errorf("jpeg: huffman table: %w", errTruncated)
+		}
+		var (
+			id     = seg[0] & 0x0f
+			counts = seg[1:17]
+			total  int
+		)
+		if id >= 4 {
+			return fmt.Errorf("jpeg: huffman table %d: %w", id, ErrFormat)
+		}
+		for _, c := range counts {
+			total += int(c)
+		}
+		if len(seg) < 17+total {
+			return fmt.Errorf("jpeg: huffman table: %w", errTruncated)
+		}
+		j.tables[id] = newLJPEGTable(counts, seg[17:17+total])
+		seg = seg[17+total:]
+	}
+	return nil
+}
+
+func (j *ljpeg) readFrame(seg []byte) error {
+	if len(seg) < 6 {
+		return fmt.Errorf("jpeg: frame: %w", errTruncated)
+	}
+	j.bits = int(seg[0])
+	j.height = int(binary.BigEndian.Uint16(seg[1:]))
+	j.width = int(binary.BigEndian.Uint16(seg[3:]))
+	n := int(seg[5])
+	if n == 0 || len(seg) < 6+n*3 {
+		return fmt.Errorf("jpeg: frame: %w", errTruncated)
+	}
+	j.comps = j.comps[:0]
+	for i := 0; i < n; i++ {
+		c := seg[6+i*3:]
+		if c[1] != 0x11 {
+			return fmt.Errorf("jpeg: subsampled component: %w", ErrFormat)
+		}
+		j.comps = append(j.comps, c[0])
+	}
+	return nil
+}
+
+func (j *ljpeg) readScan(seg []byte) error {
+	if len(seg) < 1 {
+		return fmt.Errorf("jpeg: scan: %w", errTruncated)
+	}
+	n := int(seg[0])
+	if len(seg) < 1+n*2+3 {
+		return fmt.Errorf("jpeg: scan: %w", errTruncated)
+	}
+	j.selectors = j.selectors[:0]
+	for i := 0; i < n; i++ {
+		j.selectors = append(j.selectors, int(seg[2+i*2]>>4))
+	}
+	j.predictor = int(seg[1+n*2])
+	j.transform = int(seg[3+n*2] & 0x0f)
+	return nil
+}
+
+func (j *ljpeg) decode(data []byte) ([]uint16, error) {
+	var (
+		comps = len(j.comps)
+		size  = j.width * j.height
+	)
+	if comps == 0 || size == 0 || len(j.selectors) != comps {
+		return nil, fmt.Errorf("jpeg: missing frame or scan: %w", ErrFormat)
+	}
+	if j.predictor < 1 || j.predictor > 7 || j.bits-j.transform < 1 {
+		return nil, fmt.Errorf("jpeg: predictor %d: %w", j.predictor, ErrFormat)
+	}
+	tables := make([]*ljpegTable, comps)
+	for i, s := range j.selectors {
+		if s >= len(j.tables) || j.tables[s] == nil {
+			return nil, fmt.Errorf("jpeg: missing huffman table %d: %w", s, ErrFormat)
+		}
+		tables[i] = j.tables[s]
+	}
+	var (
+		out      = make([]uint16, size*comps)
+		segs     = scanSegments(data)
+		interval = j.restart
+		initial  = 1 << (j.bits - j.transform - 1)
+		line     = j.width * comps
+		br       *bitReader
+		start    int
+	)
+	if interval == 0 {
+		interval = size
+	}
+	for p := 0; p < size; p++ {
+		if p%interval == 0 {
+			if len(segs) == 0 {
+				return nil, fmt.Errorf("jpeg: %w", errTruncated)
+			}
+			br, segs, start = newBitReader(segs[0]), segs[1:], p
+		}
+		x, y := p%j.width, p/j.width
+		for c := 0; c < comps; c++ {
+			diff, err := tables[c].decode(br)
+			if err != nil {
+				return nil, err
+			}
+			var (
+				at   = p*comps + c
+				pred int
+			)
+			switch {
+			case p == start:
+				pred = initial
+			case y == start/j.width:
+				pred = int(out[at-comps])
+			case x == 0:
+				pred = int(out[at-line])
+			default:
+				pred = predict(j.predictor, int(out[at-comps]), int(out[at-line]), int(out[at-line-comps]))
+			}
+			out[at] = uint16(pred + diff)
+		}
+		if br.overflow() {
+			return nil, fmt.Errorf("jpeg: %w", errTruncated)
+		}
+	}
+	if j.transform > 0 {
+		for i := range out {
+			out[i] <<= uint(j.transform)
+		}
+	}
+	return out, nil
+}
+
+func predict(predictor, a, b, c int) int {
+	switch predictor {
+	case 1:
+		return a
+	case 2:
+		return b
+	case 3:
+		return c
+	case 4:
+		return a + b - c
+	case 5:
+		return a + (b-c)>>1
+	case 6:
+		return b + (a-c)>>1
+	default:
+		return (a + b) >> 1
+	}
+}
+
+// scanSegments removes the stuffed bytes of the entropy coded data and splits
+// it on restart markers.
+func scanSegments(data []byte) [][]byte {
+	var (
+		list [][]byte
+		seg  = make([]byte, 0, len(data))
+	)
+	for i := 0; i < len(data); i++ {
+		if data[i] != 0xff {
+			seg = append(seg, data[i])
+			continue
+		}
+		if i+1 >= len(data) {
+			break
+		}
+		switch next := data[i+1]; {
+		case next == 0:
+			seg = append(seg, 0xff)
+			i++
+		case next == 0xff:
+		case next >= markerRST0 && next <= markerRST7:
+			list = append(list, seg)
+			seg = nil
+			i++
+		default:
+			return append(list, seg)
+		}
+	}
+	return append(list, seg)
+}
+
+type ljpegTable struct {
+	mincode [17]int32
+	maxcode [17]int32
+	valptr  [17]int32
+	values  []byte
+}
+
+func newLJPEGTable(counts, values []byte) *ljpegTable {
+	var (
+		t    = ljpegTable{values: append([]byte{}, values...)}
+		code int32
+		k    int32
+	)
+	for i := 1; i <= 16; i++ {
+		n := int32(counts[i-1])
+		t.valptr[i] = k
+		t.mincode[i] = code
+		t.maxcode[i] = -1
+		if n > 0 {
+			t.maxcode[i] = code + n - 1
+		}
+		code = (code + n) << 1
+		k += n
+	}
+	return &t
+}
+
+// decode gives the next difference: its size is huffman coded and followed by
+// its bits.
+func (t *ljpegTable) decode(br *bitReader) (int, error) {
+	code := int32(br.bits(1))
+	for i := 1; i <= 16; i++ {
+		if code <= t.maxcode[i] {
+			x := t.valptr[i] + code - t.mincode[i]
+			if int(x) >= len(t.values) {
+				break
+			}
+			return extend(br, int(t.values[x])), nil
+		}
+		code = code<<1 | int32(br.bits(1))
+	}
+	return 0, fmt.Errorf("jpeg: invalid huffman code: %w", ErrFormat)
+}
+
+func extend(br *bitReader, size int) int {
+	switch size {
+	case 0:
+		return 0
+	case 16:
+		return 32768
+	}
+	v := int(br.bits(uint(size)))
+	if v < 1<<(size-1) {
+		v -= 1<<size - 1
+	}
+	return v
+}
+
+// EncodeLossless compresses samples with lossless JPEG, using the first
+// predictor and a huffman table computed from the samples. Samples of the
+// comps components are interleaved, giving width*comps samples per row.
+func EncodeLossless(samples []uint16, width, height, comps, bits int) ([]byte, error) {
+	if width <= 0 || height <= 0 || width > 0xffff || height > 0xffff || comps <= 0 || comps > 4 {
+		return nil, fmt.Errorf("jpeg: %dx%d image with %d components: %w", width, height, comps, ErrFormat)
+	}
+	if bits < 2 || bits > 16 {
+		return nil, fmt.Errorf("jpeg: %d bits per sample: %w", bits, ErrFormat)
+	}
+	if len(samples) < width*height*comps {
+		return nil, fmt.Errorf("jpeg: %w", errTruncated)
+	}
+	var (
+		line  = width * comps
+		diffs = make([]int, width*height*comps)
+		freqs [17]int
+	)
+	for i := range diffs {
+		var pred int
+		switch x, y := (i%line)/comps, i/line; {
+		case i < comps:
+			pred = 1 << (bits - 1)
+		case y == 0:
+			pred = int(samples[i-comps])
+		case x == 0:
+			pred = int(samples[i-line])
+		default:
+			pred = int(samples[i-comps])
+		}
+		diffs[i] = int(int16(samples[i] - uint16(pred)))
+		freqs[diffSize(diffs[i])]++
+	}
+	var (
+		counts, values = optimalTable(freqs[:])
+		codes          = huffmanCodes(counts, values)
+		out            bytes.Buffer
+	)
+	out.Write([]byte{0xff, markerSOI})
+
+	frame := []byte{byte(bits), byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(comps)}
+	for c := 0; c < comps; c++ {
+		frame = append(frame, byte(c+1), 0x11, 0)
+	}
+	writeSegment(&out, markerSOF3, frame)
+
+	table := append([]byte{0}, counts[:]...)
+	writeSegment(&out, markerDHT, append(table, values...))
+
+	scan := []byte{byte(comps)}
+	for c := 0; c < comps; c++ {
+		scan = append(scan, byte(c+1), 0)
+	}
+	writeSegment(&out, markerSOS, append(scan, 1, 0, 0))
+
+	bw := bitWriter{w: &out}
+	for _, d := range diffs {
+		size := diffSize(d)
+		code := codes[size]
+		bw.write(uint32(code.code), code.size)
+		if size > 0 && size < 16 {
+			if d < 0 {
+				d += 1<<size - 1
+			}
+			bw.write(uint32(d), size)
+		}
+	}
+	bw.flush()
+	out.Write([]byte{0xff, markerEOI})
+	return out.Bytes(), nil
+}
+
+func writeSegment(w *bytes.Buffer, marker byte, seg []byte) {
+	w.Write([]byte{0xff, marker, byte((len(seg) + 2) >> 8), byte(len(seg) + 2)})
+	w.Write(seg)
+}
+
+// diffSize gives the category of a difference: the number of bits of its
+// magnitude, 16 being used for -32768 (read as 32768).
+func diffSize(d int) int {
+	if d == -32768 {
+		return 16
+	}
+	if d < 0 {
+		d = -d
+	}
+	var n int
+	for ; d > 0; d >>= 1 {
+		n++
+	}
+	return n
+}
+
+type huffmanCode struct {
+	code uint16
+	size int
+}
+
+func huffmanCodes(counts [16]byte, values []byte) map[int]huffmanCode {
+	var (
+		codes = make(map[int]huffmanCode)
+		code  uint16
+		k     int
+	)
+	for i := 0; i < 16; i++ {
+		for j := 0; j < int(counts[i]); j++ {
+			codes[int(values[k])] = huffmanCode{code: code, size: i + 1}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// optimalTable gives the counts and values of an huffman table for the
+// frequencies of the symbols given, with codes of 16 bits at most and no code
+// made only of ones (see section K.2 of the JPEG specification).
+func optimalTable(freqs []int) ([16]byte, []byte) {
+	const maxSize = 32
+	var (
+		n      = len(freqs) + 1
+		freq   = make([]int, n)
+		size   = make([]int, n)
+		others = make([]int, n)
+		bits   [maxSize + 1]int
+		counts [16]byte
+		values []byte
+	)
+	copy(freq, freqs)
+	freq[n-1] = 1 // reserved symbol so that no code is made only of ones
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		c1, c2 := -1, -1
+		for i, f := range freq {
+			if f > 0 && (c1 < 0 || f <= freq[c1]) {
+				c1 = i
+			}
+		}
+		for i, f := range freq {
+			if f > 0 && i != c1 && (c2 < 0 || f <= freq[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		for size[c1]++; others[c1] >= 0; size[c1]++ {
+			c1 = others[c1]
+		}
+		others[c1] = c2
+		for size[c2]++; others[c2] >= 0; size[c2]++ {
+			c2 = others[c2]
+		}
+	}
+	for _, s := range size {
+		if s > 0 {
+			bits[s]++
+		}
+	}
+	for i := maxSize; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+	for i := 1; i <= 16; i++ {
+		counts[i-1] = byte(bits[i])
+	}
+	for s := 1; s <= maxSize; s++ {
+		for j := 0; j < n-1; j++ {
+			if size[j] == s {
+				values = append(values, byte(j))
+			}
+		}
+	}
+	return counts, values
+}
+
+// bitWriter writes bits from the most significant one, stuffing a zero byte
+// after each 0xff.
+type bitWriter struct {
+	w    *bytes.Buffer
+	acc  uint64
+	size int
+}
+
+func (b *bitWriter) write(v uint32, n int) {
+	b.acc = b.acc<<uint(n) | uint64(v)&(1<<uint(n)-1)
+	b.size += n
+	for b.size >= 8 {
+		c := byte(b.acc >> uint(b.size-8))
+		b.w.WriteByte(c)
+		if c == 0xff {
+			b.w.WriteByte(0)
+		}
+		b.size -= 8
+	}
+}
+
+func (b *bitWriter) flush() {
+	if b.size > 0 {
+		b.write(1<<uint(8-b.size)-1, 8-b.size)
+	}
+}
@@ -0,0 +1,144 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// tiledFile gives a directory of an image of the given size stored in tiles,
+// the data of the tiles following each other in the file.
+func tiledFile(width, height, tw, th int, compression uint64, tiles [][]byte) *File {
+	var (
+		order   = binary.LittleEndian
+		data    []byte
+		offsets []uint64
+		counts  []uint64
+	)
+	for _, t := range tiles {
+		offsets = append(offsets, uint64(len(data)))
+		counts = append(counts, uint64(len(t)))
+		data = append(data, t...)
+	}
+	return NewFile(order, data,
+		NewTag(ImageWidth, Short, order, uint64(width)),
+		NewTag(ImageLength, Short, order, uint64(height)),
+		NewTag(BitsPerSample, Short, order, 8),
+		NewTag(Compression, Short, order, compression),
+		NewTag(Photometric, Short, order, uint64(ImgBlackIsZero)),
+		NewTag(TileWidth, Short, order, uint64(tw)),
+		NewTag(TileLength, Short, order, uint64(th)),
+		NewTag(TileOffsets, Long, order, offsets...),
+		NewTag(TileByteCounts, Long, order, counts...),
+	)
+}
+
+func TestProcessTiles(t *testing.T) {
+	tiles := [][]byte{
+		{1, 2, 3, 4, 6, 7, 8, 9},
+		{5, 0, 0, 0, 10, 0, 0, 0},
+		{11, 12, 13, 14, 0, 0, 0, 0},
+		{15, 0, 0, 0, 0, 0, 0, 0},
+	}
+	f := tiledFile(5, 3, 4, 2, CompressionNone, tiles)
+	got, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	if !bytes.Equal(got, want) {
+		t.Errorf("samples mismatched: want %v, got %v", want, got)
+	}
+}
+
+func TestDecompressJPEGTiles(t *testing.T) {
+	const (
+		size  = 16
+		width = 24
+	)
+	var tiles [][]byte
+	for i := 0; i < 2; i++ {
+		img := image.NewGray(image.Rect(0, 0, size, size))
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				img.SetGray(x, y, color.Gray{Y: uint8((i*size + x) * 8)})
+			}
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		tiles = append(tiles, buf.Bytes())
+	}
+	f := tiledFile(width, size, size, size, CompressionJPEG, tiles)
+	got, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(got) != width*size {
+		t.Fatalf("samples mismatched: want %d samples, got %d", width*size, len(got))
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < width; x++ {
+			var (
+				want = x * 8
+				diff = int(got[y*width+x]) - want
+			)
+			if diff < -4 || diff > 4 {
+				t.Fatalf("sample at %dx%d mismatched: want %d, got %d", x, y, want, got[y*width+x])
+			}
+		}
+	}
+}
+
+func TestEncodeLossless(t *testing.T) {
+	data := []struct {
+		Name   string
+		Width  int
+		Height int
+		Comps  int
+		Bits   int
+	}{
+		{Name: "gray-8", Width: 7, Height: 5, Comps: 1, Bits: 8},
+		{Name: "gray-12", Width: 16, Height: 3, Comps: 1, Bits: 12},
+		{Name: "two-14", Width: 9, Height: 4, Comps: 2, Bits: 14},
+		{Name: "rgb-16", Width: 5, Height: 6, Comps: 3, Bits: 16},
+		{Name: "single", Width: 1, Height: 1, Comps: 1, Bits: 2},
+	}
+	for _, d := range data {
+		var (
+			mask    = 1<<d.Bits - 1
+			samples = make([]uint16, d.Width*d.Height*d.Comps)
+		)
+		for i := range samples {
+			samples[i] = uint16((i*i*37 + i*11) & mask)
+		}
+		buf, err := EncodeLossless(samples, d.Width, d.Height, d.Comps, d.Bits)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		var j ljpeg
+		scan, err := j.parse(buf)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		got, err := j.decode(scan)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if !equalSamples(got, samples) {
+			t.Errorf("%s: samples mismatched: want %x, got %x", d.Name, samples, got)
+		}
+	}
+	for _, bits := range []int{1, 17} {
+		if _, err := EncodeLossless([]uint16{0}, 1, 1, 1, bits); err == nil {
+			t.Errorf("%d bits per sample should fail", bits)
+		}
+	}
+}
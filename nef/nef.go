@@ -11,7 +11,7 @@ import (
 	_ "image/jpeg"
 	"io"
 	"io/ioutil"
-	"os"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -29,6 +29,9 @@ var (
 	big     = []byte{0x4d, 0x4d} // MM
 	magicbe = []byte{0x00, 0x2a}
 	magicle = []byte{0x2a, 0x00}
+	// BigTIFF: version 43, offsets on 8 bytes
+	bigbe = []byte{0x00, 0x2b, 0x00, 0x08, 0x00, 0x00}
+	bigle = []byte{0x2b, 0x00, 0x08, 0x00, 0x00, 0x00}
 )
 
 const (
@@ -43,6 +46,7 @@ const (
 	Xmp     = 0x2bc
 	Comment = 0x9286
 
+	NewSubfileType    = 0xfe
 	ImageWidth        = 0x100
 	ImageLength       = 0x101
 	JpegFromRawStart  = 0x0201
@@ -52,11 +56,13 @@ const (
 	StripByteCounts   = 0x117
 	Photometric       = 0x106
 	BitsPerSample     = 0x102
+	Compression       = 0x103
+	SamplesPerPixel   = 0x115
 )
 
 const (
-	ImgBlack uint32 = iota
-	ImgWhite
+	ImgWhiteIsZero uint32 = iota
+	ImgBlackIsZero
 	ImgRGB
 	ImgPalette
 	ImgMask
@@ -79,6 +85,10 @@ const (
 	SRational        = 0xa
 	Float            = 0xb
 	Double           = 0xc
+	Ifd              = 0xd
+	Long8            = 0x10
+	SLong8           = 0x11
+	Ifd8             = 0x12
 )
 
 var formats = map[Format]string{
@@ -94,6 +104,10 @@ var formats = map[Format]string{
 	SRational: "srational",
 	Float:     "float",
 	Double:    "double",
+	Ifd:       "ifd",
+	Long8:     "long8",
+	SLong8:    "slong8",
+	Ifd8:      "ifd8",
 }
 
 func (f Format) Size() int {
@@ -102,9 +116,9 @@ func (f Format) Size() int {
 		return 1
 	case Short, SShort:
 		return 2
-	case Long, SLong, Float:
+	case Long, SLong, Float, Ifd:
 		return 4
-	case Rational, SRational, Double:
+	case Rational, SRational, Double, Long8, SLong8, Ifd8:
 		return 8
 	default:
 		return 0
@@ -119,11 +133,15 @@ func (f Format) String() string {
 	return str
 }
 
+// maxCount is the largest number of values of a tag, whose values are indexed
+// by int.
+const maxCount = math.MaxInt32
+
 type Tag struct {
 	Id     uint16
 	Type   Format
-	Count  uint32
-	Offset uint32
+	Count  uint64
+	Offset uint64
 
 	Raw []byte
 	// Tags   []Tag
@@ -139,6 +157,13 @@ func (t Tag) Bytes() []byte {
 	return append([]byte{}, t.Raw...)
 }
 
+func (t Tag) ByteOrder() binary.ByteOrder {
+	if t.order == nil {
+		return binary.BigEndian
+	}
+	return t.order
+}
+
 func (t Tag) IsPtr() bool {
 	switch t.Id {
 	case Tiff, Exif, Nef, Note, Gps:
@@ -154,32 +179,87 @@ func (t Tag) Uint() uint32 {
 		return uint32(t.Raw[0])
 	case Short:
 		return uint32(t.order.Uint16(t.Raw))
-	case Long:
+	case Long, Ifd:
 		return t.order.Uint32(t.Raw)
+	case Long8, Ifd8:
+		return uint32(t.order.Uint64(t.Raw))
 	default:
 		return 0
 	}
 }
 
+func (t Tag) Uint64() uint64 {
+	switch t.Type {
+	case Long8, Ifd8:
+		return t.order.Uint64(t.Raw)
+	default:
+		return uint64(t.Uint())
+	}
+}
+
 func (t Tag) Int() int32 {
 	switch t.Type {
 	case SByte:
+		return int32(int8(t.Raw[0]))
 	case SShort:
+		return int32(int16(t.order.Uint16(t.Raw)))
 	case SLong:
+		return int32(t.order.Uint32(t.Raw))
 	default:
+		return 0
 	}
-	return 0
 }
 
 func (t Tag) Float() float64 {
-	switch t.Type {
-	case Float:
-	case Double:
-	case Rational:
-	case SRational:
-	default:
+	fs := t.Floats()
+	if len(fs) == 0 {
+		return 0
+	}
+	return fs[0]
+}
+
+func (t Tag) Floats() []float64 {
+	var (
+		fs []float64
+		rs = bytes.NewReader(t.Raw)
+	)
+	for i := 0; i < int(t.Count); i++ {
+		var f float64
+		switch t.Type {
+		case Float:
+			var v float32
+			if err := binary.Read(rs, t.order, &v); err != nil {
+				return fs
+			}
+			f = float64(v)
+		case Double:
+			if err := binary.Read(rs, t.order, &f); err != nil {
+				return fs
+			}
+		case Rational:
+			var n, d uint32
+			binary.Read(rs, t.order, &n)
+			if err := binary.Read(rs, t.order, &d); err != nil {
+				return fs
+			}
+			if d != 0 {
+				f = float64(n) / float64(d)
+			}
+		case SRational:
+			var n, d int32
+			binary.Read(rs, t.order, &n)
+			if err := binary.Read(rs, t.order, &d); err != nil {
+				return fs
+			}
+			if d != 0 {
+				f = float64(n) / float64(d)
+			}
+		default:
+			return fs
+		}
+		fs = append(fs, f)
 	}
-	return 0
+	return fs
 }
 
 func (t Tag) String() string {
@@ -216,10 +296,14 @@ func (t Tag) Values() ([]string, error) {
 	case String:
 		b := bytes.TrimRight(t.Raw, "\x00")
 		str = append(str, string(bytes.TrimSpace(b)))
-	case Long:
+	case Long, Ifd:
 		str = decodeLong(t)
 	case SLong:
 		str = decodeSignedLong(t)
+	case Long8, Ifd8:
+		str = decodeLong8(t)
+	case SLong8:
+		str = decodeSignedLong8(t)
 	case Short:
 		str = decodeShort(t)
 	case SShort:
@@ -259,14 +343,17 @@ func (t Tag) Origin() string {
 }
 
 type File struct {
-	reader *bytes.Reader
-	order  binary.ByteOrder
+	reader  io.ReaderAt
+	order   binary.ByteOrder
+	bigtiff bool
 
 	tiff  []Tag
 	exif  []Tag
 	notes []Tag
 	gps   []Tag
 
+	variant string
+
 	Index []int
 	Files []*File
 }
@@ -311,6 +398,8 @@ func (f File) GetTag(id uint16, origin int) (Tag, error) {
 		tags = f.exif
 	case Note:
 		tags = f.notes
+	case Gps:
+		tags = f.gps
 	}
 	x := sort.Search(len(tags), func(i int) bool { return tags[i].Id >= id })
 	if x >= len(tags) || tags[x].Id != id {
@@ -319,6 +408,14 @@ func (f File) GetTag(id uint16, origin int) (Tag, error) {
 	return tags[x], nil
 }
 
+func (f File) IsBigTiff() bool {
+	return f.bigtiff
+}
+
+func (f File) ByteOrder() binary.ByteOrder {
+	return f.order
+}
+
 func (f File) IsMainDir() bool {
 	return len(f.Index) == 1
 }
@@ -352,7 +449,7 @@ func (f File) Directory() string {
 
 func (f File) Image() (image.Image, error) {
 	switch {
-	case f.IsJpeg():
+	case f.IsJpeg(), f.isJpegStrip():
 		return f.decodeJpeg()
 	case f.IsRaw():
 		return f.decodeRaw()
@@ -362,6 +459,9 @@ func (f File) Image() (image.Image, error) {
 }
 
 func (f File) IsSupported() bool {
+	if f.IsRaw() && !f.canDecompress() && !f.IsJpeg() && !f.isJpegStrip() {
+		return false
+	}
 	typ, err := f.get(Photometric)
 	if err != nil {
 		if errors.Is(err, ErrExist) {
@@ -370,7 +470,7 @@ func (f File) IsSupported() bool {
 		return false
 	}
 	switch typ.Uint() {
-	case ImgBlack, ImgWhite, ImgRGB, ImgPalette, ImgMask, ImgCMYK, ImgYCbCr:
+	case ImgWhiteIsZero, ImgBlackIsZero, ImgRGB, ImgPalette, ImgMask, ImgCMYK, ImgYCbCr, ImgCFA:
 		return true
 	default:
 		return false
@@ -380,10 +480,13 @@ func (f File) IsSupported() bool {
 func (f File) ImageType() string {
 	typ, err := f.get(Photometric)
 	if errors.Is(err, ErrExist) {
+		if f.IsRaw() && !f.IsJpeg() && !f.isJpegStrip() {
+			return "raw/cfa"
+		}
 		return "jpeg"
 	}
 	switch typ := typ.Uint(); typ {
-	case ImgBlack, ImgWhite:
+	case ImgWhiteIsZero, ImgBlackIsZero:
 		return "raw/gray"
 	case ImgRGB:
 		return "raw/rgb"
@@ -395,6 +498,8 @@ func (f File) ImageType() string {
 		return "raw/cmyk"
 	case ImgYCbCr:
 		return "raw/ycbcr"
+	case ImgCFA:
+		return "raw/cfa"
 	default:
 		return "unsupported"
 	}
@@ -405,24 +510,56 @@ func (f File) decodeRaw() (image.Image, error) {
 		imgtype, _ = f.get(Photometric)
 		width, _   = f.get(ImageWidth)
 		height, _  = f.get(ImageLength)
-		rect       = image.Rect(0, 0, int(width.Offset), int(height.Offset))
-		img        image.Image
+		rect       = image.Rect(0, 0, int(width.Uint()), int(height.Uint()))
+		bits       = f.bitsPerSample()
+		typ        = imgtype.Uint()
 	)
+	if typ == ImgYCbCr && f.compression() == CompressionJPEG {
+		// the JPEG decoder gives RGB samples
+		typ = ImgRGB
+	}
+	switch typ {
+	case ImgCFA:
+		return f.decodeCFA()
+	case ImgPalette:
+		return f.decodePalette(rect)
+	case ImgMask:
+		return f.decodeMask(rect)
+	case ImgCMYK:
+		return f.decodeCMYK(rect)
+	case ImgYCbCr:
+		return f.decodeYCbCr(rect)
+	}
+	if bits != 8 {
+		return f.decodeRaw16(rect, typ, bits)
+	}
 	buf, err := f.Bytes()
 	if err != nil {
 		return nil, err
 	}
-	switch typ := imgtype.Uint(); typ {
+	switch typ {
 	default:
 		return nil, fmt.Errorf("%d: %w", typ, ErrFormat)
-	case ImgBlack, ImgWhite:
-		img = grayImage(rect, buf, typ == ImgWhite)
+	case ImgWhiteIsZero, ImgBlackIsZero:
+		return grayImage(rect, buf, typ == ImgWhiteIsZero), nil
 	case ImgRGB:
-		img = rgbImage(rect, buf)
-	case ImgCMYK:
-		img = image.NewCMYK(rect)
+		return rgbImage(rect, buf, f.samplesPerPixel(), f.checkChunky() != nil), nil
+	}
+}
+
+func (f File) decodeRaw16(rect image.Rectangle, typ uint32, bits int) (image.Image, error) {
+	list, err := f.unpack()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case ImgWhiteIsZero, ImgBlackIsZero:
+		return gray16Image(rect, list, bits, typ == ImgWhiteIsZero), nil
+	case ImgRGB:
+		return rgb64Image(rect, list, bits, f.samplesPerPixel(), f.checkChunky() != nil), nil
+	default:
+		return nil, fmt.Errorf("%d: %w", typ, ErrFormat)
 	}
-	return img, nil
 }
 
 func (f File) decodeJpeg() (image.Image, error) {
@@ -457,15 +594,15 @@ func (f File) IsJpeg() bool {
 }
 
 func (f File) IsRaw() bool {
-	return f.Has(StripOffsets) && f.Has(RowsPerStrip) && f.Has(StripByteCounts)
+	return (f.Has(StripOffsets) && f.Has(StripByteCounts)) || f.IsTiled()
 }
 
 func (f File) processJpeg() ([]byte, error) {
 	var (
 		start, _  = f.get(JpegFromRawStart)
 		length, _ = f.get(JpegFromRawLength)
-		img       = make([]byte, int(length.Offset))
-		rs        = io.NewSectionReader(f.reader, int64(start.Offset), int64(length.Offset))
+		img       = make([]byte, int(length.Uint()))
+		rs        = io.NewSectionReader(f.reader, int64(start.Uint64()), int64(length.Uint()))
 	)
 	if _, err := io.ReadFull(rs, img); err != nil {
 		return nil, err
@@ -474,36 +611,28 @@ func (f File) processJpeg() ([]byte, error) {
 }
 
 func (f File) processRaw() ([]byte, error) {
+	if f.IsTiled() {
+		return f.processTiles()
+	}
 	var (
-		strip, _  = f.get(RowsPerStrip)
 		offset, _ = f.get(StripOffsets)
 		count, _  = f.get(StripByteCounts)
-		length, _ = f.get(ImageLength)
-		block     uint32
+		offsets   = tagUint64s(offset)
+		counts    = tagUints(count)
 		img       []byte
 	)
-	block = length.Offset / strip.Offset
-	if mod := length.Offset % strip.Offset; mod != 0 {
-		block++
-	}
-	rsp := bytes.NewReader(offset.Raw)
-	rsc := bytes.NewReader(count.Raw)
-	for i := 0; i < int(block); i++ {
-		var (
-			pos uint32
-			num uint32
-		)
-		if err := binary.Read(rsp, offset.order, &pos); err != nil {
-			return nil, err
-		}
-		if err := binary.Read(rsc, count.order, &num); err != nil {
-			return nil, err
-		}
-		r := io.NewSectionReader(f.reader, int64(pos), int64(num))
+	if len(offsets) != len(counts) {
+		return nil, fmt.Errorf("%d strip offsets for %d byte counts: %w", len(offsets), len(counts), ErrFormat)
+	}
+	for i := range offsets {
+		r := io.NewSectionReader(f.reader, int64(offsets[i]), int64(counts[i]))
 		tmp, err := ioutil.ReadAll(r)
 		if err != nil {
 			return nil, err
 		}
+		if tmp, err = f.decompress(tmp); err != nil {
+			return nil, err
+		}
 		img = append(img, tmp...)
 	}
 	return img, nil
@@ -520,83 +649,172 @@ func (f File) get(id uint16) (Tag, error) {
 	return f.tiff[x], nil
 }
 
+// ReadDirectory reads the directory pointed by the tag given, like the
+// private directories some makers store in the main one.
+func (f File) ReadDirectory(id uint16) (*File, error) {
+	t, err := f.get(id)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := readTags(f.reader, f.order, t.Uint64(), 0, Tiff, f.bigtiff)
+	if err != nil {
+		return nil, err
+	}
+	c := File{
+		reader:  f.reader,
+		order:   f.order,
+		bigtiff: f.bigtiff,
+		tiff:    tags,
+		Index:   append([]int{}, f.Index...),
+	}
+	return &c, nil
+}
+
+// DecodeFile decodes the directories of a file. The file is read in memory
+// since it is closed once decoded.
 func DecodeFile(file string) ([]*File, error) {
-	r, err := os.Open(file)
+	buf, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
-	defer r.Close()
-	return Decode(r)
+	return DecodeReaderAt(bytes.NewReader(buf), int64(len(buf)))
 }
 
+// Decode decodes the directories of a TIFF file or of the Exif of a JPEG, PNG
+// or WebP file. When r is an io.ReaderAt, like an os.File, only the
+// directories are read and r must stay open as long as the image data of the
+// directories are used.
 func Decode(r io.Reader) ([]*File, error) {
+	if ra, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		size, err := ra.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		return DecodeReaderAt(ra, size)
+	}
 	buf, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	var (
-		rs     = bytes.NewReader(buf)
-		offset uint32
-	)
-	order, err := readOrder(rs)
+	return DecodeReaderAt(bytes.NewReader(buf), int64(len(buf)))
+}
+
+// DecodeReaderAt is like Decode for a content of the given size. The other
+// formats than TIFF are read in memory.
+func DecodeReaderAt(r io.ReaderAt, size int64) ([]*File, error) {
+	magic := make([]byte, 16)
+	n, err := r.ReadAt(magic, 0)
+	if n < len(magic) && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:n]
+	if !IsJPEG(magic) && !IsPNG(magic) && !IsWebP(magic) {
+		return readTiff(r, size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(r, 0, size), buf); err != nil {
+		return nil, err
+	}
+	if IsJPEG(buf) {
+		j, err := decodeJPEG(buf)
+		if err != nil {
+			return nil, err
+		}
+		if len(j.Files) == 0 && j.ExifErr != nil {
+			return nil, j.ExifErr
+		}
+		if len(j.Files) == 0 {
+			return nil, fmt.Errorf("jpeg: exif: %w", ErrExist)
+		}
+		return j.Files, nil
+	}
+	if IsPNG(buf) {
+		p, err := decodePNG(buf)
+		if err != nil {
+			return nil, err
+		}
+		if len(p.Files) == 0 {
+			return nil, fmt.Errorf("png: exif: %w", ErrExist)
+		}
+		return p.Files, nil
+	}
+	w, err := decodeWebP(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(w.Files) == 0 {
+		return nil, fmt.Errorf("webp: exif: %w", ErrExist)
+	}
+	return w.Files, nil
+}
+
+func decodeTiff(buf []byte) ([]*File, error) {
+	return readTiff(bytes.NewReader(buf), int64(len(buf)))
+}
+
+func readTiff(r io.ReaderAt, size int64) ([]*File, error) {
+	rs := io.NewSectionReader(r, 0, size)
+	order, bigtiff, err := readHeader(rs)
 	if err != nil {
 		return nil, err
 	}
-	if err := binary.Read(rs, order, &offset); err != nil {
+	offset, err := readOffset(rs, order, bigtiff)
+	if err != nil {
 		return nil, err
 	}
 	var files []*File
 	for i := 0; offset != 0; i++ {
-		f, err := readDirectory(rs, order, offset, i)
+		f, err := readDirectory(rs, order, bigtiff, offset, i)
 		if err != nil {
 			return nil, err
 		}
 		files = append(files, f)
-		if err := binary.Read(rs, order, &offset); err != nil {
+		if offset, err = nextOffset(rs, order, offset, bigtiff); err != nil {
 			if err == io.EOF {
 				break
 			}
 			return nil, err
 		}
 	}
+	readVariant(rs, files)
 	return files, nil
 }
 
-func readDirectory(r *bytes.Reader, order binary.ByteOrder, at uint32, index int) (*File, error) {
-	tags, err := readTags(r, order, at, 0, Tiff)
-	if err != nil {
-		return nil, err
-	}
-	offset, err := r.Seek(0, io.SeekCurrent)
+func readDirectory(r io.ReaderAt, order binary.ByteOrder, bigtiff bool, at uint64, index int) (*File, error) {
+	tags, err := readTags(r, order, at, 0, Tiff, bigtiff)
 	if err != nil {
 		return nil, err
 	}
-	defer func(offset int64) { r.Seek(offset, io.SeekStart) }(int64(offset))
 	f := File{
-		reader: r,
-		order:  order,
-		tiff:   tags,
-		Index:  []int{index},
+		reader:  r,
+		order:   order,
+		bigtiff: bigtiff,
+		tiff:    tags,
+		Index:   []int{index},
 	}
-	if f.exif, err = exifTags(r, order, tags); err != nil {
+	if f.exif, err = exifTags(r, order, tags, bigtiff); err != nil {
 		return nil, err
 	}
-	if f.gps, err = gpsTags(r, order, tags); err != nil {
+	if f.gps, err = gpsTags(r, order, tags, bigtiff); err != nil {
 		return nil, err
 	}
 	if f.notes, err = notesTags(r, f.exif); err != nil {
 		return nil, err
 	}
-	data, err := subTags(r, order, tags)
+	data, err := subTags(r, order, tags, bigtiff)
 	if err != nil {
 		return nil, err
 	}
 	for i, ts := range data {
 		c := File{
-			reader: r,
-			order:  order,
-			tiff:   ts,
-			Index:  []int{index, i},
+			reader:  r,
+			order:   order,
+			bigtiff: bigtiff,
+			tiff:    ts,
+			Index:   []int{index, i},
 		}
 		c.exif = append(c.exif, f.exif...)
 		c.notes = append(c.notes, f.notes...)
@@ -605,24 +823,24 @@ func readDirectory(r *bytes.Reader, order binary.ByteOrder, at uint32, index int
 	return &f, nil
 }
 
-func exifTags(r *bytes.Reader, order binary.ByteOrder, tags []Tag) ([]Tag, error) {
+func exifTags(r io.ReaderAt, order binary.ByteOrder, tags []Tag, bigtiff bool) ([]Tag, error) {
 	x := sort.Search(len(tags), func(i int) bool {
 		return tags[i].Id >= Exif
 	})
 	if x >= len(tags) || tags[x].Id != Exif {
 		return nil, nil
 	}
-	return readTags(r, order, tags[x].Offset, 0, Exif)
+	return readTags(r, order, tags[x].Uint64(), 0, Exif, bigtiff)
 }
 
-func gpsTags(r *bytes.Reader, order binary.ByteOrder, tags []Tag) ([]Tag, error) {
+func gpsTags(r io.ReaderAt, order binary.ByteOrder, tags []Tag, bigtiff bool) ([]Tag, error) {
 	x := sort.Search(len(tags), func(i int) bool {
 		return tags[i].Id >= Gps
 	})
 	if x >= len(tags) || tags[x].Id != Gps {
 		return nil, nil
 	}
-	return readTags(r, order, tags[x].Offset, 0, Gps)
+	return readTags(r, order, tags[x].Uint64(), 0, Gps, bigtiff)
 }
 
 const (
@@ -632,33 +850,33 @@ const (
 	noteFlashInfo        = 0xa8
 )
 
-func notesTags(r *bytes.Reader, tags []Tag) ([]Tag, error) {
+func notesTags(r io.ReaderAt, tags []Tag) ([]Tag, error) {
 	x := sort.Search(len(tags), func(i int) bool {
 		return tags[i].Id >= Note
 	})
 	if x >= len(tags) || tags[x].Id != Note {
 		return nil, nil
 	}
-	if _, err := r.Seek(int64(tags[x].Offset), io.SeekStart); err != nil {
-		return nil, err
-	}
-	preamble := make([]byte, 10)
-	if _, err := io.ReadFull(r, preamble); err != nil {
+	var (
+		rs       = sectionFrom(r, tags[x].Offset)
+		preamble = make([]byte, 10)
+	)
+	if _, err := io.ReadFull(rs, preamble); err != nil {
 		return nil, err
 	}
 	if !bytes.HasPrefix(preamble, []byte("Nikon\x00")) {
 		return nil, nil
 	}
-	order, err := readOrder(r)
+	order, err := readOrder(rs)
 	if err != nil {
 		return nil, err
 	}
 	var offset uint32
-	if err = binary.Read(r, order, &offset); err != nil {
+	if err = binary.Read(rs, order, &offset); err != nil {
 		return nil, err
 	}
-	offset += tags[x].Offset + 10
-	notes, err := readTags(r, order, offset, tags[x].Offset+10, Note)
+	base := tags[x].Offset + 10
+	notes, err := readTags(r, order, uint64(offset)+base, base, Note, false)
 	if err != nil {
 		return nil, err
 	}
@@ -670,23 +888,17 @@ func notesTags(r *bytes.Reader, tags []Tag) ([]Tag, error) {
 	return notes, err
 }
 
-func subTags(r *bytes.Reader, order binary.ByteOrder, tags []Tag) ([][]Tag, error) {
+func subTags(r io.ReaderAt, order binary.ByteOrder, tags []Tag, bigtiff bool) ([][]Tag, error) {
 	x := sort.Search(len(tags), func(i int) bool {
 		return tags[i].Id >= Nef
 	})
 	if x >= len(tags) || tags[x].Id != Nef {
 		return nil, nil
 	}
-	if _, err := r.Seek(int64(tags[x].Offset), io.SeekStart); err != nil {
-		return nil, err
-	}
-	pos := make([]uint32, int(tags[x].Count))
-	for i := 0; i < int(tags[x].Count); i++ {
-		binary.Read(r, order, &pos[i])
-	}
+	pos := tagUint64s(tags[x])
 	data := make([][]Tag, len(pos))
 	for i := range pos {
-		ts, err := readTags(r, order, pos[i], 0, Tiff)
+		ts, err := readTags(r, order, pos[i], 0, Tiff, bigtiff)
 		if err != nil {
 			return nil, err
 		}
@@ -695,92 +907,168 @@ func subTags(r *bytes.Reader, order binary.ByteOrder, tags []Tag) ([][]Tag, erro
 	return data, nil
 }
 
-func findTags(r *bytes.Reader, tags []Tag, which uint16) ([]Tag, error) {
+func findTags(r io.ReaderAt, tags []Tag, which uint16) ([]Tag, error) {
 	x := sort.Search(len(tags), func(i int) bool {
 		return tags[i].Id >= which
 	})
 	if x >= len(tags) || tags[x].Id != which {
 		return nil, nil
 	}
-	tell, _ := r.Seek(0, io.SeekCurrent)
-	defer func(where int64) {
-		r.Seek(where, io.SeekStart)
-	}(tell)
 	t := tags[x]
-	return readTags(r, t.order, t.Offset, 0, t.family)
+	return readTags(r, t.order, t.Offset, 0, t.family, false)
 }
 
-func readTags(r *bytes.Reader, order binary.ByteOrder, at, delta uint32, family int) ([]Tag, error) {
-	if _, err := r.Seek(int64(at), io.SeekStart); err != nil {
+func readTags(r io.ReaderAt, order binary.ByteOrder, at, delta uint64, family int, bigtiff bool) ([]Tag, error) {
+	rs := sectionFrom(r, at)
+	count, err := readCount(rs, order, bigtiff)
+	if err != nil {
 		return nil, err
 	}
-	var count uint16
-	if err := binary.Read(r, order, &count); err != nil {
-		return nil, err
+	size := 4
+	if bigtiff {
+		size = 8
 	}
 	var tags []Tag
 	for i := 0; i < int(count); i++ {
 		g := struct {
-			Id     uint16
-			Type   Format
-			Count  uint32
-			Offset uint32
+			Id   uint16
+			Type Format
 		}{}
-		if err := binary.Read(r, order, &g); err != nil {
+		if err := binary.Read(rs, order, &g); err != nil {
 			return nil, err
 		}
 		if n := len(tags); n > 0 && g.Id <= tags[n-1].Id {
 			return nil, fmt.Errorf("tags not sorted properly")
 		}
-		if g.Count == (1<<32)-1 {
+		n, err := readOffset(rs, order, bigtiff)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxCount {
 			return nil, fmt.Errorf("invalid count")
 		}
+		field := make([]byte, size)
+		if _, err := io.ReadFull(rs, field); err != nil {
+			return nil, err
+		}
 		tag := Tag{
 			Id:     g.Id,
 			Type:   g.Type,
-			Count:  g.Count,
-			Offset: g.Offset + delta,
+			Count:  n,
 			family: family,
 			order:  order,
 		}
-		if z := tag.Size(); z > 4 {
-			sr := io.NewSectionReader(r, int64(tag.Offset), int64(z))
-			tag.Raw = make([]byte, z)
-			if _, err := io.ReadFull(sr, tag.Raw); err != nil {
+		if bigtiff {
+			tag.Offset = order.Uint64(field)
+		} else {
+			tag.Offset = uint64(order.Uint32(field))
+		}
+		if z := tag.Size(); z > size {
+			tag.Offset += delta
+			// the count is not trusted to allocate the value
+			raw, err := ioutil.ReadAll(io.NewSectionReader(r, int64(tag.Offset), int64(z)))
+			if err != nil {
 				return nil, err
 			}
+			if len(raw) < z {
+				return nil, fmt.Errorf("tag %04x: %w", tag.Id, errTruncated)
+			}
+			tag.Raw = raw
 		} else {
-			tag.Raw = make([]byte, 4)
-			order.PutUint32(tag.Raw, tag.Offset)
+			tag.Raw = field
 		}
 		tags = append(tags, tag)
 	}
 	return tags, nil
 }
 
+func readCount(r io.Reader, order binary.ByteOrder, bigtiff bool) (uint64, error) {
+	if bigtiff {
+		var count uint64
+		err := binary.Read(r, order, &count)
+		return count, err
+	}
+	var count uint16
+	err := binary.Read(r, order, &count)
+	return uint64(count), err
+}
+
+func readOffset(r io.Reader, order binary.ByteOrder, bigtiff bool) (uint64, error) {
+	if bigtiff {
+		var offset uint64
+		err := binary.Read(r, order, &offset)
+		return offset, err
+	}
+	var offset uint32
+	err := binary.Read(r, order, &offset)
+	return uint64(offset), err
+}
+
+// nextOffset gives the offset of the directory following the one at the given
+// offset.
+func nextOffset(r io.ReaderAt, order binary.ByteOrder, at uint64, bigtiff bool) (uint64, error) {
+	count, err := readCount(sectionFrom(r, at), order, bigtiff)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64 = 2 + count*12
+	if bigtiff {
+		size = 8 + count*20
+	}
+	return readOffset(sectionFrom(r, at+size), order, bigtiff)
+}
+
+// sectionFrom gives a reader of r from the given offset.
+func sectionFrom(r io.ReaderAt, at uint64) *io.SectionReader {
+	if at > math.MaxInt64 {
+		at = math.MaxInt64
+	}
+	return io.NewSectionReader(r, int64(at), math.MaxInt64-int64(at))
+}
+
 func readOrder(rs io.ReadSeeker) (binary.ByteOrder, error) {
+	order, bigtiff, err := readHeader(rs)
+	if err == nil && bigtiff {
+		err = fmt.Errorf("unexpected bigtiff header")
+	}
+	return order, err
+}
+
+func readHeader(rs io.ReadSeeker) (binary.ByteOrder, bool, error) {
 	var (
 		intro = make([]byte, 4)
 		magic []byte
+		large []byte
 		order binary.ByteOrder
 	)
 	if _, err := io.ReadFull(rs, intro); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	switch {
 	case bytes.Equal(intro[:2], little):
 		order = binary.LittleEndian
-		magic = magicle
+		magic, large = magicle, bigle
 	case bytes.Equal(intro[:2], big):
 		order = binary.BigEndian
-		magic = magicbe
+		magic, large = magicbe, bigbe
 	default:
-		return nil, fmt.Errorf("invalid byte order %04x", intro[:2])
+		return nil, false, fmt.Errorf("invalid byte order %04x", intro[:2])
 	}
-	if !bytes.Equal(intro[2:], magic) {
-		return nil, fmt.Errorf("invalid magic number %04x", intro[2:])
+	switch {
+	case bytes.Equal(intro[2:], magic), isMagic(order, intro[2:]):
+		return order, false, nil
+	case bytes.Equal(intro[2:], large[:2]):
+		rest := make([]byte, 4)
+		if _, err := io.ReadFull(rs, rest); err != nil {
+			return nil, false, err
+		}
+		if !bytes.Equal(rest, large[2:]) {
+			return nil, false, fmt.Errorf("invalid bigtiff header %04x", rest)
+		}
+		return order, true, nil
+	default:
+		return nil, false, fmt.Errorf("invalid magic number %04x", intro[2:])
 	}
-	return order, nil
 }
 
 func decodeShort(tag Tag) []string {
@@ -814,6 +1102,24 @@ func decodeLong(tag Tag) []string {
 	return str
 }
 
+func decodeLong8(tag Tag) []string {
+	str := make([]string, int(tag.Count))
+	for i := 0; i < len(str); i++ {
+		x := tag.order.Uint64(tag.Raw[i*8:])
+		str[i] = strconv.FormatUint(x, 10)
+	}
+	return str
+}
+
+func decodeSignedLong8(tag Tag) []string {
+	str := make([]string, int(tag.Count))
+	for i := 0; i < len(str); i++ {
+		x := int64(tag.order.Uint64(tag.Raw[i*8:]))
+		str[i] = strconv.FormatInt(x, 10)
+	}
+	return str
+}
+
 func decodeSignedLong(tag Tag) []string {
 	var (
 		str = make([]string, int(tag.Count))
@@ -916,20 +1222,34 @@ func grayImage(rect image.Rectangle, buf []byte, inverted bool) image.Image {
 	return img
 }
 
-func rgbImage(rect image.Rectangle, buf []byte) image.Image {
+// rgbImage gives the image of 8 bits RGB samples. Extra samples, like alpha,
+// are skipped. Planar samples give all the red values followed by all the green
+// values and then all the blue values.
+func rgbImage(rect image.Rectangle, buf []byte, samples int, planar bool) image.Image {
+	if samples < 3 {
+		samples = 3
+	}
 	var (
-		img = image.NewRGBA(rect)
-		rs  = bytes.NewReader(buf)
-		rgb color.RGBA
+		img   = image.NewRGBA(rect)
+		plane = rect.Dx() * rect.Dy()
+		step  = samples
+		next  = 1
+		rgb   color.RGBA
 	)
+	if planar {
+		step, next = 1, plane
+	}
 	for j := 0; j < rect.Dy(); j++ {
 		for i := 0; i < rect.Dx(); i++ {
-			rgb.R, _ = rs.ReadByte()
-			rgb.G, _ = rs.ReadByte()
-			rgb.B, _ = rs.ReadByte()
+			x := (j*rect.Dx() + i) * step
+			if x+2*next >= len(buf) {
+				return img
+			}
+			rgb.R = buf[x]
+			rgb.G = buf[x+next]
+			rgb.B = buf[x+2*next]
 			rgb.A = 255
-
-			img.Set(i, j, rgb)
+			img.SetRGBA(i, j, rgb)
 		}
 	}
 	return img
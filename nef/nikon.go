@@ -0,0 +1,402 @@
+package nef
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+const (
+	noteNEFCompression = 0x93
+	noteLinearization  = 0x96
+)
+
+const CompressionNikon = 34713
+
+const (
+	NikonLossy1 = iota + 1
+	NikonUncompressed
+	NikonLossless
+	NikonLossy2
+	NikonStripedPacked12
+	NikonUncompressed12
+	NikonUnpacked12
+	NikonSmall
+	NikonPacked12
+	NikonPacked14
+)
+
+const (
+	linearizationSplitAt   = 562
+	linearizationSkipBytes = 2110
+)
+
+// nikonTrees are the huffman tables used by the camera. The first 16 bytes
+// give the number of codes of each length, the following the values: the low
+// nibble being the length of the difference and the high nibble the number of
+// bits shifted out by lossy compression. Missing values are zero.
+var nikonTrees = [][]byte{
+	{ // 12-bit lossy
+		0, 1, 5, 1, 1, 1, 1, 1, 1, 2, 0, 0, 0, 0, 0, 0,
+		5, 4, 3, 6, 2, 7, 1, 0, 8, 9, 11, 10, 12,
+	},
+	{ // 12-bit lossy after split
+		0, 1, 5, 1, 1, 1, 1, 1, 1, 2, 0, 0, 0, 0, 0, 0,
+		0x39, 0x5a, 0x38, 0x27, 0x16, 5, 4, 3, 2, 1, 0, 11, 12, 12,
+	},
+	{ // 12-bit lossless
+		0, 1, 4, 2, 3, 1, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		5, 4, 6, 3, 7, 2, 8, 1, 9, 0, 10, 11, 12,
+	},
+	{ // 14-bit lossy
+		0, 1, 4, 3, 1, 1, 1, 1, 1, 2, 0, 0, 0, 0, 0, 0,
+		5, 6, 4, 7, 8, 3, 9, 2, 1, 0, 10, 11, 12, 13, 14,
+	},
+	{ // 14-bit lossy after split
+		0, 1, 5, 1, 1, 1, 1, 1, 1, 1, 2, 0, 0, 0, 0, 0,
+		8, 0x5c, 0x4b, 0x3a, 0x29, 7, 6, 5, 4, 3, 2, 1, 0, 13, 14,
+	},
+	{ // 14-bit lossless
+		0, 1, 4, 2, 2, 3, 1, 2, 0, 0, 0, 0, 0, 0, 0, 0,
+		7, 6, 8, 5, 9, 4, 10, 3, 11, 12, 2, 0, 1, 13, 14,
+	},
+}
+
+// IsCompressed reports whether the sensor data of the image has been
+// compressed by the camera with one of the huffman based schemes.
+func (f File) IsCompressed() bool {
+	c, err := f.get(Compression)
+	if err != nil || c.Uint() != CompressionNikon {
+		return false
+	}
+	if t, err := f.GetTag(noteNEFCompression, Note); err == nil {
+		switch t.Uint() {
+		case NikonLossy1, NikonLossless, NikonLossy2:
+			return true
+		default:
+			return false
+		}
+	}
+	var (
+		width, _  = f.get(ImageWidth)
+		height, _ = f.get(ImageLength)
+		bits      = f.bitsPerSample()
+		size      = int(width.Uint()) * int(height.Uint()) * bits / 8
+	)
+	return f.stripSize() < size
+}
+
+// RawImage gives the sensor data of the image, one sample per photosite as
+// recorded by the camera (12 or 14 bits) without any further processing.
+func (f File) RawImage() (*image.Gray16, error) {
+	if !f.IsRaw() {
+		return nil, ErrImage
+	}
+	if f.IsCompressed() {
+		return f.decodeNikon()
+	}
+	return f.decodeUnpacked()
+}
+
+func (f File) decodeNikon() (*image.Gray16, error) {
+	var (
+		width, _  = f.get(ImageWidth)
+		height, _ = f.get(ImageLength)
+		rect      = image.Rect(0, 0, int(width.Uint()), int(height.Uint()))
+		bits      = f.bitsPerSample()
+	)
+	meta, err := f.GetTag(noteLinearization, Note)
+	if err != nil {
+		return nil, fmt.Errorf("linearization table: %w", err)
+	}
+	buf, err := f.processRaw()
+	if err != nil {
+		return nil, err
+	}
+	return decodeNikon(rect, buf, meta, bits)
+}
+
+type nikonCurve struct {
+	vpred [2][2]uint16
+	curve []uint16
+	split int
+	tree  int
+}
+
+func readNikonCurve(meta Tag, bits int) (nikonCurve, error) {
+	var (
+		c     nikonCurve
+		order = meta.ByteOrder()
+		raw   = meta.Raw
+	)
+	if len(raw) < 12 {
+		return c, fmt.Errorf("linearization table: %w", ErrFormat)
+	}
+	ver0, ver1 := raw[0], raw[1]
+	if ver0 == 0x49 || ver1 == 0x58 {
+		if len(raw) < linearizationSkipBytes+12 {
+			return c, fmt.Errorf("linearization table: %w", ErrFormat)
+		}
+		raw = raw[linearizationSkipBytes:]
+	}
+	if ver0 == 0x46 {
+		c.tree = 2
+	}
+	if bits == 14 {
+		c.tree += 3
+	}
+	for i := 0; i < 4; i++ {
+		c.vpred[i/2][i%2] = order.Uint16(raw[2+i*2:])
+	}
+	c.curve = make([]uint16, 1<<16)
+	for i := range c.curve {
+		c.curve[i] = uint16(i)
+	}
+	var (
+		max  = (1 << bits) & 0x7fff
+		size = int(order.Uint16(raw[10:]))
+		step int
+	)
+	if size > 1 {
+		step = max / (size - 1)
+	}
+	switch {
+	case ver0 == 0x44 && ver1 == 0x20 && step > 0:
+		if len(raw) < 12+size*2 || len(raw) < linearizationSplitAt+2 {
+			return c, fmt.Errorf("linearization table: %w", ErrFormat)
+		}
+		for i := 0; i < size; i++ {
+			c.curve[i*step] = order.Uint16(raw[12+i*2:])
+		}
+		for i := 0; i < max; i++ {
+			var (
+				j = i - i%step
+				n = int(c.curve[j])*(step-i%step) + int(c.curve[j+step])*(i%step)
+			)
+			c.curve[i] = uint16(n / step)
+		}
+		c.split = int(order.Uint16(raw[linearizationSplitAt:]))
+	case ver0 != 0x46 && size <= 0x4001:
+		if len(raw) < 12+size*2 {
+			return c, fmt.Errorf("linearization table: %w", ErrFormat)
+		}
+		for i := 0; i < size; i++ {
+			c.curve[i] = order.Uint16(raw[12+i*2:])
+		}
+	}
+	return c, nil
+}
+
+func decodeNikon(rect image.Rectangle, buf []byte, meta Tag, bits int) (*image.Gray16, error) {
+	c, err := readNikonCurve(meta, bits)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		img   = image.NewGray16(rect)
+		huff  = newHuffman(nikonTrees[c.tree])
+		br    = newBitReader(buf)
+		hpred [2]int
+		vpred = [2][2]int{
+			{int(c.vpred[0][0]), int(c.vpred[0][1])},
+			{int(c.vpred[1][0]), int(c.vpred[1][1])},
+		}
+	)
+	for row := 0; row < rect.Dy(); row++ {
+		if c.split > 0 && row == c.split {
+			huff = newHuffman(nikonTrees[c.tree+1])
+		}
+		pix := img.Pix[row*img.Stride:]
+		for col := 0; col < rect.Dx(); col++ {
+			var (
+				code = huff.decode(br)
+				size = int(code & 15)
+				shl  = int(code >> 4)
+				diff int
+			)
+			if size > 0 {
+				diff = ((int(br.bits(uint(size-shl))) << 1) + 1) << shl >> 1
+				if diff&(1<<(size-1)) == 0 {
+					diff -= 1 << size
+					if shl == 0 {
+						diff++
+					}
+				}
+			}
+			if col < 2 {
+				vpred[row&1][col] += diff
+				hpred[col] = vpred[row&1][col]
+			} else {
+				hpred[col&1] += diff
+			}
+			val := c.curve[clamp(int(int16(hpred[col&1])), 0, 0x3fff)]
+			binary.BigEndian.PutUint16(pix[col*2:], val)
+		}
+	}
+	if br.overflow() {
+		return img, fmt.Errorf("nikon: %w", errTruncated)
+	}
+	return img, nil
+}
+
+var errTruncated = errors.New("truncated data")
+
+type huffman struct {
+	bits  uint
+	table []uint16
+}
+
+func newHuffman(tree []byte) huffman {
+	var (
+		h    huffman
+		max  = 16
+		vals = tree[16:]
+	)
+	for max > 0 && tree[max-1] == 0 {
+		max--
+	}
+	h.bits = uint(max)
+	h.table = make([]uint16, 1<<max)
+
+	var pos, k int
+	for size := 1; size <= max; size++ {
+		for i := 0; i < int(tree[size-1]); i++ {
+			var val byte
+			if k < len(vals) {
+				val = vals[k]
+			}
+			for j := 0; j < 1<<(max-size) && pos < len(h.table); j++ {
+				h.table[pos] = uint16(size)<<8 | uint16(val)
+				pos++
+			}
+			k++
+		}
+	}
+	return h
+}
+
+func (h huffman) decode(br *bitReader) byte {
+	v := h.table[br.peek(h.bits)]
+	br.skip(uint(v >> 8))
+	return byte(v)
+}
+
+// bitReader reads bits from the most significant to the least significant
+// one. Reading past the end of the buffer gives zero bits.
+type bitReader struct {
+	buf  []byte
+	pos  int
+	acc  uint64
+	size uint
+	over int
+}
+
+func newBitReader(buf []byte) *bitReader {
+	return &bitReader{buf: buf}
+}
+
+func (b *bitReader) fill(n uint) {
+	for b.size < n {
+		var c byte
+		if b.pos < len(b.buf) {
+			c = b.buf[b.pos]
+		} else {
+			b.over++
+		}
+		b.pos++
+		b.acc = b.acc<<8 | uint64(c)
+		b.size += 8
+	}
+}
+
+func (b *bitReader) peek(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	b.fill(n)
+	return uint32(b.acc>>(b.size-n)) & (1<<n - 1)
+}
+
+func (b *bitReader) skip(n uint) {
+	b.fill(n)
+	b.size -= n
+}
+
+func (b *bitReader) bits(n uint) uint32 {
+	v := b.peek(n)
+	b.skip(n)
+	return v
+}
+
+// overflow reports whether more than a few bytes have been read past the end
+// of the buffer, a few being expected since the reader looks ahead.
+func (b *bitReader) overflow() bool {
+	return b.over > 4
+}
+
+func (f File) bitsPerSample() int {
+	t, err := f.get(BitsPerSample)
+	if err != nil {
+		return 8
+	}
+	return int(t.Uint())
+}
+
+func (f File) stripSize() int {
+	t, err := f.get(StripByteCounts)
+	if err != nil {
+		return 0
+	}
+	var size int
+	for _, n := range tagUints(t) {
+		size += int(n)
+	}
+	return size
+}
+
+// tagUints gives all the values of a tag holding unsigned integers whatever
+// their size.
+func tagUints(t Tag) []uint32 {
+	var (
+		values = tagUint64s(t)
+		list   = make([]uint32, len(values))
+	)
+	for i, v := range values {
+		list[i] = uint32(v)
+	}
+	return list
+}
+
+// tagUint64s is like tagUints but keeps the 8 bytes values of BigTIFF, as
+// needed for offsets.
+func tagUint64s(t Tag) []uint64 {
+	var (
+		list  = make([]uint64, 0, t.Count)
+		order = t.ByteOrder()
+		size  = t.Type.Size()
+	)
+	for i := 0; i < int(t.Count) && (i+1)*size <= len(t.Raw); i++ {
+		switch x := t.Raw[i*size:]; t.Type {
+		case Byte:
+			list = append(list, uint64(x[0]))
+		case Short:
+			list = append(list, uint64(order.Uint16(x)))
+		case Long, Ifd:
+			list = append(list, uint64(order.Uint32(x)))
+		case Long8, Ifd8:
+			list = append(list, order.Uint64(x))
+		}
+	}
+	return list
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,140 @@
+package nef
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+const tiffOrientation = 0x112
+
+const (
+	OrientNormal uint32 = iota + 1
+	OrientMirror
+	OrientRotate180
+	OrientFlip
+	OrientTranspose
+	OrientRotate90
+	OrientTransverse
+	OrientRotate270
+)
+
+var (
+	colorPoint  = color.RGBA{R: 160, G: 160, B: 160, A: 255}
+	colorActive = color.RGBA{R: 230, G: 30, B: 30, A: 255}
+	colorFocus  = color.RGBA{R: 30, G: 220, B: 30, A: 255}
+)
+
+func (f File) Orientation() uint32 {
+	t, err := f.get(tiffOrientation)
+	if err != nil {
+		return OrientNormal
+	}
+	return t.Uint()
+}
+
+// DrawFocus draws the focus points of the camera on top of img: the points
+// used by the camera in red, the points in focus in green, and the
+// contrast-detect area when there is one. img is expected to be the full size
+// preview in the sensor orientation, as the positions of the points are
+// relative to the frame of the sensor. Use Orient to display it upright.
+func DrawFocus(img image.Image, a AFInfo) image.Image {
+	var (
+		rect = img.Bounds()
+		dst  = image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		size = rect.Dx() / 60
+	)
+	if size < 4 {
+		size = 4
+	}
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+
+	for _, p := range a.Points(rect.Dx(), rect.Dy()) {
+		drawBox(dst, pointRect(p, size), colorPoint, 1)
+	}
+	for _, p := range a.UsedPoints(rect.Dx(), rect.Dy()) {
+		drawBox(dst, pointRect(p, size), colorActive, 2)
+	}
+	for _, p := range a.FocusPoints(rect.Dx(), rect.Dy()) {
+		drawBox(dst, pointRect(p, size).Inset(-3), colorFocus, 2)
+	}
+	if p, err := a.PrimaryPoint(rect.Dx(), rect.Dy()); err == nil {
+		drawBox(dst, pointRect(p, size).Inset(-6), colorActive, 3)
+	}
+	if area := a.FocusArea(rect.Dx(), rect.Dy()); !area.Empty() {
+		c := colorActive
+		if a.ContrastFocus {
+			c = colorFocus
+		}
+		drawBox(dst, area, c, 3)
+	}
+	return dst
+}
+
+// Orient transforms img according to the given Exif orientation so that the
+// result is displayed upright.
+func Orient(img image.Image, orientation uint32) image.Image {
+	if orientation <= OrientNormal || orientation > OrientRotate270 {
+		return img
+	}
+	var (
+		rect = img.Bounds()
+		w    = rect.Dx()
+		h    = rect.Dy()
+		dst  *image.RGBA
+	)
+	switch orientation {
+	case OrientTranspose, OrientRotate90, OrientTransverse, OrientRotate270:
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	default:
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var (
+				px = img.At(rect.Min.X+x, rect.Min.Y+y)
+				dx int
+				dy int
+			)
+			switch orientation {
+			case OrientMirror:
+				dx, dy = w-1-x, y
+			case OrientRotate180:
+				dx, dy = w-1-x, h-1-y
+			case OrientFlip:
+				dx, dy = x, h-1-y
+			case OrientTranspose:
+				dx, dy = y, x
+			case OrientRotate90:
+				dx, dy = h-1-y, x
+			case OrientTransverse:
+				dx, dy = h-1-y, w-1-x
+			case OrientRotate270:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, px)
+		}
+	}
+	return dst
+}
+
+func pointRect(p AFPoint, size int) image.Rectangle {
+	return image.Rect(p.X-size/2, p.Y-size/2, p.X+size/2, p.Y+size/2)
+}
+
+func drawBox(img *image.RGBA, r image.Rectangle, c color.Color, width int) {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return
+	}
+	for i := 0; i < width; i++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.Set(x, r.Min.Y+i, c)
+			img.Set(x, r.Max.Y-1-i, c)
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			img.Set(r.Min.X+i, y, c)
+			img.Set(r.Max.X-1-i, y, c)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package nef
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+const (
+	PlanarConfiguration = 0x11c
+	ColorMap            = 0x140
+	YCbCrSubSampling    = 0x212
+	ReferenceBlackWhite = 0x214
+)
+
+const (
+	planarChunky       = 1
+	defaultSubSampling = 2
+)
+
+// decodePalette decodes images whose samples are indexes in the ColorMap. The
+// ColorMap gives all the red values followed by all the green values and then
+// all the blue values, each of them on 16 bits.
+func (f File) decodePalette(rect image.Rectangle) (image.Image, error) {
+	bits := f.bitsPerSample()
+	if bits > 8 {
+		return nil, fmt.Errorf("palette with %d bits per sample: %w", bits, ErrFormat)
+	}
+	t, err := f.get(ColorMap)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		size   = 1 << bits
+		values = tagUints(t)
+	)
+	if len(values) < 3*size {
+		return nil, fmt.Errorf("color map: %d values for %d colors: %w", len(values), size, ErrFormat)
+	}
+	palette := make(color.Palette, size)
+	for i := range palette {
+		palette[i] = color.RGBA64{
+			R: uint16(values[i]),
+			G: uint16(values[size+i]),
+			B: uint16(values[2*size+i]),
+			A: 0xffff,
+		}
+	}
+	list, err := f.unpack()
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewPaletted(rect, palette)
+	for i := 0; i < len(img.Pix) && i < len(list); i++ {
+		img.Pix[i] = uint8(list[i])
+	}
+	return img, nil
+}
+
+// decodeMask decodes a transparency mask: one bit per pixel, a pixel being
+// opaque when its bit is set.
+func (f File) decodeMask(rect image.Rectangle) (image.Image, error) {
+	buf, err := f.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	u := Unpacker{
+		Bits:    1,
+		Samples: 1,
+		Order:   f.order,
+	}
+	list, err := u.Unpack(buf, rect.Dx(), rect.Dy())
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewAlpha(rect)
+	for i := 0; i < len(img.Pix) && i < len(list); i++ {
+		if list[i] != 0 {
+			img.Pix[i] = 0xff
+		}
+	}
+	return img, nil
+}
+
+func (f File) decodeCMYK(rect image.Rectangle) (image.Image, error) {
+	if s := f.samplesPerPixel(); s < 4 {
+		return nil, fmt.Errorf("cmyk with %d samples per pixel: %w", s, ErrFormat)
+	}
+	if err := f.checkChunky(); err != nil {
+		return nil, err
+	}
+	var (
+		bits    = f.bitsPerSample()
+		samples = f.samplesPerPixel()
+	)
+	if bits == 8 && samples == 4 {
+		buf, err := f.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		img := image.NewCMYK(rect)
+		if len(buf) < len(img.Pix) {
+			return nil, fmt.Errorf("cmyk: %w", errTruncated)
+		}
+		copy(img.Pix, buf)
+		return img, nil
+	}
+	list, err := f.unpack()
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA64(rect)
+	for j := 0; j < rect.Dy(); j++ {
+		for i := 0; i < rect.Dx(); i++ {
+			x := (j*rect.Dx() + i) * samples
+			if x+3 >= len(list) {
+				return img, nil
+			}
+			var (
+				c = uint32(scaleSample(list[x], bits))
+				m = uint32(scaleSample(list[x+1], bits))
+				y = uint32(scaleSample(list[x+2], bits))
+				k = uint32(scaleSample(list[x+3], bits))
+				w = 0xffff - k
+			)
+			img.SetRGBA64(i, j, color.RGBA64{
+				R: uint16((0xffff - c) * w / 0xffff),
+				G: uint16((0xffff - m) * w / 0xffff),
+				B: uint16((0xffff - y) * w / 0xffff),
+				A: 0xffff,
+			})
+		}
+	}
+	return img, nil
+}
+
+var subsampleRatios = map[[2]int]image.YCbCrSubsampleRatio{
+	{1, 1}: image.YCbCrSubsampleRatio444,
+	{2, 1}: image.YCbCrSubsampleRatio422,
+	{2, 2}: image.YCbCrSubsampleRatio420,
+	{1, 2}: image.YCbCrSubsampleRatio440,
+	{4, 1}: image.YCbCrSubsampleRatio411,
+	{4, 2}: image.YCbCrSubsampleRatio410,
+}
+
+// decodeYCbCr decodes uncompressed YCbCr images. Samples are grouped in data
+// units: the luma samples of a block of SubSamplingH x SubSamplingV pixels
+// followed by one Cb and one Cr sample. The default coefficients of TIFF are
+// the ones used by image.YCbCr.
+func (f File) decodeYCbCr(rect image.Rectangle) (image.Image, error) {
+	if bits := f.bitsPerSample(); bits != 8 {
+		return nil, fmt.Errorf("ycbcr with %d bits per sample: %w", bits, ErrFormat)
+	}
+	if err := f.checkChunky(); err != nil {
+		return nil, err
+	}
+	sh, sv := defaultSubSampling, defaultSubSampling
+	if t, err := f.get(YCbCrSubSampling); err == nil {
+		if vs := tagUints(t); len(vs) == 2 {
+			sh, sv = int(vs[0]), int(vs[1])
+		}
+	}
+	ratio, ok := subsampleRatios[[2]int{sh, sv}]
+	if !ok {
+		return nil, fmt.Errorf("ycbcr subsampling %dx%d: %w", sh, sv, ErrFormat)
+	}
+	buf, err := f.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		img   = image.NewYCbCr(rect, ratio)
+		cols  = (rect.Dx() + sh - 1) / sh
+		rows  = (rect.Dy() + sv - 1) / sv
+		unit  = sh*sv + 2
+		pos   int
+		black = f.referenceBlackWhite()
+	)
+	if len(buf) < cols*rows*unit {
+		return nil, fmt.Errorf("ycbcr: %w", errTruncated)
+	}
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			block := buf[pos : pos+unit]
+			pos += unit
+			for j := 0; j < sv; j++ {
+				for i := 0; i < sh; i++ {
+					x, y := c*sh+i, r*sv+j
+					if x >= rect.Dx() || y >= rect.Dy() {
+						continue
+					}
+					img.Y[img.YOffset(x, y)] = black.luma(block[j*sh+i])
+				}
+			}
+			x, y := c*sh, r*sv
+			if x >= rect.Dx() || y >= rect.Dy() {
+				continue
+			}
+			at := img.COffset(x, y)
+			img.Cb[at] = black.chroma(block[sh*sv], 1)
+			img.Cr[at] = black.chroma(block[sh*sv+1], 2)
+		}
+	}
+	return img, nil
+}
+
+// refBlackWhite holds the values of the ReferenceBlackWhite tag used to scale
+// YCbCr samples to the full range expected by image.YCbCr.
+type refBlackWhite [6]float64
+
+func (f File) referenceBlackWhite() refBlackWhite {
+	ref := refBlackWhite{0, 255, 128, 255, 128, 255}
+	if t, err := f.get(ReferenceBlackWhite); err == nil {
+		if fs := t.Floats(); len(fs) == len(ref) {
+			copy(ref[:], fs)
+		}
+	}
+	return ref
+}
+
+func (r refBlackWhite) luma(v byte) byte {
+	if r[1] == r[0] {
+		return v
+	}
+	return clampByte((float64(v) - r[0]) * 255 / (r[1] - r[0]))
+}
+
+func (r refBlackWhite) chroma(v byte, which int) byte {
+	var (
+		black = r[which*2]
+		white = r[which*2+1]
+	)
+	if white == black {
+		return v
+	}
+	return clampByte((float64(v)-black)*127/(white-black) + 128)
+}
+
+func (f File) checkChunky() error {
+	t, err := f.get(PlanarConfiguration)
+	if err != nil || t.Uint() == planarChunky {
+		return nil
+	}
+	return fmt.Errorf("planar configuration %d: %w", t.Uint(), ErrFormat)
+}
+
+func clampByte(v float64) byte {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return byte(v + 0.5)
+}
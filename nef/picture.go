@@ -0,0 +1,299 @@
+package nef
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const notePictureControl = 0x23
+
+const (
+	PCDefault uint8 = iota
+	PCQuick
+	PCFull
+)
+
+var pcFilters = map[uint8]string{
+	0x80: "off",
+	0x81: "yellow",
+	0x82: "orange",
+	0x83: "red",
+	0x84: "green",
+	0xff: "n/a",
+}
+
+var pcTonings = map[uint8]string{
+	0x80: "b&w",
+	0x81: "sepia",
+	0x82: "cyanotype",
+	0x83: "red",
+	0x84: "yellow",
+	0x85: "green",
+	0x86: "blue-green",
+	0x87: "blue",
+	0x88: "purple-blue",
+	0x89: "red-purple",
+	0xff: "n/a",
+}
+
+type Adjustment struct {
+	Value float64
+	Auto  bool
+	User  bool
+	NA    bool
+}
+
+func (a Adjustment) String() string {
+	switch {
+	case a.NA:
+		return "n/a"
+	case a.Auto:
+		return "auto"
+	case a.User:
+		return "user"
+	case a.Value > 0:
+		return "+" + strconv.FormatFloat(a.Value, 'f', -1, 64)
+	default:
+		return strconv.FormatFloat(a.Value, 'f', -1, 64)
+	}
+}
+
+type PictureControl struct {
+	Version string
+	Name    string
+	Base    string
+
+	Adjust      uint8
+	QuickAdjust Adjustment
+
+	Sharpness         Adjustment
+	MidRangeSharpness Adjustment
+	Clarity           Adjustment
+	Contrast          Adjustment
+	Brightness        Adjustment
+	Saturation        Adjustment
+	Hue               Adjustment
+
+	FilterEffect     uint8
+	ToningEffect     uint8
+	ToningSaturation Adjustment
+}
+
+func (p PictureControl) Filter() string {
+	str, ok := pcFilters[p.FilterEffect]
+	if !ok {
+		str = fmt.Sprintf("other (%d)", p.FilterEffect)
+	}
+	return str
+}
+
+func (p PictureControl) Toning() string {
+	str, ok := pcTonings[p.ToningEffect]
+	if !ok {
+		str = fmt.Sprintf("other (%d)", p.ToningEffect)
+	}
+	return str
+}
+
+func (p PictureControl) String() string {
+	var adjust string
+	switch p.Adjust {
+	case PCDefault:
+		adjust = "default settings"
+	case PCQuick:
+		adjust = "quick adjust " + p.QuickAdjust.String()
+	case PCFull:
+		adjust = "full control"
+	default:
+		adjust = fmt.Sprintf("other (%d)", p.Adjust)
+	}
+	str := []string{
+		fmt.Sprintf("%s/%s", p.Name, p.Base),
+		adjust,
+		"sharpness: " + p.Sharpness.String(),
+		"contrast: " + p.Contrast.String(),
+		"brightness: " + p.Brightness.String(),
+		"saturation: " + p.Saturation.String(),
+		"hue: " + p.Hue.String(),
+	}
+	if !p.Clarity.NA {
+		str = append(str, "clarity: "+p.Clarity.String())
+	}
+	if !p.MidRangeSharpness.NA {
+		str = append(str, "mid-range sharpness: "+p.MidRangeSharpness.String())
+	}
+	if p.FilterEffect != 0xff {
+		str = append(str, "filter: "+p.Filter())
+	}
+	if p.ToningEffect != 0xff {
+		str = append(str, "toning: "+p.Toning())
+	}
+	return fmt.Sprintf("%s (%s)", p.Version, strings.Join(str, ", "))
+}
+
+// pcLayout gives the position of each field in a given version of the
+// PictureControlData block. A negative position means the field is not
+// recorded in this version.
+type pcLayout struct {
+	name       int
+	base       int
+	adjust     int
+	quick      int
+	sharpness  int
+	midrange   int
+	clarity    int
+	contrast   int
+	brightness int
+	saturation int
+	hue        int
+	filter     int
+	toning     int
+	toningsat  int
+	scale      float64
+}
+
+var pcLayouts = map[string]pcLayout{
+	"0100": {
+		name:       4,
+		base:       24,
+		adjust:     48,
+		quick:      49,
+		sharpness:  50,
+		midrange:   -1,
+		clarity:    -1,
+		contrast:   51,
+		brightness: 52,
+		saturation: 53,
+		hue:        54,
+		filter:     55,
+		toning:     56,
+		toningsat:  57,
+		scale:      1,
+	},
+	"0200": {
+		name:       4,
+		base:       24,
+		adjust:     48,
+		quick:      49,
+		sharpness:  51,
+		midrange:   -1,
+		clarity:    53,
+		contrast:   55,
+		brightness: 57,
+		saturation: 59,
+		hue:        61,
+		filter:     63,
+		toning:     64,
+		toningsat:  65,
+		scale:      4,
+	},
+	"0300": {
+		name:       8,
+		base:       28,
+		adjust:     54,
+		quick:      55,
+		sharpness:  57,
+		midrange:   59,
+		clarity:    61,
+		contrast:   63,
+		brightness: 65,
+		saturation: 67,
+		hue:        69,
+		filter:     71,
+		toning:     72,
+		toningsat:  73,
+		scale:      4,
+	},
+	"0310": {
+		name:       8,
+		base:       28,
+		adjust:     54,
+		quick:      55,
+		sharpness:  57,
+		midrange:   59,
+		clarity:    61,
+		contrast:   63,
+		brightness: 65,
+		saturation: 67,
+		hue:        69,
+		filter:     71,
+		toning:     72,
+		toningsat:  73,
+		scale:      4,
+	},
+}
+
+func (f File) PictureControl() (PictureControl, error) {
+	t, err := f.GetTag(notePictureControl, Note)
+	if err != nil {
+		return PictureControl{}, err
+	}
+	return DecodePictureControl(t)
+}
+
+func DecodePictureControl(t Tag) (PictureControl, error) {
+	var (
+		p   PictureControl
+		buf = t.Raw
+	)
+	if t.Id != notePictureControl || len(buf) < 4 {
+		return p, fmt.Errorf("picture control: %w", ErrFormat)
+	}
+	p.Version = string(buf[:4])
+	layout, ok := pcLayouts[p.Version]
+	if !ok {
+		return p, fmt.Errorf("picture control version %s: %w", p.Version, ErrFormat)
+	}
+	if len(buf) <= layout.toningsat {
+		return p, fmt.Errorf("picture control: short buffer (%d bytes): %w", len(buf), ErrFormat)
+	}
+	p.Name = pcString(buf[layout.name : layout.name+20])
+	p.Base = pcString(buf[layout.base : layout.base+20])
+	p.Adjust = buf[layout.adjust]
+	p.FilterEffect = buf[layout.filter]
+	p.ToningEffect = buf[layout.toning]
+
+	adjust := func(at int) Adjustment {
+		if at < 0 {
+			return Adjustment{NA: true}
+		}
+		return pcAdjustment(buf[at], layout.scale)
+	}
+	p.QuickAdjust = adjust(layout.quick)
+	p.Sharpness = adjust(layout.sharpness)
+	p.MidRangeSharpness = adjust(layout.midrange)
+	p.Clarity = adjust(layout.clarity)
+	p.Contrast = adjust(layout.contrast)
+	p.Brightness = adjust(layout.brightness)
+	p.Saturation = adjust(layout.saturation)
+	p.Hue = adjust(layout.hue)
+	p.ToningSaturation = adjust(layout.toningsat)
+	return p, nil
+}
+
+// pcAdjustment decodes a picture control setting. Settings are stored with an
+// offset of 0x80: 0x00 (-128) means automatic, 0x01 (-127) user defined and
+// 0xff not applicable.
+func pcAdjustment(b byte, scale float64) Adjustment {
+	var a Adjustment
+	switch b {
+	case 0xff:
+		a.NA = true
+	case 0x00:
+		a.Auto = true
+	case 0x01:
+		a.User = true
+	default:
+		a.Value = float64(int(b)-0x80) / scale
+	}
+	return a
+}
+
+func pcString(b []byte) string {
+	if x := bytes.IndexByte(b, 0); x >= 0 {
+		b = b[:x]
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,24 @@
+package nef
+
+import "testing"
+
+func TestPictureControlAdjustment(t *testing.T) {
+	data := []struct {
+		Raw   byte
+		Scale float64
+		Want  string
+	}{
+		{Raw: 0x00, Scale: 1, Want: "auto"},
+		{Raw: 0x01, Scale: 1, Want: "user"},
+		{Raw: 0xff, Scale: 1, Want: "n/a"},
+		{Raw: 0x80, Scale: 1, Want: "0"},
+		{Raw: 0x83, Scale: 1, Want: "+3"},
+		{Raw: 0x7e, Scale: 1, Want: "-2"},
+		{Raw: 0x86, Scale: 4, Want: "+1.5"},
+	}
+	for _, d := range data {
+		if got := pcAdjustment(d.Raw, d.Scale).String(); got != d.Want {
+			t.Errorf("%02x: want %s, got %s", d.Raw, d.Want, got)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package nef
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	chunkIHDR = "IHDR"
+	chunkIDAT = "IDAT"
+	chunkIEND = "IEND"
+	chunkEXIF = "eXIf"
+	chunkTEXT = "tEXt"
+	chunkZTXT = "zTXt"
+	chunkITXT = "iTXt"
+
+	xmpKeyword = "XML:com.adobe.xmp"
+)
+
+var pngMagic = []byte("\x89PNG\r\n\x1a\n")
+
+// Chunk is a chunk of a PNG or WebP file. Offset is the position of its
+// header and Data its content without its header and its checksum or padding.
+type Chunk struct {
+	Type   string
+	Offset int64
+	Data   []byte
+}
+
+// PNG holds the metadata of a PNG file: the directories of its eXIf chunk,
+// the XMP packet of its iTXt chunk and the text of its other textual chunks
+// by keyword.
+type PNG struct {
+	Width  int
+	Height int
+
+	Files  []*File
+	XMP    []byte
+	Texts  map[string]string
+	Chunks []Chunk
+}
+
+func IsPNG(buf []byte) bool {
+	return bytes.HasPrefix(buf, pngMagic)
+}
+
+func DecodePNGFile(file string) (*PNG, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return DecodePNG(r)
+}
+
+func DecodePNG(r io.Reader) (*PNG, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodePNG(buf)
+}
+
+func decodePNG(buf []byte) (*PNG, error) {
+	chunks, err := readChunks(buf)
+	if err != nil {
+		return nil, err
+	}
+	p := PNG{
+		Texts:  make(map[string]string),
+		Chunks: chunks,
+	}
+	for _, c := range chunks {
+		if err := p.readChunk(c); err != nil {
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// readChunks gives the chunks of a PNG file until its IEND chunk.
+func readChunks(buf []byte) ([]Chunk, error) {
+	if !IsPNG(buf) {
+		return nil, fmt.Errorf("png: missing signature: %w", ErrFormat)
+	}
+	var (
+		list []Chunk
+		pos  = len(pngMagic)
+	)
+	for pos+8 <= len(buf) {
+		var (
+			size = int(binary.BigEndian.Uint32(buf[pos:]))
+			c    = Chunk{
+				Type:   string(buf[pos+4 : pos+8]),
+				Offset: int64(pos),
+			}
+		)
+		if size < 0 || pos+12+size > len(buf) {
+			return nil, fmt.Errorf("png: chunk %s: %w", c.Type, errTruncated)
+		}
+		c.Data = buf[pos+8 : pos+8+size]
+		list = append(list, c)
+		pos += size + 12
+		if c.Type == chunkIEND {
+			break
+		}
+	}
+	return list, nil
+}
+
+func (p *PNG) readChunk(c Chunk) error {
+	switch c.Type {
+	case chunkIHDR:
+		if len(c.Data) < 8 {
+			return fmt.Errorf("png: header: %w", errTruncated)
+		}
+		p.Width = int(binary.BigEndian.Uint32(c.Data))
+		p.Height = int(binary.BigEndian.Uint32(c.Data[4:]))
+	case chunkEXIF:
+		files, err := decodeTiff(bytes.TrimPrefix(c.Data, exifHeader))
+		if err != nil {
+			return fmt.Errorf("png: exif: %w", err)
+		}
+		p.Files = files
+	case chunkTEXT, chunkZTXT, chunkITXT:
+		key, text, err := readText(c)
+		if err != nil {
+			return err
+		}
+		if key == xmpKeyword {
+			p.XMP = text
+		} else {
+			p.Texts[key] = string(text)
+		}
+	}
+	return nil
+}
+
+// readText gives the keyword and the text of a textual chunk, once
+// uncompressed. The language and the translated keyword of iTXt are skipped.
+func readText(c Chunk) (string, []byte, error) {
+	x := bytes.IndexByte(c.Data, 0)
+	if x < 0 {
+		return "", nil, fmt.Errorf("png: %s: missing keyword: %w", c.Type, ErrFormat)
+	}
+	var (
+		key      = string(c.Data[:x])
+		rest     = c.Data[x+1:]
+		compress bool
+	)
+	switch c.Type {
+	case chunkZTXT:
+		if len(rest) < 1 {
+			return "", nil, fmt.Errorf("png: %s: %w", c.Type, errTruncated)
+		}
+		rest, compress = rest[1:], true
+	case chunkITXT:
+		if len(rest) < 2 {
+			return "", nil, fmt.Errorf("png: %s: %w", c.Type, errTruncated)
+		}
+		compress = rest[0] == 1
+		rest = rest[2:]
+		for i := 0; i < 2; i++ {
+			x := bytes.IndexByte(rest, 0)
+			if x < 0 {
+				return "", nil, fmt.Errorf("png: %s: %w", c.Type, errTruncated)
+			}
+			rest = rest[x+1:]
+		}
+	}
+	if !compress {
+		return key, rest, nil
+	}
+	z, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return "", nil, fmt.Errorf("png: %s: %w", c.Type, err)
+	}
+	defer z.Close()
+	text, err := ioutil.ReadAll(z)
+	if err != nil {
+		return "", nil, fmt.Errorf("png: %s: %w", c.Type, err)
+	}
+	return key, text, nil
+}
+
+// EmbedPNG gives a copy of a PNG file with the directories given in its eXIf
+// chunk and the XMP packet given in an iTXt chunk. The existing chunks are
+// removed and the new ones inserted before the image data, so giving no
+// directories or no packet strips them from the file.
+func EmbedPNG(buf []byte, files []*File, xmp []byte) ([]byte, error) {
+	chunks, err := readChunks(buf)
+	if err != nil {
+		return nil, err
+	}
+	var exif []byte
+	if len(files) > 0 {
+		var tmp bytes.Buffer
+		if err := Encode(&tmp, files); err != nil {
+			return nil, err
+		}
+		exif = tmp.Bytes()
+	}
+	var (
+		out  = append(make([]byte, 0, len(buf)+len(exif)+len(xmp)), pngMagic...)
+		done bool
+	)
+	for _, c := range chunks {
+		switch {
+		case c.Type == chunkEXIF:
+			continue
+		case c.Type == chunkITXT && bytes.HasPrefix(c.Data, []byte(xmpKeyword+"\x00")):
+			continue
+		case (c.Type == chunkIDAT || c.Type == chunkIEND) && !done:
+			if len(exif) > 0 {
+				out = appendChunk(out, chunkEXIF, exif)
+			}
+			if len(xmp) > 0 {
+				text := append([]byte(xmpKeyword), 0, 0, 0, 0, 0)
+				out = appendChunk(out, chunkITXT, append(text, xmp...))
+			}
+			done = true
+		}
+		out = append(out, buf[c.Offset:c.Offset+int64(len(c.Data))+12]...)
+	}
+	return out, nil
+}
+
+func appendChunk(buf []byte, typ string, data []byte) []byte {
+	var (
+		head = make([]byte, 8)
+		tail = make([]byte, 4)
+		sum  = crc32.NewIEEE()
+	)
+	binary.BigEndian.PutUint32(head, uint32(len(data)))
+	copy(head[4:], typ)
+	sum.Write(head[4:])
+	sum.Write(data)
+	binary.BigEndian.PutUint32(tail, sum.Sum32())
+
+	buf = append(buf, head...)
+	buf = append(buf, data...)
+	return append(buf, tail...)
+}
@@ -0,0 +1,108 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+const embedDate = "2021:03:04 05:06:07"
+
+var embedXMP = []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`)
+
+// embedFiles gives the directories embedded by the tests of the writers.
+func embedFiles() []*File {
+	order := binary.LittleEndian
+	f := NewFile(order, nil,
+		NewString(tiffMake, "NIKON CORPORATION"),
+		NewTag(tiffOrientation, Short, order, 6),
+	)
+	f.SetTag(NewString(0x9003, embedDate), Exif)
+	return []*File{f}
+}
+
+// checkFiles reports the differences between the directories decoded and the
+// ones of embedFiles.
+func checkFiles(t *testing.T, name string, files []*File) {
+	t.Helper()
+	if len(files) != 1 {
+		t.Errorf("%s: want 1 directory, got %d", name, len(files))
+		return
+	}
+	f := files[0]
+	if tag, err := f.GetTag(tiffMake, Tiff); err != nil || tag.String() != "NIKON CORPORATION" {
+		t.Errorf("%s: make mismatched: %q (%v)", name, tag.String(), err)
+	}
+	if tag, err := f.GetTag(0x9003, Exif); err != nil || tag.String() != embedDate {
+		t.Errorf("%s: date mismatched: %q (%v)", name, tag.String(), err)
+	}
+}
+
+func pngImage(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEmbedPNG(t *testing.T) {
+	orig := pngImage(t)
+	buf, err := EmbedPNG(orig, embedFiles(), embedXMP)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// embedding again replaces the chunks of the first time
+	if buf, err = EmbedPNG(buf, embedFiles(), embedXMP); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := png.Decode(bytes.NewReader(buf)); err != nil {
+		t.Fatalf("invalid png: %s", err)
+	}
+	p, err := DecodePNG(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if p.Width != 3 || p.Height != 2 {
+		t.Errorf("size mismatched: want 3x2, got %dx%d", p.Width, p.Height)
+	}
+	checkFiles(t, "png", p.Files)
+	if !bytes.Equal(p.XMP, embedXMP) {
+		t.Errorf("xmp mismatched: want %q, got %q", embedXMP, p.XMP)
+	}
+	var (
+		seen  = make(map[string]int)
+		first = -1
+	)
+	for i, c := range p.Chunks {
+		seen[c.Type]++
+		if c.Type == chunkIDAT && first < 0 {
+			first = i
+		}
+		if (c.Type == chunkEXIF || c.Type == chunkITXT) && first >= 0 {
+			t.Errorf("chunk %s after image data", c.Type)
+		}
+		var (
+			end  = c.Offset + 8 + int64(len(c.Data))
+			want = crc32.ChecksumIEEE(buf[c.Offset+4 : end])
+		)
+		if got := binary.BigEndian.Uint32(buf[end:]); got != want {
+			t.Errorf("chunk %s: checksum mismatched: want %08x, got %08x", c.Type, want, got)
+		}
+	}
+	if seen[chunkEXIF] != 1 || seen[chunkITXT] != 1 {
+		t.Errorf("metadata chunks mismatched: %d eXIf, %d iTXt", seen[chunkEXIF], seen[chunkITXT])
+	}
+
+	strip, err := EmbedPNG(buf, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(strip, orig) {
+		t.Errorf("stripping metadata should give the original file")
+	}
+}
@@ -0,0 +1,150 @@
+package nef
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	noteRetouchHistory = 0x9e
+	noteRetouchInfo    = 0xbb
+)
+
+var retouchOperations = map[uint16]string{
+	0:  "none",
+	3:  "b&w",
+	4:  "sepia",
+	5:  "trim",
+	6:  "small picture",
+	7:  "d-lighting",
+	8:  "red eye",
+	9:  "cyanotype",
+	10: "sky light",
+	11: "warm tone",
+	12: "color custom",
+	13: "image overlay",
+	14: "red intensifier",
+	15: "green intensifier",
+	16: "blue intensifier",
+	17: "cross screen",
+	18: "quick retouch",
+	19: "nef processing",
+	23: "distortion control",
+	25: "fisheye",
+	26: "straighten",
+	29: "perspective control",
+	30: "color outline",
+	31: "soft filter",
+	32: "resize",
+	33: "miniature effect",
+	34: "skin softening",
+	35: "selected color",
+	37: "color sketch",
+	38: "painting",
+	39: "illustration",
+	40: "photo illustration",
+	41: "toy camera effect",
+}
+
+type Retouch uint16
+
+func (r Retouch) String() string {
+	str, ok := retouchOperations[uint16(r)]
+	if !ok {
+		str = fmt.Sprintf("other (%d)", r)
+	}
+	return str
+}
+
+type RetouchInfo struct {
+	Version       string
+	NEFProcessing bool
+	History       []Retouch
+}
+
+func (r RetouchInfo) IsRetouched() bool {
+	return r.NEFProcessing || len(r.History) > 0
+}
+
+func (r RetouchInfo) Operations() []string {
+	str := make([]string, 0, len(r.History)+1)
+	for _, h := range r.History {
+		str = append(str, h.String())
+	}
+	if r.NEFProcessing && !r.hasProcessing() {
+		str = append(str, retouchOperations[19])
+	}
+	return str
+}
+
+func (r RetouchInfo) String() string {
+	if !r.IsRetouched() {
+		return "none"
+	}
+	return strings.Join(r.Operations(), ", ")
+}
+
+func (r RetouchInfo) hasProcessing() bool {
+	for _, h := range r.History {
+		if h == 19 {
+			return true
+		}
+	}
+	return false
+}
+
+// Retouches gives the in-camera edits recorded in the maker notes from the
+// RetouchHistory and RetouchInfo tags. It fails with ErrExist only if none
+// of these tags is present.
+func (f File) Retouches() (RetouchInfo, error) {
+	var r RetouchInfo
+	hist, err := f.GetTag(noteRetouchHistory, Note)
+	if err == nil {
+		if r.History, err = DecodeRetouchHistory(hist); err != nil {
+			return r, err
+		}
+	} else if !errors.Is(err, ErrExist) {
+		return r, err
+	}
+	info, err := f.GetTag(noteRetouchInfo, Note)
+	if err == nil {
+		var i RetouchInfo
+		if i, err = DecodeRetouchInfo(info); err != nil {
+			return r, err
+		}
+		r.Version = i.Version
+		r.NEFProcessing = i.NEFProcessing
+	} else if !errors.Is(err, ErrExist) || len(hist.Raw) == 0 {
+		return r, err
+	}
+	return r, nil
+}
+
+func DecodeRetouchHistory(t Tag) ([]Retouch, error) {
+	if t.Id != noteRetouchHistory || t.Type != Short {
+		return nil, fmt.Errorf("retouch history: %w", ErrFormat)
+	}
+	var (
+		order = t.ByteOrder()
+		list  []Retouch
+	)
+	for i := 0; i < int(t.Count) && (i+1)*2 <= len(t.Raw); i++ {
+		r := order.Uint16(t.Raw[i*2:])
+		if r == 0 {
+			continue
+		}
+		list = append(list, Retouch(r))
+	}
+	return list, nil
+}
+
+func DecodeRetouchInfo(t Tag) (RetouchInfo, error) {
+	var r RetouchInfo
+	if t.Id != noteRetouchInfo || len(t.Raw) < 6 {
+		return r, fmt.Errorf("retouch info: %w", ErrFormat)
+	}
+	r.Version = string(t.Raw[:4])
+	r.NEFProcessing = int8(t.Raw[5]) > 0
+	return r, nil
+}
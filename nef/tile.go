@@ -0,0 +1,140 @@
+package nef
+
+import (
+	"fmt"
+	"image"
+	"sort"
+)
+
+const (
+	TileWidth      = 0x142
+	TileLength     = 0x143
+	TileOffsets    = 0x144
+	TileByteCounts = 0x145
+)
+
+func (f File) IsTiled() bool {
+	return f.Has(TileWidth) && f.Has(TileLength) && f.Has(TileOffsets) && f.Has(TileByteCounts)
+}
+
+// Tiles gives the part of the image covered by each tile, in the order of the
+// TileOffsets tag. Tiles on the right and bottom edges can go beyond the image.
+func (f File) Tiles() []image.Rectangle {
+	if !f.IsTiled() {
+		return nil
+	}
+	var (
+		width, _  = f.get(ImageWidth)
+		height, _ = f.get(ImageLength)
+		tw, _     = f.get(TileWidth)
+		th, _     = f.get(TileLength)
+		offset, _ = f.get(TileOffsets)
+		w         = int(tw.Uint())
+		h         = int(th.Uint())
+	)
+	if w == 0 || h == 0 {
+		return nil
+	}
+	var (
+		across = (int(width.Uint()) + w - 1) / w
+		down   = (int(height.Uint()) + h - 1) / h
+		count  = int(offset.Count)
+		list   = make([]image.Rectangle, 0, count)
+	)
+	for i := 0; i < count && across > 0; i++ {
+		x, y := (i%across)*w, (i/across)%down*h
+		list = append(list, image.Rect(x, y, x+w, y+h))
+	}
+	return list
+}
+
+// Tile gives a view of the i-th tile of the image as an image of its own
+// stored in a single strip. Nothing is read until its Image or Bytes method is
+// called, making it possible to decode large images one tile at a time.
+func (f File) Tile(i int) (*File, error) {
+	if !f.IsTiled() {
+		return nil, ErrImage
+	}
+	var (
+		tw, _     = f.get(TileWidth)
+		th, _     = f.get(TileLength)
+		offset, _ = f.get(TileOffsets)
+		count, _  = f.get(TileByteCounts)
+		offsets   = tagUint64s(offset)
+		counts    = tagUint64s(count)
+	)
+	if i < 0 || i >= len(offsets) || i >= len(counts) {
+		return nil, fmt.Errorf("tile %d: %w", i, ErrExist)
+	}
+	tags := make([]Tag, 0, len(f.tiff))
+	for _, t := range f.tiff {
+		switch t.Id {
+		case TileWidth, TileLength, TileOffsets, TileByteCounts:
+		default:
+			tags = append(tags, t)
+		}
+	}
+	tags = setTag(tags, NewTag(ImageWidth, Long, f.order, tw.Uint64()))
+	tags = setTag(tags, NewTag(ImageLength, Long, f.order, th.Uint64()))
+	tags = setTag(tags, NewTag(StripOffsets, Long8, f.order, offsets[i]))
+	tags = setTag(tags, NewTag(RowsPerStrip, Long, f.order, th.Uint64()))
+	tags = setTag(tags, NewTag(StripByteCounts, Long, f.order, counts[i]))
+
+	t := f
+	t.tiff = tags
+	t.Files = nil
+	return &t, nil
+}
+
+// processTiles assembles the tiles of the image in rows of samples packed as
+// in an uncompressed strip.
+func (f File) processTiles() ([]byte, error) {
+	if err := f.checkChunky(); err != nil {
+		return nil, err
+	}
+	var (
+		width, _  = f.get(ImageWidth)
+		height, _ = f.get(ImageLength)
+		rect      = image.Rect(0, 0, int(width.Uint()), int(height.Uint()))
+		bits      = f.bitsPerSample() * f.samplesPerPixel()
+		stride    = (rect.Dx()*bits + 7) / 8
+		img       = make([]byte, stride*rect.Dy())
+	)
+	for i, r := range f.Tiles() {
+		t, err := f.Tile(i)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := t.processRaw()
+		if err != nil {
+			return nil, err
+		}
+		var (
+			part = r.Intersect(rect)
+			size = (r.Dx()*bits + 7) / 8
+			x    = r.Min.X * bits / 8
+			n    = (part.Dx()*bits + 7) / 8
+		)
+		for y := part.Min.Y; y < part.Max.Y; y++ {
+			row := (y - r.Min.Y) * size
+			if row+n > len(buf) {
+				return nil, fmt.Errorf("tile %d: %w", i, errTruncated)
+			}
+			copy(img[y*stride+x:], buf[row:row+n])
+		}
+	}
+	return img, nil
+}
+
+// setTag replaces or inserts a tag, keeping the list sorted by id.
+func setTag(tags []Tag, t Tag) []Tag {
+	x := sort.Search(len(tags), func(i int) bool { return tags[i].Id >= t.Id })
+	if x < len(tags) && tags[x].Id == t.Id {
+		tags[x] = t
+		return tags
+	}
+	tags = append(tags, Tag{})
+	copy(tags[x+1:], tags[x:])
+	tags[x] = t
+	return tags
+}
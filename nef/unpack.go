@@ -0,0 +1,261 @@
+package nef
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+type Packing uint8
+
+const (
+	// PackMSB is a stream of samples packed from the most significant bit of
+	// each byte, every row starting on a new byte as defined by TIFF.
+	PackMSB Packing = iota
+	// PackLSB is a stream of samples packed from the least significant bit of
+	// each byte as written by some cameras in little endian files.
+	PackLSB
+	// PackWord stores each sample in a 16 bits word using the byte order of
+	// the file.
+	PackWord
+)
+
+func (p Packing) String() string {
+	switch p {
+	case PackMSB:
+		return "packed (msb)"
+	case PackLSB:
+		return "packed (lsb)"
+	case PackWord:
+		return "unpacked (16 bits)"
+	default:
+		return "unknown"
+	}
+}
+
+// Unpacker extracts the samples of an image stored with BitsPerSample bits
+// per sample.
+type Unpacker struct {
+	Bits    int
+	Samples int
+	Order   binary.ByteOrder
+	Packing Packing
+	// Stride is the number of bytes from the start of a row to the start of
+	// the next one. Rows follow each other, starting on a new byte as defined
+	// by TIFF, when it is 0.
+	Stride int
+}
+
+// Unpack gives the samples of an image of width x height pixels. Samples are
+// returned as found in the file, not scaled to 16 bits.
+func (u Unpacker) Unpack(buf []byte, width, height int) ([]uint16, error) {
+	if u.Bits <= 0 || u.Bits > 16 {
+		return nil, fmt.Errorf("%d bits per sample: %w", u.Bits, ErrFormat)
+	}
+	samples := u.Samples
+	if samples <= 0 {
+		samples = 1
+	}
+	var (
+		count = width * samples
+		need  = u.rowSize(count)
+	)
+	stride := need
+	if u.Stride > 0 {
+		if u.Stride < need {
+			return nil, fmt.Errorf("unpack: stride of %d bytes for rows of %d bytes: %w", u.Stride, need, ErrFormat)
+		}
+		stride = u.Stride
+	}
+	if height <= 0 || len(buf) < stride*(height-1)+need {
+		return nil, fmt.Errorf("unpack: %d bytes for %d rows of %d bytes: %w", len(buf), height, stride, errTruncated)
+	}
+	var (
+		list  = make([]uint16, 0, count*height)
+		order = u.Order
+	)
+	if order == nil {
+		order = binary.BigEndian
+	}
+	for j := 0; j < height; j++ {
+		row := buf[j*stride : j*stride+need]
+		switch u.Packing {
+		case PackWord:
+			for i := 0; i < count; i++ {
+				list = append(list, order.Uint16(row[i*2:])&(1<<u.Bits-1))
+			}
+		case PackLSB:
+			var (
+				acc  uint32
+				size int
+				pos  int
+			)
+			for i := 0; i < count; i++ {
+				for size < u.Bits {
+					acc |= uint32(row[pos]) << size
+					pos++
+					size += 8
+				}
+				list = append(list, uint16(acc&(1<<u.Bits-1)))
+				acc >>= u.Bits
+				size -= u.Bits
+			}
+		default:
+			br := newBitReader(row)
+			for i := 0; i < count; i++ {
+				list = append(list, uint16(br.bits(uint(u.Bits))))
+			}
+		}
+	}
+	return list, nil
+}
+
+func (u Unpacker) rowSize(count int) int {
+	if u.Packing == PackWord {
+		return count * 2
+	}
+	return (count*u.Bits + 7) / 8
+}
+
+// unpacker gives the unpacker for the samples of the image, size being the
+// number of bytes of the uncompressed samples.
+func (f File) unpacker(size int) Unpacker {
+	u := Unpacker{
+		Bits:    f.bitsPerSample(),
+		Samples: f.samplesPerPixel(),
+		Order:   f.order,
+	}
+	if u.Bits <= 8 {
+		return u
+	}
+	if u.Bits == 16 {
+		u.Packing = PackWord
+		return u
+	}
+	var (
+		width, _  = f.get(ImageWidth)
+		height, _ = f.get(ImageLength)
+		count     = int(width.Uint()) * int(height.Uint()) * u.Samples
+	)
+	if t, err := f.GetTag(noteNEFCompression, Note); err == nil {
+		switch t.Uint() {
+		case NikonUncompressed, NikonUncompressed12, NikonUnpacked12:
+			if size >= count*2 {
+				u.Packing = PackWord
+			}
+			return u
+		case NikonStripedPacked12, NikonPacked12, NikonPacked14:
+			if f.order == binary.LittleEndian {
+				u.Packing = PackLSB
+			}
+			return u
+		}
+	}
+	if size >= count*2 {
+		u.Packing = PackWord
+	}
+	return u
+}
+
+func (f File) unpack() ([]uint16, error) {
+	buf, err := f.processRaw()
+	if err != nil {
+		return nil, err
+	}
+	if f.sampleFormat() == SampleFloat {
+		return f.unpackFloats(buf)
+	}
+	var (
+		width, _  = f.get(ImageWidth)
+		height, _ = f.get(ImageLength)
+	)
+	return f.unpacker(len(buf)).Unpack(buf, int(width.Uint()), int(height.Uint()))
+}
+
+func (f File) decodeUnpacked() (*image.Gray16, error) {
+	if f.samplesPerPixel() != 1 {
+		return nil, fmt.Errorf("raw image: %d samples per pixel: %w", f.samplesPerPixel(), ErrFormat)
+	}
+	list, err := f.unpack()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		width, _  = f.get(ImageWidth)
+		height, _ = f.get(ImageLength)
+		img       = image.NewGray16(image.Rect(0, 0, int(width.Uint()), int(height.Uint())))
+	)
+	for i, v := range list {
+		binary.BigEndian.PutUint16(img.Pix[i*2:], v)
+	}
+	return img, nil
+}
+
+func (f File) samplesPerPixel() int {
+	t, err := f.get(SamplesPerPixel)
+	if err != nil {
+		return 1
+	}
+	return int(t.Uint())
+}
+
+func gray16Image(rect image.Rectangle, list []uint16, bits int, inverted bool) image.Image {
+	var (
+		img  = image.NewGray16(rect)
+		gray color.Gray16
+	)
+	for j := 0; j < rect.Dy(); j++ {
+		for i := 0; i < rect.Dx(); i++ {
+			x := j*rect.Dx() + i
+			if x >= len(list) {
+				return img
+			}
+			gray.Y = scaleSample(list[x], bits)
+			if inverted {
+				gray.Y = 0xffff - gray.Y
+			}
+			img.SetGray16(i, j, gray)
+		}
+	}
+	return img
+}
+
+func rgb64Image(rect image.Rectangle, list []uint16, bits, samples int, planar bool) image.Image {
+	if samples < 3 {
+		samples = 3
+	}
+	var (
+		img   = image.NewRGBA64(rect)
+		plane = rect.Dx() * rect.Dy()
+		step  = samples
+		next  = 1
+		rgb   color.RGBA64
+	)
+	if planar {
+		step, next = 1, plane
+	}
+	for j := 0; j < rect.Dy(); j++ {
+		for i := 0; i < rect.Dx(); i++ {
+			x := (j*rect.Dx() + i) * step
+			if x+2*next >= len(list) {
+				return img
+			}
+			rgb.R = scaleSample(list[x], bits)
+			rgb.G = scaleSample(list[x+next], bits)
+			rgb.B = scaleSample(list[x+2*next], bits)
+			rgb.A = 0xffff
+			img.SetRGBA64(i, j, rgb)
+		}
+	}
+	return img
+}
+
+// scaleSample scales a sample of the given number of bits to 16 bits.
+func scaleSample(v uint16, bits int) uint16 {
+	if bits >= 16 || bits <= 0 {
+		return v
+	}
+	max := uint32(1)<<bits - 1
+	return uint16(uint32(v) * 0xffff / max)
+}
@@ -0,0 +1,87 @@
+package nef
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestUnpack(t *testing.T) {
+	data := []struct {
+		Name   string
+		Unpack Unpacker
+		Buf    []byte
+		Width  int
+		Height int
+		Want   []uint16
+	}{
+		{
+			Name:   "msb-12",
+			Unpack: Unpacker{Bits: 12, Packing: PackMSB},
+			Buf:    []byte{0xab, 0xcd, 0xef, 0x12, 0x34, 0x56},
+			Width:  2,
+			Height: 2,
+			Want:   []uint16{0xabc, 0xdef, 0x123, 0x456},
+		},
+		{
+			Name:   "lsb-12",
+			Unpack: Unpacker{Bits: 12, Packing: PackLSB},
+			Buf:    []byte{0xbc, 0xfa, 0xde},
+			Width:  2,
+			Height: 1,
+			Want:   []uint16{0xabc, 0xdef},
+		},
+		{
+			Name:   "word-14",
+			Unpack: Unpacker{Bits: 14, Packing: PackWord, Order: binary.LittleEndian},
+			Buf:    []byte{0xff, 0x3f, 0x01, 0x00},
+			Width:  2,
+			Height: 1,
+			Want:   []uint16{0x3fff, 0x0001},
+		},
+		{
+			Name:   "stride",
+			Unpack: Unpacker{Bits: 8, Stride: 3},
+			Buf:    []byte{1, 2, 0, 3, 4},
+			Width:  2,
+			Height: 2,
+			Want:   []uint16{1, 2, 3, 4},
+		},
+		{
+			Name:   "trailing",
+			Unpack: Unpacker{Bits: 8},
+			Buf:    []byte{1, 2, 3, 4, 0, 0},
+			Width:  2,
+			Height: 2,
+			Want:   []uint16{1, 2, 3, 4},
+		},
+	}
+	for _, d := range data {
+		got, err := d.Unpack.Unpack(d.Buf, d.Width, d.Height)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if !equalSamples(got, d.Want) {
+			t.Errorf("%s: samples mismatched: want %x, got %x", d.Name, d.Want, got)
+		}
+	}
+}
+
+func TestUnpackTruncated(t *testing.T) {
+	u := Unpacker{Bits: 12}
+	if _, err := u.Unpack([]byte{0xab, 0xcd}, 2, 1); err == nil {
+		t.Errorf("truncated rows should fail")
+	}
+}
+
+func equalSamples(got, want []uint16) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,348 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	VariantTiff = "tiff"
+	VariantNEF  = "nef"
+	VariantNRW  = "nrw"
+	VariantDNG  = "dng"
+	VariantCR2  = "cr2"
+	VariantARW  = "arw"
+	VariantORF  = "orf"
+	VariantRW2  = "rw2"
+	VariantPEF  = "pef"
+)
+
+const (
+	tiffMake = 0x10f
+
+	rw2JpgFromRaw = 0x2e
+
+	sr2Private    = 0xc634
+	sr2SubOffset  = 0x7200
+	sr2SubLength  = 0x7201
+	sr2SubKey     = 0x7221
+	orfCameraInfo = 0x2020
+	orfPreview    = 0x101
+	orfPreviewLen = 0x102
+)
+
+var (
+	// Olympus ORF: IIRO, MMOR and IIRS
+	orfle = []byte{0x52, 0x4f}
+	orfbe = []byte{0x4f, 0x52}
+	orsle = []byte{0x52, 0x53}
+	// Panasonic RW2: IIU
+	rw2le = []byte{0x55, 0x00}
+	// Canon CR2: marker following the header, before the offset of the raw
+	// directory
+	cr2Marker = []byte("CR")
+
+	olympusNote = []byte("OLYMPUS\x00")
+)
+
+// Variant gives the kind of TIFF based file the directory was decoded from.
+func (f File) Variant() string {
+	if f.variant == "" {
+		return VariantTiff
+	}
+	return f.variant
+}
+
+// isMagic reports whether the magic number of a header is the one of a TIFF
+// variant using the given byte order.
+func isMagic(order binary.ByteOrder, magic []byte) bool {
+	if order == binary.LittleEndian {
+		return bytes.Equal(magic, orfle) || bytes.Equal(magic, orsle) || bytes.Equal(magic, rw2le)
+	}
+	return bytes.Equal(magic, orfbe)
+}
+
+// detectVariant finds the kind of file from its header or, for the variants
+// with a regular header, from the maker of the camera. Nikon NRW are told
+// from NEF by their main image stored in JPEG.
+func detectVariant(buf []byte, files []*File) string {
+	if len(buf) < 10 {
+		return VariantTiff
+	}
+	switch magic := buf[2:4]; {
+	case bytes.Equal(magic, orfle) || bytes.Equal(magic, orfbe) || bytes.Equal(magic, orsle):
+		return VariantORF
+	case bytes.Equal(magic, rw2le):
+		return VariantRW2
+	case bytes.Equal(buf[8:10], cr2Marker):
+		return VariantCR2
+	}
+	if len(files) == 0 {
+		return VariantTiff
+	}
+	f := files[0]
+	if f.IsDNG() {
+		return VariantDNG
+	}
+	t, err := f.get(tiffMake)
+	if err != nil {
+		return VariantTiff
+	}
+	switch maker := strings.ToUpper(strings.TrimSpace(t.String())); {
+	case strings.HasPrefix(maker, "NIKON"):
+		if f.compression() == CompressionOJPEG {
+			return VariantNRW
+		}
+		return VariantNEF
+	case strings.HasPrefix(maker, "SONY"):
+		return VariantARW
+	case strings.HasPrefix(maker, "PENTAX"), strings.HasPrefix(maker, "RICOH"):
+		return VariantPEF
+	case strings.HasPrefix(maker, "CANON"):
+		return VariantCR2
+	default:
+		return VariantTiff
+	}
+}
+
+// readVariant marks the directories with the variant of the file and adds, as
+// sub directories of the first one, the directories that are not found by
+// following the chain of directories: the preview of RW2 and ORF and the
+// decrypted directory of Sony. These directories are extras: they are left out
+// when they can not be read, like the rest of the maker notes.
+func readVariant(r io.ReaderAt, files []*File) {
+	head := make([]byte, 10)
+	n, _ := r.ReadAt(head, 0)
+	variant := detectVariant(head[:n], files)
+	var mark func([]*File)
+	mark = func(list []*File) {
+		for _, f := range list {
+			f.variant = variant
+			mark(f.Files)
+		}
+	}
+	mark(files)
+	if len(files) == 0 {
+		return
+	}
+	var (
+		f   = files[0]
+		sub *File
+		err error
+	)
+	switch variant {
+	case VariantRW2:
+		sub, err = f.readRW2Preview()
+	case VariantORF:
+		sub, err = f.readORFPreview()
+	case VariantARW:
+		sub, err = f.readSR2()
+	}
+	if err != nil || sub == nil {
+		return
+	}
+	sub.variant = variant
+	sub.Index = []int{f.Index[0], len(f.Files)}
+	f.Files = append(f.Files, sub)
+}
+
+func (f File) previewFile(start, length uint64) *File {
+	tags := []Tag{
+		NewTag(NewSubfileType, Long, f.order, 1),
+		NewTag(JpegFromRawStart, Long, f.order, start),
+		NewTag(JpegFromRawLength, Long, f.order, length),
+	}
+	return &File{
+		reader: f.reader,
+		order:  f.order,
+		tiff:   tags,
+	}
+}
+
+// readRW2Preview gives the JPEG stored in the JpgFromRaw tag of RW2.
+func (f File) readRW2Preview() (*File, error) {
+	t, err := f.get(rw2JpgFromRaw)
+	if err != nil || t.Size() <= 4 {
+		return nil, nil
+	}
+	return f.previewFile(t.Offset, uint64(t.Size())), nil
+}
+
+// readORFPreview gives the JPEG referenced by the camera settings of the maker
+// notes of Olympus, whose offsets are from the start of the notes.
+func (f File) readORFPreview() (*File, error) {
+	note, err := f.GetTag(Note, Exif)
+	if err != nil || !bytes.HasPrefix(note.Raw, olympusNote) || len(note.Raw) < 16 {
+		return nil, nil
+	}
+	var (
+		base  = note.Offset
+		order = f.order
+	)
+	switch {
+	case bytes.Equal(note.Raw[8:10], little):
+		order = binary.LittleEndian
+	case bytes.Equal(note.Raw[8:10], big):
+		order = binary.BigEndian
+	}
+	tags, err := readTags(f.reader, order, base+12, base, Note, false)
+	if err != nil {
+		return nil, fmt.Errorf("olympus notes: %w", err)
+	}
+	info, ok := findTag(tags, orfCameraInfo)
+	if !ok {
+		return nil, nil
+	}
+	if tags, err = readTags(f.reader, order, base+info.Uint64(), base, Note, false); err != nil {
+		return nil, fmt.Errorf("olympus camera settings: %w", err)
+	}
+	start, ok1 := findTag(tags, orfPreview)
+	length, ok2 := findTag(tags, orfPreviewLen)
+	if !ok1 || !ok2 || length.Uint() == 0 {
+		return nil, nil
+	}
+	return f.previewFile(base+start.Uint64(), length.Uint64()), nil
+}
+
+// readSR2 gives the directory of Sony pointed by the SR2Private directory. It
+// is encrypted with a key stored in SR2Private and its offsets are from the
+// start of the file.
+func (f File) readSR2() (*File, error) {
+	t, err := f.get(sr2Private)
+	if err != nil {
+		return nil, nil
+	}
+	tags, err := readTags(f.reader, f.order, t.Uint64(), 0, Tiff, false)
+	if err != nil {
+		return nil, fmt.Errorf("sr2 private: %w", err)
+	}
+	offset, ok1 := findTag(tags, sr2SubOffset)
+	length, ok2 := findTag(tags, sr2SubLength)
+	key, ok3 := findTag(tags, sr2SubKey)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, nil
+	}
+	at := offset.Uint64()
+	buf, err := ioutil.ReadAll(io.NewSectionReader(f.reader, int64(at), int64(length.Uint64())))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(buf)) < length.Uint64() {
+		return nil, fmt.Errorf("sr2 sub directory: %w", errTruncated)
+	}
+	var k uint32
+	if len(key.Raw) >= 4 {
+		k = f.order.Uint32(key.Raw)
+	}
+	decryptSony(buf, k)
+
+	r := shiftReader{
+		Reader: bytes.NewReader(buf),
+		base:   int64(at),
+	}
+	if tags, err = readTags(r, f.order, at, 0, Tiff, false); err != nil {
+		return nil, fmt.Errorf("sr2 sub directory: %w", err)
+	}
+	sub := File{
+		reader: r,
+		order:  f.order,
+		tiff:   tags,
+	}
+	return &sub, nil
+}
+
+// shiftReader reads a buffer found at the given offset of a file with the
+// offsets of the file.
+type shiftReader struct {
+	*bytes.Reader
+	base int64
+}
+
+func (r shiftReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < r.base {
+		return 0, fmt.Errorf("offset %d before %d: %w", off, r.base, ErrFormat)
+	}
+	return r.Reader.ReadAt(p, off-r.base)
+}
+
+// decryptSony reverts the encryption of Sony: the data, as 32 bits big endian
+// words, are xored with a pad generated from the key.
+func decryptSony(buf []byte, key uint32) {
+	var pad [128]uint32
+	for i := 0; i < 4; i++ {
+		key = key*48828125 + 1
+		pad[i] = key
+	}
+	pad[3] = pad[3]<<1 | (pad[0]^pad[2])>>31
+	for i := 4; i < 127; i++ {
+		pad[i] = (pad[i-4]^pad[i-2])<<1 | (pad[i-3]^pad[i-1])>>31
+	}
+	for i, p := 0, 127; i+4 <= len(buf); i += 4 {
+		p++
+		pad[(p-1)&127] = pad[p&127] ^ pad[(p+64)&127]
+		v := binary.BigEndian.Uint32(buf[i:]) ^ pad[(p-1)&127]
+		binary.BigEndian.PutUint32(buf[i:], v)
+	}
+}
+
+func findTag(tags []Tag, id uint16) (Tag, bool) {
+	for _, t := range tags {
+		if t.Id == id {
+			return t, true
+		}
+	}
+	return Tag{}, false
+}
+
+// isJpegStrip reports whether the strips of the image hold a JPEG preview,
+// stored with the old style JPEG compression like in CR2 and NRW. The
+// lossless JPEG of the raw data of CR2 is not a preview.
+func (f File) isJpegStrip() bool {
+	if f.compression() != CompressionOJPEG || !f.Has(StripOffsets) || !f.Has(StripByteCounts) {
+		return false
+	}
+	var (
+		offset, _ = f.get(StripOffsets)
+		count, _  = f.get(StripByteCounts)
+		offsets   = tagUint64s(offset)
+		counts    = tagUint64s(count)
+	)
+	if len(offsets) == 0 || len(counts) == 0 {
+		return false
+	}
+	size := counts[0]
+	if size > 1<<16 {
+		size = 1 << 16
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(f.reader, int64(offsets[0]), int64(size)), buf); err != nil {
+		return false
+	}
+	return jpegFrame(buf) != markerSOF3
+}
+
+// jpegFrame gives the marker of the frame of a JPEG stream, 0 when not found
+// before the start of the scan.
+func jpegFrame(buf []byte) byte {
+	if len(buf) < 2 || buf[0] != 0xff || buf[1] != markerSOI {
+		return 0
+	}
+	for buf = buf[2:]; len(buf) >= 4 && buf[0] == 0xff; {
+		marker := buf[1]
+		switch {
+		case marker == 0xff:
+			buf = buf[1:]
+			continue
+		case marker == markerSOS || marker == markerEOI:
+			return 0
+		case marker >= markerSOF0 && marker <= 0xcf && marker != markerDHT && marker != 0xc8 && marker != 0xcc:
+			return marker
+		}
+		buf = buf[2+int(binary.BigEndian.Uint16(buf[2:])):]
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeVariantMalformed(t *testing.T) {
+	order := binary.LittleEndian
+	data := []struct {
+		Name    string
+		Magic   []byte
+		Variant string
+		Tags    []Tag
+		Exif    []Tag
+	}{
+		{
+			Name:    "orf",
+			Magic:   orfle,
+			Variant: VariantORF,
+			Exif: []Tag{
+				NewBytes(Note, Undef, []byte("OLYMPUS\x00II\x03\x00\xff\xff\xff\xff")),
+			},
+		},
+		{
+			Name:    "arw",
+			Magic:   magicle,
+			Variant: VariantARW,
+			Tags: []Tag{
+				NewString(tiffMake, "SONY"),
+				NewTag(sr2Private, Long, order, 0xfffffff0),
+			},
+		},
+	}
+	for _, d := range data {
+		f := NewFile(order, nil, append(d.Tags, NewTag(ImageWidth, Short, order, 1))...)
+		for _, e := range d.Exif {
+			f.SetTag(e, Exif)
+		}
+		var buf bytes.Buffer
+		if err := Encode(&buf, []*File{f}); err != nil {
+			t.Errorf("%s: encode: %s", d.Name, err)
+			continue
+		}
+		raw := buf.Bytes()
+		copy(raw[2:], d.Magic)
+
+		files, err := Decode(bytes.NewReader(raw))
+		if err != nil {
+			t.Errorf("%s: malformed extras should be skipped: %s", d.Name, err)
+			continue
+		}
+		if len(files) != 1 || files[0].Variant() != d.Variant {
+			t.Errorf("%s: variant mismatched: want %s", d.Name, d.Variant)
+			continue
+		}
+		if len(files[0].Files) != 0 {
+			t.Errorf("%s: no extra directory expected, got %d", d.Name, len(files[0].Files))
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	chunkVP8X = "VP8X"
+	chunkVP8  = "VP8 "
+	chunkVP8L = "VP8L"
+	chunkALPH = "ALPH"
+	chunkICCP = "ICCP"
+	chunkWEXF = "EXIF"
+	chunkWXMP = "XMP "
+)
+
+// flags of the VP8X chunk
+const (
+	webpAnimation = 1 << 1
+	webpXMP       = 1 << 2
+	webpExif      = 1 << 3
+	webpAlpha     = 1 << 4
+	webpICC       = 1 << 5
+)
+
+var (
+	riffMagic = []byte("RIFF")
+	webpMagic = []byte("WEBP")
+)
+
+// WebP holds the metadata of a WebP file: the directories of its EXIF chunk,
+// its XMP packet and its ICC profile.
+type WebP struct {
+	Width  int
+	Height int
+
+	Files  []*File
+	XMP    []byte
+	ICC    []byte
+	Chunks []Chunk
+}
+
+func IsWebP(buf []byte) bool {
+	return len(buf) >= 12 && bytes.HasPrefix(buf, riffMagic) && bytes.Equal(buf[8:12], webpMagic)
+}
+
+func DecodeWebPFile(file string) (*WebP, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return DecodeWebP(r)
+}
+
+func DecodeWebP(r io.Reader) (*WebP, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeWebP(buf)
+}
+
+func decodeWebP(buf []byte) (*WebP, error) {
+	chunks, err := readRiff(buf)
+	if err != nil {
+		return nil, err
+	}
+	w := WebP{
+		Chunks: chunks,
+	}
+	for _, c := range chunks {
+		if err := w.readChunk(c); err != nil {
+			return nil, err
+		}
+	}
+	return &w, nil
+}
+
+// readRiff gives the chunks of a WebP file. Chunks are padded to an even
+// size.
+func readRiff(buf []byte) ([]Chunk, error) {
+	if !IsWebP(buf) {
+		return nil, fmt.Errorf("webp: missing signature: %w", ErrFormat)
+	}
+	end := 8 + int(binary.LittleEndian.Uint32(buf[4:]))
+	if end > len(buf) {
+		end = len(buf)
+	}
+	var (
+		list []Chunk
+		pos  = 12
+	)
+	for pos+8 <= end {
+		var (
+			size = int(binary.LittleEndian.Uint32(buf[pos+4:]))
+			c    = Chunk{
+				Type:   string(buf[pos : pos+4]),
+				Offset: int64(pos),
+			}
+		)
+		if size < 0 || pos+8+size > end {
+			return nil, fmt.Errorf("webp: chunk %s: %w", c.Type, errTruncated)
+		}
+		c.Data = buf[pos+8 : pos+8+size]
+		list = append(list, c)
+		pos += 8 + size + size&1
+	}
+	return list, nil
+}
+
+func (w *WebP) readChunk(c Chunk) error {
+	switch c.Type {
+	case chunkVP8X:
+		if len(c.Data) < 10 {
+			return fmt.Errorf("webp: %s: %w", c.Type, errTruncated)
+		}
+		w.Width = int(uint24(c.Data[4:])) + 1
+		w.Height = int(uint24(c.Data[7:])) + 1
+	case chunkVP8:
+		if len(c.Data) < 10 || w.Width > 0 {
+			break
+		}
+		w.Width = int(binary.LittleEndian.Uint16(c.Data[6:]) & 0x3fff)
+		w.Height = int(binary.LittleEndian.Uint16(c.Data[8:]) & 0x3fff)
+	case chunkVP8L:
+		if len(c.Data) < 5 || w.Width > 0 {
+			break
+		}
+		bits := binary.LittleEndian.Uint32(c.Data[1:])
+		w.Width = int(bits&0x3fff) + 1
+		w.Height = int((bits>>14)&0x3fff) + 1
+	case chunkWEXF:
+		files, err := decodeTiff(bytes.TrimPrefix(c.Data, exifHeader))
+		if err != nil {
+			return fmt.Errorf("webp: exif: %w", err)
+		}
+		w.Files = files
+	case chunkWXMP:
+		w.XMP = c.Data
+	case chunkICCP:
+		w.ICC = c.Data
+	}
+	return nil
+}
+
+func uint24(buf []byte) uint32 {
+	return uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16
+}
+
+// EmbedWebP gives a copy of a WebP file with the directories given in its
+// EXIF chunk and the XMP packet given in its XMP chunk. Like EmbedPNG, the
+// existing chunks are removed so giving no directories or no packet strips
+// them from the file. A VP8X chunk, required by the metadata, is added to the
+// files in the simple format, giving an error when the size of their image is
+// unknown.
+func EmbedWebP(buf []byte, files []*File, xmp []byte) ([]byte, error) {
+	w, err := decodeWebP(buf)
+	if err != nil {
+		return nil, err
+	}
+	var exif []byte
+	if len(files) > 0 {
+		var tmp bytes.Buffer
+		if err := Encode(&tmp, files); err != nil {
+			return nil, err
+		}
+		exif = tmp.Bytes()
+	}
+	var (
+		out   = make([]byte, 12, len(buf)+len(exif)+len(xmp)+32)
+		flags byte
+		body  []byte
+	)
+	copy(out, buf[:12])
+	for _, c := range w.Chunks {
+		switch c.Type {
+		case chunkVP8X:
+			flags = c.Data[0]
+			continue
+		case chunkWEXF, chunkWXMP:
+			continue
+		case chunkALPH:
+			flags |= webpAlpha
+		case chunkVP8L:
+			if len(c.Data) >= 5 && c.Data[4]&0x10 != 0 {
+				flags |= webpAlpha
+			}
+		}
+		body = appendRiff(body, c.Type, c.Data)
+	}
+	flags &^= webpExif | webpXMP
+	if len(exif) > 0 {
+		flags |= webpExif
+		body = appendRiff(body, chunkWEXF, exif)
+	}
+	if len(xmp) > 0 {
+		flags |= webpXMP
+		body = appendRiff(body, chunkWXMP, xmp)
+	}
+	if w.Width <= 0 || w.Height <= 0 {
+		if len(exif) > 0 || len(xmp) > 0 {
+			return nil, fmt.Errorf("webp: unknown size of image: %w", ErrFormat)
+		}
+	} else {
+		vp8x := make([]byte, 10)
+		vp8x[0] = flags
+		putUint24(vp8x[4:], uint32(w.Width-1))
+		putUint24(vp8x[7:], uint32(w.Height-1))
+		out = appendRiff(out, chunkVP8X, vp8x)
+	}
+	out = append(out, body...)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+func appendRiff(buf []byte, typ string, data []byte) []byte {
+	head := make([]byte, 8)
+	copy(head, typ)
+	binary.LittleEndian.PutUint32(head[4:], uint32(len(data)))
+	buf = append(buf, head...)
+	buf = append(buf, data...)
+	if len(data)&1 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+func putUint24(buf []byte, v uint32) {
+	buf[0], buf[1], buf[2] = byte(v), byte(v>>8), byte(v>>16)
+}
@@ -0,0 +1,119 @@
+package nef
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// webpFile gives a WebP file made of the given chunks.
+func webpFile(chunks ...Chunk) []byte {
+	buf := append(append([]byte{}, riffMagic...), 0, 0, 0, 0)
+	buf = append(buf, webpMagic...)
+	for _, c := range chunks {
+		buf = appendRiff(buf, c.Type, c.Data)
+	}
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(buf)-8))
+	return buf
+}
+
+func TestEmbedWebP(t *testing.T) {
+	var old bytes.Buffer
+	if err := Encode(&old, []*File{NewFile(binary.LittleEndian, nil, NewString(tiffMake, "OLD"))}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var (
+		vp8  = []byte{0x50, 0x01, 0x00, 0x9d, 0x01, 0x2a, 0x05, 0x00, 0x04, 0x00, 0xff}
+		vp8l = []byte{0x2f, 0x04, 0xc0, 0x00, 0x10, 0xaa}
+		vp8x = []byte{webpICC | webpExif, 0, 0, 0, 4, 0, 0, 3, 0, 0}
+	)
+	data := []struct {
+		Name   string
+		File   []byte
+		Width  int
+		Height int
+		Flags  byte
+	}{
+		{
+			Name:   "vp8",
+			File:   webpFile(Chunk{Type: chunkVP8, Data: vp8}),
+			Width:  5,
+			Height: 4,
+			Flags:  webpExif | webpXMP,
+		},
+		{
+			Name:   "vp8l-alpha",
+			File:   webpFile(Chunk{Type: chunkVP8L, Data: vp8l}),
+			Width:  5,
+			Height: 4,
+			Flags:  webpExif | webpXMP | webpAlpha,
+		},
+		{
+			Name: "extended",
+			File: webpFile(
+				Chunk{Type: chunkVP8X, Data: vp8x},
+				Chunk{Type: chunkICCP, Data: []byte("icc")},
+				Chunk{Type: chunkVP8, Data: vp8},
+				Chunk{Type: chunkWEXF, Data: old.Bytes()},
+			),
+			Width:  5,
+			Height: 4,
+			Flags:  webpExif | webpXMP | webpICC,
+		},
+	}
+	for _, d := range data {
+		buf, err := EmbedWebP(d.File, embedFiles(), embedXMP)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if size := binary.LittleEndian.Uint32(buf[4:]); int(size) != len(buf)-8 {
+			t.Errorf("%s: riff size mismatched: want %d, got %d", d.Name, len(buf)-8, size)
+		}
+		w, err := DecodeWebP(bytes.NewReader(buf))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Name, err)
+			continue
+		}
+		if len(w.Chunks) == 0 || w.Chunks[0].Type != chunkVP8X {
+			t.Errorf("%s: first chunk should be %s", d.Name, chunkVP8X)
+			continue
+		}
+		if flags := w.Chunks[0].Data[0]; flags != d.Flags {
+			t.Errorf("%s: flags mismatched: want %02x, got %02x", d.Name, d.Flags, flags)
+		}
+		if w.Width != d.Width || w.Height != d.Height {
+			t.Errorf("%s: size mismatched: want %dx%d, got %dx%d", d.Name, d.Width, d.Height, w.Width, w.Height)
+		}
+		checkFiles(t, d.Name, w.Files)
+		if !bytes.Equal(w.XMP, embedXMP) {
+			t.Errorf("%s: xmp mismatched: want %q, got %q", d.Name, embedXMP, w.XMP)
+		}
+		var count int
+		for _, c := range w.Chunks {
+			if c.Type == chunkWEXF {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("%s: want 1 %s chunk, got %d", d.Name, chunkWEXF, count)
+		}
+	}
+}
+
+func TestEmbedWebPUnknownSize(t *testing.T) {
+	file := webpFile(Chunk{Type: chunkVP8, Data: []byte{0x50, 0x01, 0x00}})
+	if _, err := EmbedWebP(file, embedFiles(), nil); err == nil {
+		t.Errorf("embedding exif without the size of the image should fail")
+	}
+	if _, err := EmbedWebP(file, nil, embedXMP); err == nil {
+		t.Errorf("embedding xmp without the size of the image should fail")
+	}
+	buf, err := EmbedWebP(file, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(buf, file) {
+		t.Errorf("file without metadata should be left unchanged")
+	}
+}
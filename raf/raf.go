@@ -0,0 +1,276 @@
+package raf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/midbel/exif/nef"
+)
+
+var (
+	ErrFormat   = errors.New("not a raf file")
+	ErrNotFound = errors.New("not found")
+)
+
+var magic = []byte("FUJIFILMCCD-RAW ")
+
+const headerSize = 108
+
+// tags of the records of the CFA header
+const (
+	TagRawSize    = 0x100
+	TagCropOrigin = 0x110
+	TagCropSize   = 0x111
+	TagXTrans     = 0x131
+)
+
+// tags of the private directory of the raw block
+const (
+	fujiIFD    = 0xf000
+	fujiWidth  = 0xf001
+	fujiHeight = 0xf002
+	fujiBits   = 0xf003
+)
+
+const xtransSize = 6
+
+// Header is the header of a RAF file: the identification of the camera and the
+// position of the three parts of the file.
+type Header struct {
+	Version    string
+	CameraId   string
+	Model      string
+	DirVersion string
+
+	JpegOffset uint32
+	JpegLength uint32
+	MetaOffset uint32
+	MetaLength uint32
+	RawOffset  uint32
+	RawLength  uint32
+}
+
+type File struct {
+	Header
+
+	// Width and Height are the size of the sensor data and Crop the part of
+	// it making the image.
+	Width  int
+	Height int
+	Crop   image.Rectangle
+	Bits   int
+	// Records are the records of the CFA header, by tag.
+	Records map[uint16][]byte
+
+	buf []byte
+}
+
+func IsRAF(buf []byte) bool {
+	return bytes.HasPrefix(buf, magic)
+}
+
+func DecodeFile(file string) (*File, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return Decode(r)
+}
+
+func Decode(r io.Reader) (*File, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < headerSize || !IsRAF(buf) {
+		return nil, ErrFormat
+	}
+	f := File{
+		Header:  readHeader(buf),
+		Records: make(map[uint16][]byte),
+		buf:     buf,
+	}
+	if err := f.readRecords(); err != nil {
+		return nil, err
+	}
+	if err := f.readSize(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func readHeader(buf []byte) Header {
+	var (
+		h    Header
+		trim = func(b []byte) string { return strings.TrimRight(string(b), "\x00 ") }
+	)
+	h.Version = trim(buf[16:20])
+	h.CameraId = trim(buf[20:28])
+	h.Model = trim(buf[28:60])
+	h.DirVersion = trim(buf[60:64])
+	h.JpegOffset = binary.BigEndian.Uint32(buf[84:])
+	h.JpegLength = binary.BigEndian.Uint32(buf[88:])
+	h.MetaOffset = binary.BigEndian.Uint32(buf[92:])
+	h.MetaLength = binary.BigEndian.Uint32(buf[96:])
+	h.RawOffset = binary.BigEndian.Uint32(buf[100:])
+	h.RawLength = binary.BigEndian.Uint32(buf[104:])
+	return h
+}
+
+func (f File) part(offset, length uint32) ([]byte, error) {
+	end := uint64(offset) + uint64(length)
+	if length == 0 || end > uint64(len(f.buf)) {
+		return nil, fmt.Errorf("%d bytes at %d: %w", length, offset, io.ErrUnexpectedEOF)
+	}
+	return f.buf[offset:end], nil
+}
+
+// readRecords reads the CFA header: a count followed by records made of a
+// tag, a size and the data, all big endian.
+func (f *File) readRecords() error {
+	if f.MetaLength == 0 {
+		return nil
+	}
+	buf, err := f.part(f.MetaOffset, f.MetaLength)
+	if err != nil {
+		return fmt.Errorf("cfa header: %w", err)
+	}
+	if len(buf) < 4 {
+		return fmt.Errorf("cfa header: %w", io.ErrUnexpectedEOF)
+	}
+	count := binary.BigEndian.Uint32(buf)
+	buf = buf[4:]
+	for i := uint32(0); i < count && len(buf) >= 4; i++ {
+		var (
+			tag  = binary.BigEndian.Uint16(buf)
+			size = int(binary.BigEndian.Uint16(buf[2:]))
+		)
+		if 4+size > len(buf) {
+			return fmt.Errorf("cfa header: record %04x: %w", tag, io.ErrUnexpectedEOF)
+		}
+		f.Records[tag] = buf[4 : 4+size]
+		buf = buf[4+size:]
+	}
+	return nil
+}
+
+// readSize reads the size of the sensor data from the CFA header or, when
+// missing, from the private directory of the raw block.
+func (f *File) readSize() error {
+	if r, ok := f.Records[TagRawSize]; ok && len(r) >= 4 {
+		f.Height = int(binary.BigEndian.Uint16(r))
+		f.Width = int(binary.BigEndian.Uint16(r[2:]))
+	}
+	if raw, err := f.Raw(); err == nil {
+		for _, t := range raw.TagsFor(nef.Tiff) {
+			switch t.Id {
+			case fujiWidth:
+				if f.Width == 0 {
+					f.Width = int(t.Uint())
+				}
+			case fujiHeight:
+				if f.Height == 0 {
+					f.Height = int(t.Uint())
+				}
+			case fujiBits:
+				f.Bits = int(t.Uint())
+			}
+		}
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	f.Crop = image.Rect(0, 0, f.Width, f.Height)
+	if r, ok := f.Records[TagCropOrigin]; ok && len(r) >= 4 {
+		f.Crop.Min.Y = int(binary.BigEndian.Uint16(r))
+		f.Crop.Min.X = int(binary.BigEndian.Uint16(r[2:]))
+	}
+	if r, ok := f.Records[TagCropSize]; ok && len(r) >= 4 {
+		f.Crop.Max.Y = f.Crop.Min.Y + int(binary.BigEndian.Uint16(r))
+		f.Crop.Max.X = f.Crop.Min.X + int(binary.BigEndian.Uint16(r[2:]))
+	}
+	return nil
+}
+
+// Jpeg gives the embedded JPEG, a full size preview of the image.
+func (f File) Jpeg() ([]byte, error) {
+	buf, err := f.part(f.JpegOffset, f.JpegLength)
+	if err != nil {
+		return nil, fmt.Errorf("jpeg: %w", err)
+	}
+	return buf, nil
+}
+
+// Exif gives the directories of the Exif segment of the embedded JPEG.
+func (f File) Exif() ([]*nef.File, error) {
+	buf, err := f.Jpeg()
+	if err != nil {
+		return nil, err
+	}
+	return nef.Decode(bytes.NewReader(buf))
+}
+
+// Raw gives the private directory of the raw block (width, height, bits per
+// sample, offset of the data...). Old cameras store the sensor data as is,
+// without directory.
+func (f File) Raw() (*nef.File, error) {
+	buf, err := f.part(f.RawOffset, f.RawLength)
+	if err != nil {
+		return nil, fmt.Errorf("raw: %w", err)
+	}
+	if !bytes.HasPrefix(buf, []byte("II*\x00")) && !bytes.HasPrefix(buf, []byte("MM\x00*")) {
+		return nil, fmt.Errorf("%w: raw directory", ErrNotFound)
+	}
+	files, err := nef.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("raw: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: raw directory", ErrNotFound)
+	}
+	if !files[0].Has(fujiIFD) {
+		return files[0], nil
+	}
+	return files[0].ReadDirectory(fujiIFD)
+}
+
+// IsXTrans reports whether the sensor has the 6x6 colour filter array of
+// X-Trans.
+func (f File) IsXTrans() bool {
+	return len(f.Records[TagXTrans]) >= xtransSize*xtransSize
+}
+
+// CFA gives the layout of the colour filter array. The X-Trans layout of the
+// CFA header is stored from its last photosite. Bayer layouts are taken from
+// the Exif of the embedded JPEG.
+func (f File) CFA() (nef.CFA, error) {
+	if f.IsXTrans() {
+		var (
+			r = f.Records[TagXTrans]
+			c = nef.CFA{
+				Width:  xtransSize,
+				Height: xtransSize,
+				Colors: make([]uint8, xtransSize*xtransSize),
+			}
+		)
+		for i := range c.Colors {
+			c.Colors[len(c.Colors)-1-i] = r[i] & 0x3
+		}
+		return c, nil
+	}
+	files, err := f.Exif()
+	if err != nil {
+		return nef.CFA{}, err
+	}
+	if len(files) == 0 {
+		return nef.CFA{}, fmt.Errorf("%w: cfa pattern", ErrNotFound)
+	}
+	return files[0].CFA()
+}